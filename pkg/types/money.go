@@ -0,0 +1,130 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrOverflow = errors.New("amount of money overflows")
+var ErrBadMoney = errors.New("bad amount of money")
+
+// Add returns m + n, or ErrOverflow if the sum does not fit in Money.
+func (m Money) Add(n Money) (Money, error) {
+	sum := m + n
+	if (n > 0 && sum < m) || (n < 0 && sum > m) {
+		return 0, ErrOverflow
+	}
+	return sum, nil
+}
+
+// Sub returns m - n, or ErrOverflow if the difference does not fit in Money.
+func (m Money) Sub(n Money) (Money, error) {
+	difference := m - n
+	if (n > 0 && difference > m) || (n < 0 && difference < m) {
+		return 0, ErrOverflow
+	}
+	return difference, nil
+}
+
+// Mul returns m * n, or ErrOverflow if the product does not fit in Money.
+func (m Money) Mul(n int64) (Money, error) {
+	if m == 0 || n == 0 {
+		return 0, nil
+	}
+	product := m * Money(n)
+	if product/Money(n) != m || (n == -1 && m == math.MinInt64) {
+		return 0, ErrOverflow
+	}
+	return product, nil
+}
+
+// decimalDigits is how many digits follow the decimal point of Money written
+// without a currency.
+const decimalDigits = 2
+
+// Decimal writes m in whole units with two digits after the point, e.g. 1234
+// as "12.34". Currency.Format writes it with the digits of a currency.
+func (m Money) Decimal() string {
+	return formatDecimal(m, decimalDigits)
+}
+
+// ParseMoney reads an amount written by Decimal, such as "12.34", "-0.5" or
+// "12". More than two digits after the point give ErrBadMoney and amounts
+// that do not fit in Money give ErrOverflow.
+func ParseMoney(s string) (Money, error) {
+	return parseDecimal(s, decimalDigits)
+}
+
+// Parse is ParseMoney for an amount in c, written like Format does.
+func (c Currency) Parse(s string) (Money, error) {
+	digits, ok := c.MinorUnits()
+	if !ok {
+		digits = decimalDigits
+	}
+	return parseDecimal(s, digits)
+}
+
+// formatDecimal writes amount with digits digits after the point.
+func formatDecimal(amount Money, digits int) string {
+	sign := ""
+	abs := uint64(amount)
+	if amount < 0 {
+		sign = "-"
+		abs = uint64(-amount)
+	}
+	text := strconv.FormatUint(abs, 10)
+	if digits == 0 {
+		return sign + text
+	}
+	if len(text) <= digits {
+		text = strings.Repeat("0", digits-len(text)+1) + text
+	}
+	point := len(text) - digits
+	return sign + text[:point] + "." + text[point:]
+}
+
+// parseDecimal reads an amount written by formatDecimal with digits digits.
+func parseDecimal(s string, digits int) (Money, error) {
+	text := s
+	negative := strings.HasPrefix(text, "-")
+	if negative {
+		text = text[1:]
+	}
+	whole, fraction := text, ""
+	if point := strings.IndexByte(text, '.'); point >= 0 {
+		whole, fraction = text[:point], text[point+1:]
+		if fraction == "" {
+			return 0, fmt.Errorf("%w: %q", ErrBadMoney, s)
+		}
+	}
+	if !onlyDigits(whole) || !onlyDigits(fraction) || whole == "" || len(fraction) > digits {
+		return 0, fmt.Errorf("%w: %q", ErrBadMoney, s)
+	}
+
+	abs, err := strconv.ParseUint(whole+fraction+strings.Repeat("0", digits-len(fraction)), 10, 64)
+	if err != nil {
+		return 0, ErrOverflow
+	}
+	if negative {
+		if abs > 1<<63 {
+			return 0, ErrOverflow
+		}
+		return Money(-int64(abs)), nil
+	}
+	if abs > math.MaxInt64 {
+		return 0, ErrOverflow
+	}
+	return Money(abs), nil
+}
+
+func onlyDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
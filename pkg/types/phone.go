@@ -0,0 +1,61 @@
+package types
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrBadPhone = errors.New("malformed phone number")
+
+// Separators people write phone numbers with, dropped by ParsePhone.
+const phoneSeparators = " -().\t"
+
+// ParsePhone reads a phone number and returns it in E.164 form: "+", the
+// country code and the national number, 15 digits at most. Spaces, dashes,
+// dots and brackets are dropped.
+//
+// Numbers starting with "+" or "00" are international. Other numbers are
+// national ones in the country with countryCode, with a leading trunk "0" or
+// not, unless they start with countryCode and have more than 10 digits,
+// which is taken as an international number missing its "+". So with
+// countryCode "992" the numbers "+992 92 958 2003", "992929582003",
+// "0929582003" and "929582003" all read as "+992929582003".
+//
+// Other characters, numbers of fewer than 8 or more than 15 digits and
+// national numbers without a countryCode of digits give ErrBadPhone.
+func ParsePhone(text string, countryCode string) (Phone, error) {
+	international := false
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "+") {
+		international = true
+		text = text[1:]
+	}
+
+	digits := strings.Builder{}
+	for _, r := range text {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case strings.ContainsRune(phoneSeparators, r):
+		default:
+			return "", ErrBadPhone
+		}
+	}
+	number := digits.String()
+
+	switch {
+	case international:
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case countryCode == "" || !onlyDigits(countryCode):
+		return "", ErrBadPhone
+	case strings.HasPrefix(number, countryCode) && len(number) > 10:
+	default:
+		number = countryCode + strings.TrimPrefix(number, "0")
+	}
+
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrBadPhone
+	}
+	return Phone("+" + number), nil
+}
@@ -1,56 +1,321 @@
 package types
 
+import "time"
+
 // Money presents the amount of money in minimum units (cents, penny, dirams and others)
 type Money int64
 
+// Currency presents an ISO 4217 currency code, e.g. "TJS".
+type Currency string
+
+// Predefined currencies. Accounts and payments recorded before there were
+// currencies are in DefaultCurrency.
+const (
+	CurrencyTJS Currency = "TJS"
+	CurrencyUSD Currency = "USD"
+	CurrencyEUR Currency = "EUR"
+	CurrencyRUB Currency = "RUB"
+	CurrencyJPY Currency = "JPY"
+	CurrencyKWD Currency = "KWD"
+
+	DefaultCurrency = CurrencyTJS
+)
+
+// minorUnits holds how many digits of each currency follow the decimal point.
+var minorUnits = map[Currency]int{
+	CurrencyTJS: 2,
+	CurrencyUSD: 2,
+	CurrencyEUR: 2,
+	CurrencyRUB: 2,
+	CurrencyJPY: 0,
+	CurrencyKWD: 3,
+}
+
+// MinorUnits returns how many digits of the currency follow the decimal
+// point, so that Money in it counts units of 10^-MinorUnits. ok is false for
+// a currency that is not predefined.
+func (c Currency) MinorUnits() (digits int, ok bool) {
+	digits, ok = minorUnits[c]
+	return digits, ok
+}
+
+// Known reports whether c is one of the predefined currencies.
+func (c Currency) Known() bool {
+	_, ok := minorUnits[c]
+	return ok
+}
+
+// Format writes amount in whole units of c, e.g. 1234 TJS as "12.34" and
+// 1234 JPY as "1234". Unknown currencies are written with two digits after
+// the point.
+func (c Currency) Format(amount Money) string {
+	digits, ok := c.MinorUnits()
+	if !ok {
+		digits = decimalDigits
+	}
+	return formatDecimal(amount, digits)
+}
+
 // PaymentCategory presents the category in which the payment was made(auto, pharmacy, restaraunts and others.)
 type PaymentCategory string
 
+// Rate presents an exchange rate: how many units of one currency a unit of
+// another is worth, written as a decimal such as "10.93" or a fraction such
+// as "100/1093". The empty Rate means no conversion.
+type Rate string
+
 // Predefined payment categories
-const(
+const (
 	PaymentCategoryAuto PaymentCategory = "Auto"
-	PaymentCategoryFun PaymentCategory = "Fun"
-	PaymentCategoryIT PaymentCategory = "IT"
+	PaymentCategoryFun  PaymentCategory = "Fun"
+	PaymentCategoryIT   PaymentCategory = "IT"
 	PaymentCategoryFood PaymentCategory = "Food"
 )
 
 // PaymentStatus presents the status of the payment
 type PaymentStatus string
 
-// Predefined payment statuses
+// Predefined payment statuses. A payment starts INPROGRESS and ends either OK,
+// once completed, or FAIL, once rejected.
 const (
-	PaymentStatusOk PaymentStatus = "OK"
-	PaymentStatusFail PaymentStatus = "FAIL"
+	PaymentStatusOk         PaymentStatus = "OK"
+	PaymentStatusFail       PaymentStatus = "FAIL"
 	PaymentStatusInProgress PaymentStatus = "INPROGRESS"
 )
 
-// Payment presents information about payment
+// CanBecome reports whether a payment in status s may move to status next.
+func (s PaymentStatus) CanBecome(next PaymentStatus) bool {
+	return s == PaymentStatusInProgress && (next == PaymentStatusOk || next == PaymentStatusFail)
+}
+
+// Final reports whether a payment in status s can not change any more.
+func (s PaymentStatus) Final() bool {
+	return s == PaymentStatusOk || s == PaymentStatusFail
+}
+
+// Payment presents information about payment. Created and Updated are zero
+// for payments made before they were recorded. Refunds are stored on their
+// own and only filled in by exports of the account history.
+//
+// Amount is always in the currency of the account. A payment made in another
+// currency keeps what was paid in OriginalAmount and OriginalCurrency and the
+// Rate it was converted at; the others leave them empty.
+//
+// Fees were charged on top of Amount, in the same currency, and went to the
+// ledger account FeeAccount.
 type Payment struct {
-	ID string
-	AccountID int64
-	Amount Money
-	Category PaymentCategory
-	Status PaymentStatus
+	ID        string          `json:"id"`
+	AccountID int64           `json:"account_id"`
+	Amount    Money           `json:"amount"`
+	Category  PaymentCategory `json:"category"`
+	Status    PaymentStatus   `json:"status"`
+	Created   time.Time       `json:"created"`
+	Updated   time.Time       `json:"updated"`
+	Currency  Currency        `json:"currency"`
+	Refunds   []Refund        `json:"refunds,omitempty"`
+
+	OriginalAmount   Money    `json:"original_amount,omitempty"`
+	OriginalCurrency Currency `json:"original_currency,omitempty"`
+	Rate             Rate     `json:"rate,omitempty"`
+
+	Fees       []Fee `json:"fees,omitempty"`
+	FeeAccount int64 `json:"fee_account,omitempty"`
+}
+
+// Fee presents one fee charged for a payment, named after the rule that
+// charged it.
+type Fee struct {
+	Name   string `json:"name"`
+	Amount Money  `json:"amount"`
 }
+
+// Refund presents part of a payment given back to its account. A payment may
+// have several refunds, adding up to at most its Amount.
+type Refund struct {
+	ID        string    `json:"id"`
+	PaymentID string    `json:"payment_id"`
+	AccountID int64     `json:"account_id"`
+	Amount    Money     `json:"amount"`
+	Created   time.Time `json:"created"`
+}
+
 // Phone presents a phone number
 type Phone string
 
-// Account presents information about the user's account
+// AccountStatus presents the status of an account
+type AccountStatus string
+
+// Predefined account statuses. Accounts start ACTIVE, and only active ones
+// can pay, be paid into or take part in transfers. FROZEN is meant for holds
+// the owner asked for and BLOCKED for the ones put by the wallet, on a
+// compromised account for example. A CLOSED account stays closed.
+const (
+	AccountStatusActive  AccountStatus = "ACTIVE"
+	AccountStatusFrozen  AccountStatus = "FROZEN"
+	AccountStatusBlocked AccountStatus = "BLOCKED"
+	AccountStatusClosed  AccountStatus = "CLOSED"
+)
+
+// Known reports whether s is one of the predefined account statuses.
+func (s AccountStatus) Known() bool {
+	switch s {
+	case AccountStatusActive, AccountStatusFrozen, AccountStatusBlocked, AccountStatusClosed:
+		return true
+	}
+	return false
+}
+
+// CanBecome reports whether an account in status s may move to status next:
+// any status but CLOSED may move to any other one.
+func (s AccountStatus) CanBecome(next AccountStatus) bool {
+	return s != AccountStatusClosed && next != s && next.Known()
+}
+
+// Account presents information about the user's account. Its balance and
+// payments are in its Currency. Limits are nil for accounts held to the
+// default limits of the wallet. Overdraft is how far below zero the balance
+// may go; accounts start without any.
+//
+// The Profile of the owner is empty until it is filled in. Created is zero
+// for accounts registered before it was recorded.
 type Account struct {
-	ID int64
-	Phone Phone
-	Balance Money
+	ID        int64         `json:"id"`
+	Phone     Phone         `json:"phone"`
+	Balance   Money         `json:"balance"`
+	Currency  Currency      `json:"currency"`
+	Limits    *Limits       `json:"limits,omitempty"`
+	Overdraft Money         `json:"overdraft,omitempty"`
+	Status    AccountStatus `json:"status"`
+	Profile
+	Created time.Time `json:"created"`
+}
+
+// Profile presents the owner of an account. Locale is a language tag such
+// as "tg" or "ru-RU".
+type Profile struct {
+	Name   string `json:"name,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Locale string `json:"locale,omitempty"`
+}
+
+// Limits presents how much an account may pay and hold, in its currency. A
+// zero amount means no limit.
+type Limits struct {
+	MaxPayment Money           `json:"max_payment,omitempty"`
+	MaxBalance Money           `json:"max_balance,omitempty"`
+	Spending   []SpendingLimit `json:"spending,omitempty"`
+}
+
+// LimitPeriod presents the period a spending limit is counted over
+type LimitPeriod string
+
+// Predefined limit periods: a calendar day and a calendar month.
+const (
+	LimitPeriodDay   LimitPeriod = "DAY"
+	LimitPeriodMonth LimitPeriod = "MONTH"
+)
+
+// SpendingLimit presents how much may be paid in a Category during a Period.
+// The empty Category limits the payments of all categories together.
+type SpendingLimit struct {
+	Period   LimitPeriod     `json:"period"`
+	Category PaymentCategory `json:"category,omitempty"`
+	Amount   Money           `json:"amount"`
 }
 
 // Favorite presents information about Favorite payment
 type Favorite struct {
-	ID string
-	AccountID int64
-	Name string
-	Amount Money
-	Category PaymentCategory
+	ID        string          `json:"id"`
+	AccountID int64           `json:"account_id"`
+	Name      string          `json:"name"`
+	Amount    Money           `json:"amount"`
+	Category  PaymentCategory `json:"category"`
+}
+
+// Posting presents one movement of money in the ledger: Amount is debited
+// from one ledger account and credited to another. Ledger accounts are
+// account IDs or system accounts with negative IDs. A credit adds to the
+// balance of an account and a debit takes from it.
+type Posting struct {
+	ID        string    `json:"id"`
+	Debit     int64     `json:"debit"`
+	Credit    int64     `json:"credit"`
+	Amount    Money     `json:"amount"`
+	Reference string    `json:"reference"`
+	Created   time.Time `json:"created"`
+}
+
+// Deposit presents money put into an account. Source and Reference are
+// optional: where the money came from (a card, a terminal and so on) and the
+// ID the source gave it.
+type Deposit struct {
+	ID        string    `json:"id"`
+	AccountID int64     `json:"account_id"`
+	Amount    Money     `json:"amount"`
+	Source    string    `json:"source"`
+	Reference string    `json:"reference"`
+	Created   time.Time `json:"created"`
+}
+
+// Transfer presents money moved from one account to another. Its Status is
+// PaymentStatusOk once made and PaymentStatusFail once rejected. Amount is in
+// the currency of the sender and ToAmount in the one of the recipient; they
+// differ only for transfers across currencies, which keep the Rate used.
+type Transfer struct {
+	ID            string        `json:"id"`
+	FromAccountID int64         `json:"from_account_id"`
+	ToAccountID   int64         `json:"to_account_id"`
+	Amount        Money         `json:"amount"`
+	Status        PaymentStatus `json:"status"`
+	Created       time.Time     `json:"created"`
+	ToAmount      Money         `json:"to_amount"`
+	Rate          Rate          `json:"rate,omitempty"`
+}
+
+// IdempotencyKey presents a key a client sent with a request. A retry of the
+// request with the same key gets back the record made by the first one (the
+// payment or deposit with RecordID) instead of making another. Keys belong to
+// an account, and Request holds the parameters the key was first used with.
+type IdempotencyKey struct {
+	Key       string    `json:"key"`
+	AccountID int64     `json:"account_id"`
+	Operation string    `json:"operation"`
+	Request   string    `json:"request"`
+	RecordID  string    `json:"record_id"`
+	Created   time.Time `json:"created"`
 }
+
+// TransactionKind presents what a transaction of an account statement is
+type TransactionKind string
+
+// Predefined transaction kinds
+const (
+	TransactionDeposit    TransactionKind = "DEPOSIT"
+	TransactionPayment    TransactionKind = "PAYMENT"
+	TransactionRefund     TransactionKind = "REFUND"
+	TransactionTransfer   TransactionKind = "TRANSFER"
+	TransactionAdjustment TransactionKind = "ADJUSTMENT"
+	TransactionFee        TransactionKind = "FEE"
+)
+
+// Transaction presents one line of an account statement. Amount is positive
+// for money coming into the account and negative for money leaving it, and
+// Balance is the balance of the account right after the transaction. ID is
+// the ID of the deposit, payment, refund or transfer behind it, if any; a fee
+// has the one of what it was charged for.
+type Transaction struct {
+	ID        string          `json:"id"`
+	AccountID int64           `json:"account_id"`
+	Kind      TransactionKind `json:"kind"`
+	Amount    Money           `json:"amount"`
+	Balance   Money           `json:"balance"`
+	Created   time.Time       `json:"created"`
+}
+
+// Progress presents the result of one part of a long sum. Err is set, and
+// Result zero, if the part could not be summed.
 type Progress struct {
 	Part   int
 	Result Money
-}
\ No newline at end of file
+	Err    error
+}
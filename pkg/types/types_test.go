@@ -0,0 +1,129 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCurrency_Format(t *testing.T) {
+	tests := []struct {
+		currency Currency
+		amount   Money
+		want     string
+	}{
+		{CurrencyTJS, 1234, "12.34"},
+		{CurrencyTJS, 5, "0.05"},
+		{CurrencyTJS, -1234, "-12.34"},
+		{CurrencyJPY, 1234, "1234"},
+		{CurrencyKWD, 1234, "1.234"},
+		{"XXX", 100, "1.00"},
+	}
+	for _, tt := range tests {
+		got := tt.currency.Format(tt.amount)
+		if got != tt.want {
+			t.Errorf("%v.Format(%v) = %q, want %q", tt.currency, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestMoney_checked(t *testing.T) {
+	const max, min = Money(math.MaxInt64), Money(math.MinInt64)
+	tests := []struct {
+		name string
+		got  func() (Money, error)
+		want Money
+		err  error
+	}{
+		{"1+2", func() (Money, error) { return Money(1).Add(2) }, 3, nil},
+		{"max+1", func() (Money, error) { return max.Add(1) }, 0, ErrOverflow},
+		{"min+-1", func() (Money, error) { return min.Add(-1) }, 0, ErrOverflow},
+		{"1-2", func() (Money, error) { return Money(1).Sub(2) }, -1, nil},
+		{"min-1", func() (Money, error) { return min.Sub(1) }, 0, ErrOverflow},
+		{"0-min", func() (Money, error) { return Money(0).Sub(min) }, 0, ErrOverflow},
+		{"3*-4", func() (Money, error) { return Money(3).Mul(-4) }, -12, nil},
+		{"max*2", func() (Money, error) { return max.Mul(2) }, 0, ErrOverflow},
+		{"min*-1", func() (Money, error) { return min.Mul(-1) }, 0, ErrOverflow},
+		{"-1*min", func() (Money, error) { return Money(-1).Mul(math.MinInt64) }, 0, ErrOverflow},
+	}
+	for _, tt := range tests {
+		got, err := tt.got()
+		if got != tt.want || err != tt.err {
+			t.Errorf("%v = %v, %v, want %v, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		text string
+		want Money
+		err  error
+	}{
+		{"12.34", 1234, nil},
+		{"12", 1200, nil},
+		{"0.5", 50, nil},
+		{"-0.05", -5, nil},
+		{"92233720368547758.07", math.MaxInt64, nil},
+		{"-92233720368547758.08", math.MinInt64, nil},
+		{"92233720368547758.08", 0, ErrOverflow},
+		{"12.345", 0, ErrBadMoney},
+		{"12.", 0, ErrBadMoney},
+		{".5", 0, ErrBadMoney},
+		{"1,5", 0, ErrBadMoney},
+		{"", 0, ErrBadMoney},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.text)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("ParseMoney(%q) = %v, %v, want %v, %v", tt.text, got, err, tt.want, tt.err)
+		}
+	}
+
+	for _, m := range []Money{0, 5, -5, 1234, math.MaxInt64, math.MinInt64} {
+		got, err := ParseMoney(m.Decimal())
+		if got != m || err != nil {
+			t.Errorf("ParseMoney(%q) = %v, %v, want %v", m.Decimal(), got, err, m)
+		}
+	}
+
+	got, err := CurrencyKWD.Parse("1.234")
+	if got != 1234 || err != nil {
+		t.Errorf("KWD.Parse(1.234) = %v, %v, want 1234", got, err)
+	}
+}
+
+func TestParsePhone(t *testing.T) {
+	tests := []struct {
+		text string
+		want Phone
+		err  error
+	}{
+		{"+992929582003", "+992929582003", nil},
+		{"992929582003", "+992929582003", nil},
+		{"+992 (92) 958-20-03", "+992929582003", nil},
+		{"00992929582003", "+992929582003", nil},
+		{"0929582003", "+992929582003", nil},
+		{"929582003", "+992929582003", nil},
+		{"+7 912 345 67 89", "+79123456789", nil},
+		{"+992 93 815 1007 ", "+992938151007", nil},
+		{"", "", ErrBadPhone},
+		{"+", "", ErrBadPhone},
+		{"+0992929582003", "", ErrBadPhone},
+		{"+99292958200312345", "", ErrBadPhone},
+		{"1234", "", ErrBadPhone},
+		{"+992 92 958 20 03 ext 5", "", ErrBadPhone},
+		{"phone", "", ErrBadPhone},
+	}
+	for _, tt := range tests {
+		got, err := ParsePhone(tt.text, "992")
+		if got != tt.want || err != tt.err {
+			t.Errorf("ParsePhone(%q) = %q, %v, want %q, %v", tt.text, got, err, tt.want, tt.err)
+		}
+	}
+
+	_, err := ParsePhone("929582003", "")
+	if err != ErrBadPhone {
+		t.Errorf("ParsePhone() without a country code: error = %v, want %v", err, ErrBadPhone)
+	}
+}
@@ -0,0 +1,1179 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// Names of the dump files written by Export and read by Import.
+const (
+	accountsDump  = "accounts.dump"
+	paymentsDump  = "payments.dump"
+	favoritesDump = "favorites.dump"
+	postingsDump  = "postings.dump"
+	depositsDump  = "deposits.dump"
+	transfersDump = "transfers.dump"
+	keysDump      = "keys.dump"
+	refundsDump   = "refunds.dump"
+	manifestDump  = "manifest.dump"
+)
+
+var ErrManifestMismatch = errors.New("dump files do not match the manifest")
+
+// Dump files start with a header naming the format version and the record
+// type, followed by one record per line:
+//
+//	#wallet-dump;2;accounts
+//	id;phone;balance;currency;maxPayment;maxBalance;spending;overdraft;status;name;email;locale;created
+//
+//	#wallet-dump;2;payments
+//	id;accountID;amount;category;status;created;updated;currency;originalAmount;originalCurrency;rate;fees;feeAccount
+//
+//	#wallet-dump;2;favorites
+//	id;accountID;name;amount;category
+//
+//	#wallet-dump;2;postings
+//	id;debit;credit;amount;reference;created
+//
+//	#wallet-dump;2;deposits
+//	id;accountID;amount;source;reference;created
+//
+//	#wallet-dump;2;transfers
+//	id;fromAccountID;toAccountID;amount;status;created;toAmount;rate
+//
+//	#wallet-dump;2;keys
+//	key;accountID;operation;request;recordID;created
+//
+//	#wallet-dump;2;refunds
+//	id;paymentID;accountID;amount;created
+//
+// Times are written in RFC 3339 with nanoseconds. Payments made before their
+// times were recorded have them empty, or have no such fields at all.
+// Accounts and payments recorded before currencies have no currency, which
+// reads as types.DefaultCurrency, and transfers recorded before conversions
+// have no toAmount, which reads as their amount. Accounts held to the
+// default limits have their limit fields empty, accounts recorded before
+// account statuses are active, and accounts without a profile have its
+// fields empty.
+//
+// Fields are separated by ";". A "\", ";", line feed or carriage return in a
+// field is written as "\\", "\;", "\n" or "\r", so any text survives a round
+// trip and a record never spans lines. Readers ignore fields past the ones
+// they know, so new fields can be appended without a new version.
+//
+// Files without a header are version 1, written before headers existed: the
+// same fields, each followed by ";", and no escaping.
+const (
+	dumpMagic   = "#wallet-dump"
+	dumpVersion = 2
+)
+
+// Record types named in dump headers.
+const (
+	accountRecords  = "accounts"
+	paymentRecords  = "payments"
+	favoriteRecords = "favorites"
+	postingRecords  = "postings"
+	depositRecords  = "deposits"
+	transferRecords = "transfers"
+	keyRecords      = "keys"
+	refundRecords   = "refunds"
+)
+
+var ErrDumpVersion = errors.New("unsupported dump version")
+var ErrDumpType = errors.New("dump holds another record type")
+var ErrDumpEscape = errors.New("bad escape sequence in dump")
+var ErrDumpRecord = errors.New("record has too few fields")
+
+// escapeField escapes the separator, line breaks and the escape character.
+func escapeField(field string) string {
+	if !strings.ContainsAny(field, "\\;\n\r") {
+		return field
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(field); i++ {
+		switch field[i] {
+		case '\\':
+			b.WriteString(`\\`)
+		case ';':
+			b.WriteString(`\;`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteByte(field[i])
+		}
+	}
+	return b.String()
+}
+
+// joinFields makes one record line, without the line feed.
+func joinFields(fields []string) string {
+	escaped := make([]string, len(fields))
+	for i, field := range fields {
+		escaped[i] = escapeField(field)
+	}
+	return strings.Join(escaped, ";")
+}
+
+// splitFields splits a record line made by joinFields back into fields.
+func splitFields(line string) ([]string, error) {
+	fields := []string{}
+	field := strings.Builder{}
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ';':
+			fields = append(fields, field.String())
+			field.Reset()
+		case '\\':
+			i++
+			if i == len(line) {
+				return nil, ErrDumpEscape
+			}
+			switch line[i] {
+			case '\\', ';':
+				field.WriteByte(line[i])
+			case 'n':
+				field.WriteByte('\n')
+			case 'r':
+				field.WriteByte('\r')
+			default:
+				return nil, ErrDumpEscape
+			}
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String()), nil
+}
+
+// encodeDump writes the header and the records of a dump file.
+func encodeDump(recordType string, records [][]string) string {
+	b := strings.Builder{}
+	b.WriteString(joinFields([]string{dumpMagic, strconv.Itoa(dumpVersion), recordType}))
+	b.WriteByte('\n')
+	for _, record := range records {
+		b.WriteString(joinFields(record))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// decodeDump calls parse with the fields of every record of a dump file of
+// recordType. Blank lines are skipped. Bad records are reported to o and left
+// out; a bad header leaves out the whole file.
+func decodeDump(o *origin, recordType string, data string, parse func(fields []string) error) {
+	lines := strings.Split(data, "\n")
+
+	// version 1 has no header and no escaping
+	first := 0
+	split := func(line string) ([]string, error) {
+		return strings.Split(line, ";"), nil
+	}
+	if strings.HasPrefix(data, dumpMagic) {
+		err := checkHeader(recordType, strings.TrimSuffix(lines[0], "\r"))
+		if err != nil {
+			o.fail(1, err)
+			return
+		}
+		first = 1
+		split = splitFields
+	}
+
+	for i := first; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		if line == "" {
+			continue
+		}
+		fields, err := split(line)
+		if err == nil {
+			err = parse(fields)
+		}
+		if err != nil {
+			o.fail(i+1, err)
+			continue
+		}
+		o.parsed(i + 1)
+	}
+}
+
+func checkHeader(recordType string, line string) error {
+	header, err := splitFields(line)
+	if err != nil {
+		return err
+	}
+	if len(header) < 3 || header[0] != dumpMagic {
+		return ErrDumpRecord
+	}
+	version, err := strconv.Atoi(header[1])
+	if err != nil || version < 2 || version > dumpVersion {
+		return ErrDumpVersion
+	}
+	if header[2] != recordType {
+		return ErrDumpType
+	}
+	return nil
+}
+
+func accountFields(account *types.Account) []string {
+	return append([]string{
+		strconv.FormatInt(account.ID, 10),
+		string(account.Phone),
+		strconv.FormatInt(int64(account.Balance), 10),
+		string(account.Currency),
+	}, append(limitsFields(account.Limits),
+		formatOptionalMoney(account.Overdraft),
+		string(account.Status),
+		account.Name,
+		account.Email,
+		account.Locale,
+		formatTime(account.Created),
+	)...)
+}
+
+// limitsFields writes the limits of an account as three fields: maxPayment,
+// maxBalance and spending. Nil limits leave them all empty, while set ones
+// write both amounts even if zero. Spending limits are written as
+// "period:category:amount", separated by ",", e.g. "DAY:auto:10000,MONTH::500000".
+func limitsFields(limits *types.Limits) []string {
+	if limits == nil {
+		return []string{"", "", ""}
+	}
+	spending := make([]string, len(limits.Spending))
+	for i, limit := range limits.Spending {
+		spending[i] = fmt.Sprintf("%s:%s:%d", limit.Period, limit.Category, limit.Amount)
+	}
+	return []string{
+		strconv.FormatInt(int64(limits.MaxPayment), 10),
+		strconv.FormatInt(int64(limits.MaxBalance), 10),
+		strings.Join(spending, ","),
+	}
+}
+
+// parseLimits reads the fields written by limitsFields from fields[i:]. A
+// record with them missing or all empty has nil limits.
+func parseLimits(fields []string, i int) (*types.Limits, error) {
+	maxPayment, maxBalance, spending := field(fields, i), field(fields, i+1), field(fields, i+2)
+	if maxPayment == "" && maxBalance == "" && spending == "" {
+		return nil, nil
+	}
+	limits := &types.Limits{}
+	amount, err := strconv.ParseInt(maxPayment, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("max payment: %w", err)
+	}
+	limits.MaxPayment = types.Money(amount)
+	amount, err = strconv.ParseInt(maxBalance, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("max balance: %w", err)
+	}
+	limits.MaxBalance = types.Money(amount)
+	if spending == "" {
+		return limits, nil
+	}
+	for _, text := range strings.Split(spending, ",") {
+		parts := strings.Split(text, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("spending limit %q: %w", text, ErrDumpRecord)
+		}
+		amount, err = strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("spending limit: %w", err)
+		}
+		limits.Spending = append(limits.Spending, types.SpendingLimit{
+			Period:   types.LimitPeriod(parts[0]),
+			Category: types.PaymentCategory(parts[1]),
+			Amount:   types.Money(amount),
+		})
+	}
+	return limits, nil
+}
+
+func accountFromFields(fields []string) (*types.Account, error) {
+	if len(fields) < 3 {
+		return nil, ErrDumpRecord
+	}
+	id, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("id: %w", err)
+	}
+	balance, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("balance: %w", err)
+	}
+	limits, err := parseLimits(fields, 4)
+	if err != nil {
+		return nil, err
+	}
+	var overdraft int64
+	if field(fields, 7) != "" {
+		overdraft, err = strconv.ParseInt(fields[7], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("overdraft: %w", err)
+		}
+	}
+	created, err := parseTime(fields, 12)
+	if err != nil {
+		return nil, fmt.Errorf("created: %w", err)
+	}
+	return &types.Account{
+		ID:        id,
+		Phone:     types.Phone(fields[1]),
+		Balance:   types.Money(balance),
+		Currency:  parseCurrency(fields, 3),
+		Limits:    limits,
+		Overdraft: types.Money(overdraft),
+		Status:    parseAccountStatus(fields, 8),
+		Profile: types.Profile{
+			Name:   field(fields, 9),
+			Email:  field(fields, 10),
+			Locale: field(fields, 11),
+		},
+		Created: created,
+	}, nil
+}
+
+// parseAccountStatus reads an account status field. A missing or empty one,
+// left by files written before account statuses, reads as active.
+func parseAccountStatus(fields []string, i int) types.AccountStatus {
+	if field(fields, i) == "" {
+		return types.AccountStatusActive
+	}
+	return types.AccountStatus(fields[i])
+}
+
+// parseCurrency reads a currency field. A missing or empty one, left by
+// files written before currencies, reads as types.DefaultCurrency.
+func parseCurrency(fields []string, i int) types.Currency {
+	if i >= len(fields) || fields[i] == "" {
+		return types.DefaultCurrency
+	}
+	return types.Currency(fields[i])
+}
+
+// formatTime writes a time field, a zero time as "".
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseTime reads a time field written by formatTime. A missing field (i is
+// past the end of fields) reads as a zero time, like an empty one.
+func parseTime(fields []string, i int) (time.Time, error) {
+	if i >= len(fields) || fields[i] == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, fields[i])
+}
+
+func paymentFields(payment *types.Payment) []string {
+	return []string{
+		payment.ID,
+		strconv.FormatInt(payment.AccountID, 10),
+		strconv.FormatInt(int64(payment.Amount), 10),
+		string(payment.Category),
+		string(payment.Status),
+		formatTime(payment.Created),
+		formatTime(payment.Updated),
+		string(payment.Currency),
+		formatOptionalMoney(payment.OriginalAmount),
+		string(payment.OriginalCurrency),
+		string(payment.Rate),
+		formatFees(payment.Fees),
+		formatOptionalID(payment.FeeAccount),
+	}
+}
+
+// formatFees writes the fee lines of a payment as one field: their names and
+// amounts, in turn, joined like the fields of a record, e.g. "card;150;sms;10".
+func formatFees(fees []types.Fee) string {
+	fields := make([]string, 0, 2*len(fees))
+	for _, fee := range fees {
+		fields = append(fields, fee.Name, strconv.FormatInt(int64(fee.Amount), 10))
+	}
+	return joinFields(fields)
+}
+
+// parseFees reads a field written by formatFees. A missing or empty one has
+// no fees.
+func parseFees(fields []string, i int) ([]types.Fee, error) {
+	if field(fields, i) == "" {
+		return nil, nil
+	}
+	parts, err := splitFields(fields[i])
+	if err != nil {
+		return nil, err
+	}
+	if len(parts)%2 != 0 {
+		return nil, fmt.Errorf("fees: %w", ErrDumpRecord)
+	}
+	fees := make([]types.Fee, 0, len(parts)/2)
+	for j := 0; j < len(parts); j += 2 {
+		amount, err := strconv.ParseInt(parts[j+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fee: %w", err)
+		}
+		fees = append(fees, types.Fee{Name: parts[j], Amount: types.Money(amount)})
+	}
+	return fees, nil
+}
+
+// formatOptionalID writes an account ID that may be unset, zero as "".
+func formatOptionalID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// formatOptionalMoney writes a money field that may be unset, zero as "".
+func formatOptionalMoney(amount types.Money) string {
+	if amount == 0 {
+		return ""
+	}
+	return strconv.FormatInt(int64(amount), 10)
+}
+
+// field returns fields[i], or "" if the record is too short to have it.
+func field(fields []string, i int) string {
+	if i >= len(fields) {
+		return ""
+	}
+	return fields[i]
+}
+
+func paymentFromFields(fields []string) (*types.Payment, error) {
+	if len(fields) < 5 {
+		return nil, ErrDumpRecord
+	}
+	accountID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("account id: %w", err)
+	}
+	amount, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("amount: %w", err)
+	}
+	created, err := parseTime(fields, 5)
+	if err != nil {
+		return nil, fmt.Errorf("created: %w", err)
+	}
+	updated, err := parseTime(fields, 6)
+	if err != nil {
+		return nil, fmt.Errorf("updated: %w", err)
+	}
+	var original int64
+	if field(fields, 8) != "" {
+		original, err = strconv.ParseInt(fields[8], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("original amount: %w", err)
+		}
+	}
+	fees, err := parseFees(fields, 11)
+	if err != nil {
+		return nil, err
+	}
+	var feeAccount int64
+	if field(fields, 12) != "" {
+		feeAccount, err = strconv.ParseInt(fields[12], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("fee account: %w", err)
+		}
+	}
+	return &types.Payment{
+		ID:        fields[0],
+		AccountID: accountID,
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(fields[3]),
+		Status:    types.PaymentStatus(fields[4]),
+		Created:   created,
+		Updated:   updated,
+		Currency:  parseCurrency(fields, 7),
+
+		OriginalAmount:   types.Money(original),
+		OriginalCurrency: types.Currency(field(fields, 9)),
+		Rate:             types.Rate(field(fields, 10)),
+
+		Fees:       fees,
+		FeeAccount: feeAccount,
+	}, nil
+}
+
+func favoriteFields(favorite *types.Favorite) []string {
+	return []string{
+		favorite.ID,
+		strconv.FormatInt(favorite.AccountID, 10),
+		favorite.Name,
+		strconv.FormatInt(int64(favorite.Amount), 10),
+		string(favorite.Category),
+	}
+}
+
+func favoriteFromFields(fields []string) (*types.Favorite, error) {
+	if len(fields) < 5 {
+		return nil, ErrDumpRecord
+	}
+	accountID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("account id: %w", err)
+	}
+	amount, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("amount: %w", err)
+	}
+	return &types.Favorite{
+		ID:        fields[0],
+		AccountID: accountID,
+		Name:      fields[2],
+		Amount:    types.Money(amount),
+		Category:  types.PaymentCategory(fields[4]),
+	}, nil
+}
+
+func postingFields(posting *types.Posting) []string {
+	return []string{
+		posting.ID,
+		strconv.FormatInt(posting.Debit, 10),
+		strconv.FormatInt(posting.Credit, 10),
+		strconv.FormatInt(int64(posting.Amount), 10),
+		posting.Reference,
+		posting.Created.Format(time.RFC3339Nano),
+	}
+}
+
+func postingFromFields(fields []string) (*types.Posting, error) {
+	if len(fields) < 6 {
+		return nil, ErrDumpRecord
+	}
+	debit, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("debit: %w", err)
+	}
+	credit, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("credit: %w", err)
+	}
+	amount, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("amount: %w", err)
+	}
+	created, err := time.Parse(time.RFC3339Nano, fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("created: %w", err)
+	}
+	return &types.Posting{
+		ID:        fields[0],
+		Debit:     debit,
+		Credit:    credit,
+		Amount:    types.Money(amount),
+		Reference: fields[4],
+		Created:   created,
+	}, nil
+}
+
+func depositFields(deposit *types.Deposit) []string {
+	return []string{
+		deposit.ID,
+		strconv.FormatInt(deposit.AccountID, 10),
+		strconv.FormatInt(int64(deposit.Amount), 10),
+		deposit.Source,
+		deposit.Reference,
+		deposit.Created.Format(time.RFC3339Nano),
+	}
+}
+
+func depositFromFields(fields []string) (*types.Deposit, error) {
+	if len(fields) < 6 {
+		return nil, ErrDumpRecord
+	}
+	accountID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("account id: %w", err)
+	}
+	amount, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("amount: %w", err)
+	}
+	created, err := time.Parse(time.RFC3339Nano, fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("created: %w", err)
+	}
+	return &types.Deposit{
+		ID:        fields[0],
+		AccountID: accountID,
+		Amount:    types.Money(amount),
+		Source:    fields[3],
+		Reference: fields[4],
+		Created:   created,
+	}, nil
+}
+
+func transferFields(transfer *types.Transfer) []string {
+	return []string{
+		transfer.ID,
+		strconv.FormatInt(transfer.FromAccountID, 10),
+		strconv.FormatInt(transfer.ToAccountID, 10),
+		strconv.FormatInt(int64(transfer.Amount), 10),
+		string(transfer.Status),
+		transfer.Created.Format(time.RFC3339Nano),
+		strconv.FormatInt(int64(transfer.ToAmount), 10),
+		string(transfer.Rate),
+	}
+}
+
+func transferFromFields(fields []string) (*types.Transfer, error) {
+	if len(fields) < 6 {
+		return nil, ErrDumpRecord
+	}
+	from, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("from account id: %w", err)
+	}
+	to, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("to account id: %w", err)
+	}
+	amount, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("amount: %w", err)
+	}
+	created, err := time.Parse(time.RFC3339Nano, fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("created: %w", err)
+	}
+	toAmount := amount
+	if field(fields, 6) != "" {
+		toAmount, err = strconv.ParseInt(fields[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("to amount: %w", err)
+		}
+	}
+	return &types.Transfer{
+		ID:            fields[0],
+		FromAccountID: from,
+		ToAccountID:   to,
+		Amount:        types.Money(amount),
+		Status:        types.PaymentStatus(fields[4]),
+		Created:       created,
+		ToAmount:      types.Money(toAmount),
+		Rate:          types.Rate(field(fields, 7)),
+	}, nil
+}
+
+func keyFields(key *types.IdempotencyKey) []string {
+	return []string{
+		key.Key,
+		strconv.FormatInt(key.AccountID, 10),
+		key.Operation,
+		key.Request,
+		key.RecordID,
+		key.Created.Format(time.RFC3339Nano),
+	}
+}
+
+func keyFromFields(fields []string) (*types.IdempotencyKey, error) {
+	if len(fields) < 6 {
+		return nil, ErrDumpRecord
+	}
+	accountID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("account id: %w", err)
+	}
+	created, err := time.Parse(time.RFC3339Nano, fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("created: %w", err)
+	}
+	return &types.IdempotencyKey{
+		Key:       fields[0],
+		AccountID: accountID,
+		Operation: fields[2],
+		Request:   fields[3],
+		RecordID:  fields[4],
+		Created:   created,
+	}, nil
+}
+
+func refundFields(refund *types.Refund) []string {
+	return []string{
+		refund.ID,
+		refund.PaymentID,
+		strconv.FormatInt(refund.AccountID, 10),
+		strconv.FormatInt(int64(refund.Amount), 10),
+		refund.Created.Format(time.RFC3339Nano),
+	}
+}
+
+func refundFromFields(fields []string) (*types.Refund, error) {
+	if len(fields) < 5 {
+		return nil, ErrDumpRecord
+	}
+	accountID, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("account id: %w", err)
+	}
+	amount, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("amount: %w", err)
+	}
+	created, err := time.Parse(time.RFC3339Nano, fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("created: %w", err)
+	}
+	return &types.Refund{
+		ID:        fields[0],
+		PaymentID: fields[1],
+		AccountID: accountID,
+		Amount:    types.Money(amount),
+		Created:   created,
+	}, nil
+}
+
+func encodeAccounts(accounts []*types.Account) string {
+	records := make([][]string, len(accounts))
+	for i, account := range accounts {
+		records[i] = accountFields(account)
+	}
+	return encodeDump(accountRecords, records)
+}
+
+func encodePayments(payments []*types.Payment) string {
+	records := make([][]string, len(payments))
+	for i, payment := range payments {
+		records[i] = paymentFields(payment)
+	}
+	return encodeDump(paymentRecords, records)
+}
+
+func encodeFavorites(favorites []*types.Favorite) string {
+	records := make([][]string, len(favorites))
+	for i, favorite := range favorites {
+		records[i] = favoriteFields(favorite)
+	}
+	return encodeDump(favoriteRecords, records)
+}
+
+func encodePostings(postings []*types.Posting) string {
+	records := make([][]string, len(postings))
+	for i, posting := range postings {
+		records[i] = postingFields(posting)
+	}
+	return encodeDump(postingRecords, records)
+}
+
+func encodeDeposits(deposits []*types.Deposit) string {
+	records := make([][]string, len(deposits))
+	for i, deposit := range deposits {
+		records[i] = depositFields(deposit)
+	}
+	return encodeDump(depositRecords, records)
+}
+
+func encodeTransfers(transfers []*types.Transfer) string {
+	records := make([][]string, len(transfers))
+	for i, transfer := range transfers {
+		records[i] = transferFields(transfer)
+	}
+	return encodeDump(transferRecords, records)
+}
+
+func encodeKeys(keys []*types.IdempotencyKey) string {
+	records := make([][]string, len(keys))
+	for i, key := range keys {
+		records[i] = keyFields(key)
+	}
+	return encodeDump(keyRecords, records)
+}
+
+func encodeRefunds(refunds []*types.Refund) string {
+	records := make([][]string, len(refunds))
+	for i, refund := range refunds {
+		records[i] = refundFields(refund)
+	}
+	return encodeDump(refundRecords, records)
+}
+
+// appendAccount and the other append funcs return a parse func for decodeDump
+// and parseCSV collecting the records into the given slice.
+func appendAccount(accounts *[]*types.Account) func(fields []string) error {
+	return func(fields []string) error {
+		account, err := accountFromFields(fields)
+		if err != nil {
+			return err
+		}
+		*accounts = append(*accounts, account)
+		return nil
+	}
+}
+
+func appendPayment(payments *[]*types.Payment) func(fields []string) error {
+	return func(fields []string) error {
+		payment, err := paymentFromFields(fields)
+		if err != nil {
+			return err
+		}
+		*payments = append(*payments, payment)
+		return nil
+	}
+}
+
+func appendFavorite(favorites *[]*types.Favorite) func(fields []string) error {
+	return func(fields []string) error {
+		favorite, err := favoriteFromFields(fields)
+		if err != nil {
+			return err
+		}
+		*favorites = append(*favorites, favorite)
+		return nil
+	}
+}
+
+func appendPosting(postings *[]*types.Posting) func(fields []string) error {
+	return func(fields []string) error {
+		posting, err := postingFromFields(fields)
+		if err != nil {
+			return err
+		}
+		*postings = append(*postings, posting)
+		return nil
+	}
+}
+
+func appendDeposit(deposits *[]*types.Deposit) func(fields []string) error {
+	return func(fields []string) error {
+		deposit, err := depositFromFields(fields)
+		if err != nil {
+			return err
+		}
+		*deposits = append(*deposits, deposit)
+		return nil
+	}
+}
+
+func appendTransfer(transfers *[]*types.Transfer) func(fields []string) error {
+	return func(fields []string) error {
+		transfer, err := transferFromFields(fields)
+		if err != nil {
+			return err
+		}
+		*transfers = append(*transfers, transfer)
+		return nil
+	}
+}
+
+func appendKey(keys *[]*types.IdempotencyKey) func(fields []string) error {
+	return func(fields []string) error {
+		key, err := keyFromFields(fields)
+		if err != nil {
+			return err
+		}
+		*keys = append(*keys, key)
+		return nil
+	}
+}
+
+func appendRefund(refunds *[]*types.Refund) func(fields []string) error {
+	return func(fields []string) error {
+		refund, err := refundFromFields(fields)
+		if err != nil {
+			return err
+		}
+		*refunds = append(*refunds, refund)
+		return nil
+	}
+}
+
+func decodeAccounts(o *origin, data string) (accounts []*types.Account) {
+	decodeDump(o, accountRecords, data, appendAccount(&accounts))
+	return accounts
+}
+
+func decodePayments(o *origin, data string) (payments []*types.Payment) {
+	decodeDump(o, paymentRecords, data, appendPayment(&payments))
+	return payments
+}
+
+func decodeFavorites(o *origin, data string) (favorites []*types.Favorite) {
+	decodeDump(o, favoriteRecords, data, appendFavorite(&favorites))
+	return favorites
+}
+
+func decodePostings(o *origin, data string) (postings []*types.Posting) {
+	decodeDump(o, postingRecords, data, appendPosting(&postings))
+	return postings
+}
+
+func decodeDeposits(o *origin, data string) (deposits []*types.Deposit) {
+	decodeDump(o, depositRecords, data, appendDeposit(&deposits))
+	return deposits
+}
+
+func decodeTransfers(o *origin, data string) (transfers []*types.Transfer) {
+	decodeDump(o, transferRecords, data, appendTransfer(&transfers))
+	return transfers
+}
+
+func decodeKeys(o *origin, data string) (keys []*types.IdempotencyKey) {
+	decodeDump(o, keyRecords, data, appendKey(&keys))
+	return keys
+}
+
+func decodeRefunds(o *origin, data string) (refunds []*types.Refund) {
+	decodeDump(o, refundRecords, data, appendRefund(&refunds))
+	return refunds
+}
+
+// parseAccounts, parsePayments and the other parse funcs read a whole dump
+// file and fail with an *ImportError if any record is bad.
+func parseAccounts(data string) ([]*types.Account, error) {
+	report := &ImportError{}
+	accounts := decodeAccounts(newOrigin(accountsDump, report), data)
+	return accounts, report.err()
+}
+
+func parsePayments(data string) ([]*types.Payment, error) {
+	report := &ImportError{}
+	payments := decodePayments(newOrigin(paymentsDump, report), data)
+	return payments, report.err()
+}
+
+func parseFavorites(data string) ([]*types.Favorite, error) {
+	report := &ImportError{}
+	favorites := decodeFavorites(newOrigin(favoritesDump, report), data)
+	return favorites, report.err()
+}
+
+func parsePostings(data string) ([]*types.Posting, error) {
+	report := &ImportError{}
+	postings := decodePostings(newOrigin(postingsDump, report), data)
+	return postings, report.err()
+}
+
+func parseDeposits(data string) ([]*types.Deposit, error) {
+	report := &ImportError{}
+	deposits := decodeDeposits(newOrigin(depositsDump, report), data)
+	return deposits, report.err()
+}
+
+func parseTransfers(data string) ([]*types.Transfer, error) {
+	report := &ImportError{}
+	transfers := decodeTransfers(newOrigin(transfersDump, report), data)
+	return transfers, report.err()
+}
+
+func parseKeys(data string) ([]*types.IdempotencyKey, error) {
+	report := &ImportError{}
+	keys := decodeKeys(newOrigin(keysDump, report), data)
+	return keys, report.err()
+}
+
+func parseRefunds(data string) ([]*types.Refund, error) {
+	report := &ImportError{}
+	refunds := decodeRefunds(newOrigin(refundsDump, report), data)
+	return refunds, report.err()
+}
+
+// writeDumps writes the files of codec c into dir together with a manifest
+// holding their checksums. Every file goes to a temporary file first. Once
+// all of them are on disk, a pending manifest listing them is written, and
+// finishDumps renames them into place, writes the manifest and removes the
+// pending manifest. A crash before the pending manifest is written leaves the
+// old export as it was; a crash after it leaves one that readDumps reads
+// through the pending manifest and the next writeDumps finishes. Either way
+// the files read are those of one export, on a first export into dir too.
+func writeDumps(dir string, c codec, files map[string]string) error {
+	err := finishDumps(dir, c)
+	if err != nil {
+		return err
+	}
+
+	temps := map[string]string{}
+	defer func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+
+	pending := ""
+	for _, name := range c.names() {
+		tmp, err := writeTempFile(filepath.Join(dir, name), files[name])
+		if err != nil {
+			return err
+		}
+		temps[name] = tmp
+		pending += joinFields([]string{name, checksum(files[name]), filepath.Base(tmp)}) + "\n"
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, pendingManifest(c)), pending)
+	if err != nil {
+		return err
+	}
+	// the temporary files are the export now, left for finishDumps
+	temps = nil
+	return finishDumps(dir, c)
+}
+
+// pendingManifest names the file listing the export being written by
+// writeDumps, one "name;checksum;temporary file" record per file.
+func pendingManifest(c codec) string {
+	return c.manifest + ".pending"
+}
+
+// pendingFile is a file of the export listed in a pending manifest.
+type pendingFile struct {
+	sum string
+	tmp string
+}
+
+// readPending reads the pending manifest of codec c in dir, nil if there is
+// none.
+func readPending(dir string, c codec) (map[string]pendingFile, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, pendingManifest(c)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pending := map[string]pendingFile{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		fields, err := splitFields(line)
+		if err != nil || len(fields) < 3 || filepath.Base(fields[2]) != fields[2] {
+			return nil, ErrManifestMismatch
+		}
+		pending[fields[0]] = pendingFile{sum: fields[1], tmp: fields[2]}
+	}
+	for _, name := range c.names() {
+		if _, listed := pending[name]; !listed {
+			return nil, ErrManifestMismatch
+		}
+	}
+	return pending, nil
+}
+
+// finishDumps finishes the export listed in the pending manifest of codec c
+// in dir, if there is one: the temporary files still there are renamed into
+// place, then the manifest is written and the pending manifest removed.
+func finishDumps(dir string, c codec) error {
+	pending, err := readPending(dir, c)
+	if err != nil || pending == nil {
+		return err
+	}
+
+	manifest := ""
+	for _, name := range c.names() {
+		file := pending[name]
+		err = os.Rename(filepath.Join(dir, file.tmp), filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		manifest += name + ";" + file.sum + ";\n"
+	}
+	err = syncDir(dir)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, c.manifest), manifest)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, pendingManifest(c)))
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// readDumps reads the files of codec c in dir, a missing file reads as empty.
+// If dir has a manifest, every file must match its checksum; exports made
+// before manifests existed are read as they are. Files the manifest does not
+// list were not written by that export, which predates them, and read as
+// empty. An export cut short after its pending manifest was written is read
+// as it will be once finishDumps is done with it.
+func readDumps(dir string, c codec) (map[string]string, error) {
+	pending, err := readPending(dir, c)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{}
+	for _, name := range c.names() {
+		path := filepath.Join(dir, name)
+		if file, listed := pending[name]; listed {
+			tmp := filepath.Join(dir, file.tmp)
+			if _, err := os.Stat(tmp); err == nil {
+				path = tmp
+			}
+		}
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[name] = string(data)
+	}
+
+	if pending != nil {
+		for _, name := range c.names() {
+			if pending[name].sum != checksum(files[name]) {
+				return nil, ErrManifestMismatch
+			}
+		}
+		return files, nil
+	}
+
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, c.manifest))
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sums := map[string]string{}
+	for _, line := range strings.Split(string(manifest), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		if len(fields) < 2 {
+			return nil, ErrManifestMismatch
+		}
+		sums[fields[0]] = fields[1]
+	}
+	for _, name := range c.names() {
+		sum, listed := sums[name]
+		if !listed {
+			delete(files, name)
+			continue
+		}
+		if sum != checksum(files[name]) {
+			return nil, ErrManifestMismatch
+		}
+	}
+	return files, nil
+}
+
+func checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,148 @@
+package wallet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestSplitFields_roundTrip(t *testing.T) {
+	roundTrip := func(fields []string) bool {
+		if len(fields) == 0 {
+			// an empty line is one empty field
+			fields = []string{""}
+		}
+		got, err := splitFields(joinFields(fields))
+		return err == nil && reflect.DeepEqual(got, fields)
+	}
+	err := quick.Check(roundTrip, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	separators := func(a, b string) bool {
+		fields := []string{a + ";\\\n" + b, "\r;" + b, `\;` + a}
+		got, err := splitFields(joinFields(fields))
+		return err == nil && reflect.DeepEqual(got, fields)
+	}
+	err = quick.Check(separators, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDump_roundTrip(t *testing.T) {
+	accounts := func(id int64, phone string, balance int64) bool {
+		want := []*types.Account{{ID: id, Phone: types.Phone(phone + ";\n"), Balance: types.Money(balance), Currency: types.CurrencyUSD, Status: types.AccountStatusFrozen}}
+		got, err := parseAccounts(encodeAccounts(want))
+		return err == nil && reflect.DeepEqual(got, want)
+	}
+	payments := func(id string, accountID int64, amount int64, category, status string) bool {
+		want := []*types.Payment{{
+			ID:        id,
+			AccountID: accountID,
+			Amount:    types.Money(amount),
+			Category:  types.PaymentCategory(category),
+			Status:    types.PaymentStatus(status),
+			Currency:  types.CurrencyKWD,
+
+			Fees:       []types.Fee{{Name: "card;\\" + category, Amount: types.Money(amount)}, {Name: "", Amount: 1}},
+			FeeAccount: accountID,
+		}}
+		got, err := parsePayments(encodePayments(want))
+		return err == nil && reflect.DeepEqual(got, want)
+	}
+	favorites := func(id string, accountID int64, name string, amount int64, category string) bool {
+		want := []*types.Favorite{{
+			ID:        id,
+			AccountID: accountID,
+			Name:      "a;b\nc\\" + name,
+			Amount:    types.Money(amount),
+			Category:  types.PaymentCategory(category),
+		}}
+		got, err := parseFavorites(encodeFavorites(want))
+		return err == nil && reflect.DeepEqual(got, want)
+	}
+
+	for name, f := range map[string]interface{}{"accounts": accounts, "payments": payments, "favorites": favorites} {
+		err := quick.Check(f, nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestDump_legacy(t *testing.T) {
+	accounts, err := parseAccounts("1;+992938151007;100;\n2;+992938151003;0;\n")
+	if err != nil {
+		t.Fatalf("parseAccounts(): error = %v", err)
+	}
+	want := []*types.Account{
+		{ID: 1, Phone: "+992938151007", Balance: 100, Currency: types.DefaultCurrency, Status: types.AccountStatusActive},
+		{ID: 2, Phone: "+992938151003", Balance: 0, Currency: types.DefaultCurrency, Status: types.AccountStatusActive},
+	}
+	if !reflect.DeepEqual(accounts, want) {
+		t.Errorf("parseAccounts() = %v, want %v", accounts, want)
+	}
+
+	favorites, err := parseFavorites("f1;1;ogastus;10;auto;\n")
+	if err != nil {
+		t.Fatalf("parseFavorites(): error = %v", err)
+	}
+	if len(favorites) != 1 || favorites[0].Name != "ogastus" || favorites[0].Amount != 10 {
+		t.Errorf("parseFavorites() = %v", favorites)
+	}
+}
+
+func TestDump_badHeader(t *testing.T) {
+	tests := []struct {
+		data string
+		want error
+	}{
+		{"#wallet-dump;3;accounts\n1;+992938151007;100\n", ErrDumpVersion},
+		{"#wallet-dump;2;payments\n", ErrDumpType},
+		{"#wallet-dump;2;accounts\n1;+992938151007\n", ErrDumpRecord},
+		{"#wallet-dump;2;accounts\n1;+99\\x;100\n", ErrDumpEscape},
+	}
+	for _, tt := range tests {
+		_, err := parseAccounts(tt.data)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("parseAccounts(%q): error = %v, want %v", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestService_Export_Import_escapedFavorite(t *testing.T) {
+	s := newTestService()
+
+	_, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "rent; flat 7\nsecond line")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): error = %v", err)
+	}
+
+	restored := newTestService()
+	err = restored.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): error = %v", err)
+	}
+	got, err := restored.FindFavoriteByID(favorite.ID)
+	if err != nil {
+		t.Fatalf("FindFavoriteByID(): error = %v", err)
+	}
+	if got.Name != favorite.Name {
+		t.Errorf("FindFavoriteByID(): name = %q, want %q", got.Name, favorite.Name)
+	}
+}
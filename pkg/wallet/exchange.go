@@ -0,0 +1,200 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// LedgerExchange is where transfers across currencies change money: the
+// sender pays into it in one currency and the recipient is paid from it in
+// another, so it is the only ledger account whose balance mixes currencies.
+const LedgerExchange int64 = -4
+
+var ErrNoRate = errors.New("no exchange rate")
+var ErrBadRate = errors.New("exchange rate must be a positive number")
+
+// RateProvider gives the exchange rates used to pay and transfer across
+// currencies. A service without one refuses to mix currencies.
+type RateProvider interface {
+	// Rate returns how many units of to a unit of from is worth, or an error
+	// wrapping ErrNoRate if it does not know.
+	Rate(from types.Currency, to types.Currency) (types.Rate, error)
+}
+
+// WithRates makes the service convert payments and transfers across
+// currencies at the rates of provider.
+func WithRates(provider RateProvider) Option {
+	return func(s *Service) {
+		s.rates = provider
+	}
+}
+
+// CurrencyPair names the currencies of an exchange rate.
+type CurrencyPair struct {
+	From types.Currency
+	To   types.Currency
+}
+
+// StaticRates is a RateProvider with fixed rates. The rate of a pair missing
+// from the map is the inverse of the reverse pair, if that is there.
+type StaticRates map[CurrencyPair]types.Rate
+
+func (r StaticRates) Rate(from types.Currency, to types.Currency) (types.Rate, error) {
+	if from == to {
+		return "1", nil
+	}
+	if rate, ok := r[CurrencyPair{from, to}]; ok {
+		return rate, nil
+	}
+	if reverse, ok := r[CurrencyPair{to, from}]; ok {
+		value, err := parseRate(reverse)
+		if err != nil {
+			return "", err
+		}
+		return types.Rate(value.Inv(value).RatString()), nil
+	}
+	return "", fmt.Errorf("%w: %v to %v", ErrNoRate, from, to)
+}
+
+// rateRecords is the record type of a rates file.
+const rateRecords = "rates"
+
+// LoadRates reads StaticRates from a file in the dump format:
+//
+//	#wallet-dump;2;rates
+//	from;to;rate
+//
+// A missing file has no rates. Bad lines are reported in an *ImportError.
+func LoadRates(path string) (StaticRates, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return StaticRates{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rates := StaticRates{}
+	report := &ImportError{}
+	decodeDump(newOrigin(path, report), rateRecords, string(data), func(fields []string) error {
+		if len(fields) < 3 {
+			return ErrDumpRecord
+		}
+		pair := CurrencyPair{types.Currency(fields[0]), types.Currency(fields[1])}
+		if !pair.From.Known() || !pair.To.Known() {
+			return fmt.Errorf("%w %q", ErrUnknownCurrency, fields[0]+"/"+fields[1])
+		}
+		rate := types.Rate(fields[2])
+		_, err := parseRate(rate)
+		if err != nil {
+			return err
+		}
+		rates[pair] = rate
+		return nil
+	})
+	return rates, report.err()
+}
+
+// parseRate reads a rate, which must be positive.
+func parseRate(rate types.Rate) (*big.Rat, error) {
+	value, ok := new(big.Rat).SetString(string(rate))
+	if !ok || value.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: %q", ErrBadRate, rate)
+	}
+	return value, nil
+}
+
+// rate returns the rate from one currency to another, asking the provider of
+// the service. Without one, currencies can not be mixed.
+func (s *Service) rate(from types.Currency, to types.Currency) (types.Rate, error) {
+	if s.rates == nil {
+		return "", ErrCurrencyMismatch
+	}
+	return s.rates.Rate(from, to)
+}
+
+// reportRates returns the rates converting the currencies of payments into
+// types.DefaultCurrency, which sums of payments across accounts are given in.
+func (s *Service) reportRates(payments []*types.Payment) (map[types.Currency]types.Rate, error) {
+	rates := map[types.Currency]types.Rate{}
+	for _, payment := range payments {
+		currency := payment.Currency
+		if currency == "" || currency == types.DefaultCurrency {
+			continue
+		}
+		if _, ok := rates[currency]; ok {
+			continue
+		}
+		rate, err := s.rate(currency, types.DefaultCurrency)
+		if err != nil {
+			return nil, err
+		}
+		rates[currency] = rate
+	}
+	return rates, nil
+}
+
+// reportAmount returns the amount of the payment in types.DefaultCurrency,
+// at the rates of reportRates.
+func reportAmount(payment *types.Payment, rates map[types.Currency]types.Rate) (types.Money, error) {
+	if payment.Currency == "" || payment.Currency == types.DefaultCurrency {
+		return payment.Amount, nil
+	}
+	return convert(payment.Amount, payment.Currency, types.DefaultCurrency, rates[payment.Currency])
+}
+
+// reportAmounts returns the amounts of payments in types.DefaultCurrency.
+func (s *Service) reportAmounts(payments []*types.Payment) ([]types.Money, error) {
+	rates, err := s.reportRates(payments)
+	if err != nil {
+		return nil, err
+	}
+	amounts := make([]types.Money, 0, len(payments))
+	for _, payment := range payments {
+		amount, err := reportAmount(payment, rates)
+		if err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, amount)
+	}
+	return amounts, nil
+}
+
+// convert returns amount of currency from in currency to at rate, rounded
+// half away from zero to the minor unit of to.
+func convert(amount types.Money, from types.Currency, to types.Currency, rate types.Rate) (types.Money, error) {
+	value, err := parseRate(rate)
+	if err != nil {
+		return 0, err
+	}
+	fromDigits, ok := from.MinorUnits()
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownCurrency, from)
+	}
+	toDigits, ok := to.MinorUnits()
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownCurrency, to)
+	}
+
+	value.Mul(value, new(big.Rat).SetInt64(int64(amount)))
+	value.Mul(value, new(big.Rat).SetFrac(pow10(toDigits), pow10(fromDigits)))
+
+	num, den := value.Num(), value.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() != 0 && new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+	if !quo.IsInt64() {
+		return 0, ErrOverflow
+	}
+	return types.Money(quo.Int64()), nil
+}
+
+func pow10(digits int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+}
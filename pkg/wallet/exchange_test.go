@@ -0,0 +1,242 @@
+package wallet
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+var testRates = StaticRates{
+	{types.CurrencyUSD, types.CurrencyTJS}: "10.93",
+	{types.CurrencyEUR, types.CurrencyJPY}: "160.5",
+}
+
+func TestStaticRates_Rate(t *testing.T) {
+	tests := []struct {
+		from, to types.Currency
+		want     types.Rate
+		err      error
+	}{
+		{types.CurrencyUSD, types.CurrencyTJS, "10.93", nil},
+		{types.CurrencyTJS, types.CurrencyUSD, "100/1093", nil},
+		{types.CurrencyRUB, types.CurrencyRUB, "1", nil},
+		{types.CurrencyRUB, types.CurrencyUSD, "", ErrNoRate},
+	}
+	for _, tt := range tests {
+		got, err := testRates.Rate(tt.from, tt.to)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Rate(%v, %v) = %q, %v, want %q, %v", tt.from, tt.to, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		amount   types.Money
+		from, to types.Currency
+		rate     types.Rate
+		want     types.Money
+	}{
+		{10_00, types.CurrencyUSD, types.CurrencyTJS, "10.93", 109_30},
+		{1, types.CurrencyUSD, types.CurrencyTJS, "10.93", 11},
+		{109_30, types.CurrencyTJS, types.CurrencyUSD, "100/1093", 10_00},
+		{5, types.CurrencyTJS, types.CurrencyUSD, "100/1093", 0},
+		{10_00, types.CurrencyEUR, types.CurrencyJPY, "160.5", 1605},
+		{1605, types.CurrencyJPY, types.CurrencyKWD, "0.002", 3_210},
+	}
+	for _, tt := range tests {
+		got, err := convert(tt.amount, tt.from, tt.to, tt.rate)
+		if err != nil || got != tt.want {
+			t.Errorf("convert(%v %v to %v at %v) = %v, %v, want %v", tt.amount, tt.from, tt.to, tt.rate, got, err, tt.want)
+		}
+	}
+
+	_, err := convert(1<<62, types.CurrencyUSD, types.CurrencyTJS, "10")
+	if err != ErrOverflow {
+		t.Errorf("convert(huge): error = %v, want %v", err, ErrOverflow)
+	}
+	_, err = convert(1, types.CurrencyUSD, types.CurrencyTJS, "-1")
+	if !errors.Is(err, ErrBadRate) {
+		t.Errorf("convert(rate -1): error = %v, want %v", err, ErrBadRate)
+	}
+}
+
+func TestLoadRates(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"rates.dump": "#wallet-dump;2;rates\nUSD;TJS;10.93\nEUR;TJS;12\n",
+		"bad.dump":   "#wallet-dump;2;rates\nUSD;TJS;ten\nUSD;XXX;1\n",
+	})
+
+	rates, err := LoadRates(filepath.Join(dir, "rates.dump"))
+	if err != nil {
+		t.Fatalf("LoadRates(): error = %v", err)
+	}
+	rate, err := rates.Rate(types.CurrencyTJS, types.CurrencyEUR)
+	if err != nil || rate != "1/12" {
+		t.Errorf("Rate(TJS, EUR) = %q, %v, want 1/12", rate, err)
+	}
+
+	_, err = LoadRates(filepath.Join(dir, "bad.dump"))
+	if !errors.Is(err, ErrBadRate) || !errors.Is(err, ErrUnknownCurrency) {
+		t.Errorf("LoadRates(bad): error = %v, want %v and %v", err, ErrBadRate, ErrUnknownCurrency)
+	}
+	rates, err = LoadRates(filepath.Join(dir, "missing.dump"))
+	if err != nil || len(rates) != 0 {
+		t.Errorf("LoadRates(missing) = %v, %v, want no rates", rates, err)
+	}
+}
+
+func TestService_PayIn_convert(t *testing.T) {
+	s := &testService{Service: NewService(WithRates(testRates))}
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 1000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := s.PayIn(account.ID, 10_00, types.CurrencyUSD, types.PaymentCategoryIT)
+	if err != nil {
+		t.Fatalf("PayIn(USD): error = %v", err)
+	}
+	if payment.Amount != 109_30 || payment.Currency != types.CurrencyTJS ||
+		payment.OriginalAmount != 10_00 || payment.OriginalCurrency != types.CurrencyUSD || payment.Rate != "10.93" {
+		t.Errorf("PayIn(USD) = %+v", payment)
+	}
+	if s.balance(account) != 890_70 {
+		t.Errorf("PayIn(USD): balance = %v, want 890_70", s.balance(account))
+	}
+
+	// the rate changes, Reject gives back what was paid
+	s.rates = StaticRates{{types.CurrencyUSD, types.CurrencyTJS}: "11"}
+	repeated, err := s.Repeat(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repeated.Amount != 110_00 || repeated.OriginalAmount != 10_00 || repeated.Rate != "11" {
+		t.Errorf("Repeat() = %+v, want 10 USD at 11", repeated)
+	}
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.balance(account) != 890_00 {
+		t.Errorf("Reject(): balance = %v, want 890_00", s.balance(account))
+	}
+
+	_, err = s.PayIn(account.ID, 10_00, types.CurrencyRUB, types.PaymentCategoryIT)
+	if !errors.Is(err, ErrNoRate) {
+		t.Errorf("PayIn(RUB): error = %v, want %v", err, ErrNoRate)
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+
+	dir := t.TempDir()
+	for _, format := range []Format{FormatDump, FormatJSON, FormatCSV} {
+		err = s.ExportFormat(dir, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := newTestService()
+		err = restored.ImportFormat(dir, format)
+		if err != nil {
+			t.Fatalf("ImportFormat(%v): error = %v", format, err)
+		}
+		assertSameState(t, restored.Service, s.Service)
+	}
+}
+
+func TestService_Transfer_convert(t *testing.T) {
+	s := &testService{Service: NewService(WithRates(testRates))}
+
+	from, err := s.addAccountWithBalance(defaultTestAccount.phone, 1000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.RegisterAccountIn("+992938151003", types.CurrencyUSD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.Transfer(from.ID, to.ID, 109_30)
+	if err != nil {
+		t.Fatalf("Transfer(TJS to USD): error = %v", err)
+	}
+	if transfer.Amount != 109_30 || transfer.ToAmount != 10_00 || transfer.Rate != "100/1093" {
+		t.Errorf("Transfer() = %+v", transfer)
+	}
+	if s.balance(from) != 890_70 || s.balance(to) != 10_00 {
+		t.Errorf("Transfer(): balances = %v, %v, want 890_70, 10_00", s.balance(from), s.balance(to))
+	}
+	statement, err := s.ExportAccountStatement(to.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement) != 1 || statement[0].Kind != types.TransactionTransfer || statement[0].Amount != 10_00 {
+		t.Errorf("ExportAccountStatement(): %+v, want the transfer", statement)
+	}
+
+	err = s.RejectTransfer(transfer.ID)
+	if err != nil {
+		t.Fatalf("RejectTransfer(): error = %v", err)
+	}
+	if s.balance(from) != 1000_00 || s.balance(to) != 0 {
+		t.Errorf("RejectTransfer(): balances = %v, %v, want 1000_00, 0", s.balance(from), s.balance(to))
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+
+	_, err = s.Transfer(from.ID, to.ID, 5)
+	if err != ErrAmountMustBePositive {
+		t.Errorf("Transfer(less than a cent): error = %v, want %v", err, ErrAmountMustBePositive)
+	}
+}
+
+func TestService_TotalPayments_currencies(t *testing.T) {
+	s := &testService{Service: NewService(WithRates(testRates))}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 1000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(account.ID, 100_00, types.PaymentCategoryIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dollars, err := s.RegisterAccountIn("+992938151003", types.CurrencyUSD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Deposit(dollars.ID, 100_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(dollars.ID, 10_00, types.PaymentCategoryIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 USD are 109.30 TJS
+	total, err := s.TotalPayments(2)
+	if err != nil || total != 209_30 {
+		t.Errorf("TotalPayments() = %v, %v, want 209_30", total, err)
+	}
+	for progress := range s.SumPaymentsWithProgress() {
+		if progress.Err != nil || progress.Result != 209_30 {
+			t.Errorf("SumPaymentsWithProgress() sent %+v, want 209_30", progress)
+		}
+	}
+
+	s.rates = nil
+	_, err = s.TotalPayments(2)
+	if err != ErrCurrencyMismatch {
+		t.Errorf("TotalPayments() without rates: error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	for progress := range s.SumPaymentsWithProgress() {
+		if progress.Err != ErrCurrencyMismatch {
+			t.Errorf("SumPaymentsWithProgress() without rates sent %+v, want %v", progress, ErrCurrencyMismatch)
+		}
+	}
+}
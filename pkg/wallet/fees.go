@@ -0,0 +1,186 @@
+package wallet
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// LedgerFees is where the fees of payments go unless WithFeeAccount names
+// an account of the wallet for them.
+const LedgerFees int64 = -6
+
+// FeeSchedule works out the fees of payments. Fees are in the currency of
+// the account paying, charged on top of amount.
+type FeeSchedule interface {
+	Fees(account types.Account, amount types.Money, category types.PaymentCategory) ([]types.Fee, error)
+}
+
+// WithFees makes the service charge payments the fees of schedule.
+// Without it payments are free.
+func WithFees(schedule FeeSchedule) Option {
+	return func(s *Service) {
+		s.fees = schedule
+	}
+}
+
+// WithFeeAccount makes the service credit the fees of payments to the
+// account with accountID instead of LedgerFees. Payments from accounts in
+// another currency than its one, which fees could not be credited to it in,
+// give ErrCurrencyMismatch. The account itself pays no fees.
+func WithFeeAccount(accountID int64) Option {
+	return func(s *Service) {
+		s.feeAccount = accountID
+	}
+}
+
+// FeeRule charges a payment a Flat fee and a share of its amount, its Rate,
+// such as "0.015" for 1.5%. The Tiers of a tiered rule add the fees of the
+// tier the amount falls into. A rule for a Category only applies to payments
+// in it; the empty Category applies to all of them. Shares are rounded half
+// away from zero.
+type FeeRule struct {
+	Name     string
+	Category types.PaymentCategory
+	Flat     types.Money
+	Rate     types.Rate
+	Tiers    []FeeTier
+}
+
+// FeeTier is the part of a tiered FeeRule for amounts from From up to the
+// From of the next tier.
+type FeeTier struct {
+	From types.Money
+	Flat types.Money
+	Rate types.Rate
+}
+
+// FeeRules is a FeeSchedule charging the fee of every rule that applies to a
+// payment, as a fee line of its own. Rules coming to zero are left out.
+type FeeRules []FeeRule
+
+func (rules FeeRules) Fees(account types.Account, amount types.Money, category types.PaymentCategory) ([]types.Fee, error) {
+	fees := []types.Fee{}
+	for _, rule := range rules {
+		if rule.Category != "" && !strings.EqualFold(string(rule.Category), string(category)) {
+			continue
+		}
+		fee, err := rule.fee(account.Currency, amount)
+		if err != nil {
+			return nil, err
+		}
+		if fee > 0 {
+			fees = append(fees, types.Fee{Name: rule.Name, Amount: fee})
+		}
+	}
+	return fees, nil
+}
+
+// fee returns the fee of the rule for amount.
+func (rule FeeRule) fee(currency types.Currency, amount types.Money) (types.Money, error) {
+	fee, err := share(currency, amount, rule.Flat, rule.Rate)
+	if err != nil {
+		return 0, err
+	}
+
+	tiers := append([]FeeTier(nil), rule.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].From > tiers[j].From })
+	for _, tier := range tiers {
+		if amount < tier.From {
+			continue
+		}
+		tierFee, err := share(currency, amount, tier.Flat, tier.Rate)
+		if err != nil {
+			return 0, err
+		}
+		return fee.Add(tierFee)
+	}
+	return fee, nil
+}
+
+// share returns flat plus the share rate of amount.
+func share(currency types.Currency, amount types.Money, flat types.Money, rate types.Rate) (types.Money, error) {
+	if rate == "" {
+		return flat, nil
+	}
+	part, err := convert(amount, currency, currency, rate)
+	if err != nil {
+		return 0, err
+	}
+	return flat.Add(part)
+}
+
+// feeAccountID returns the ledger account the fees of payments go to.
+func (s *Service) feeAccountID() int64 {
+	if s.feeAccount > 0 {
+		return s.feeAccount
+	}
+	return LedgerFees
+}
+
+// lockPayer locks the account paying and the one its fees go to, if that is
+// an account of the wallet. feeAccount is nil if it is not.
+func (s *Service) lockPayer(accountID int64) (account *types.Account, feeAccount *types.Account, unlock func(), err error) {
+	feeAccountID := s.feeAccountID()
+	if s.fees == nil || feeAccountID <= 0 || feeAccountID == accountID {
+		account, unlock, err = s.lockAccount(accountID)
+		return account, nil, unlock, err
+	}
+
+	accounts, unlock, err := s.lockAccounts(accountID, feeAccountID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return accounts[0], accounts[1], unlock, nil
+}
+
+// chargeFees works out the fees of a payment of amount in category by
+// account and returns them with their total. The caller holds the locks of
+// lockPayer; feeAccount is the one it returned.
+func (s *Service) chargeFees(account *types.Account, feeAccount *types.Account, amount types.Money, category types.PaymentCategory) ([]types.Fee, types.Money, error) {
+	if s.fees == nil || s.feeAccountID() == account.ID {
+		return nil, 0, nil
+	}
+
+	fees, err := s.fees.Fees(*account, amount, category)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total types.Money
+	for _, fee := range fees {
+		if fee.Amount <= 0 {
+			return nil, 0, ErrAmountMustBePositive
+		}
+		total, err = total.Add(fee.Amount)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if total > 0 && feeAccount != nil {
+		if feeAccount.Currency != account.Currency {
+			return nil, 0, ErrCurrencyMismatch
+		}
+		err = checkNotClosed(feeAccount)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if total == 0 {
+		fees = nil
+	}
+	return fees, total, nil
+}
+
+// feesTotal returns the total of the fees of the payment.
+func feesTotal(payment *types.Payment) (types.Money, error) {
+	var total types.Money
+	var err error
+	for _, fee := range payment.Fees {
+		total, err = total.Add(fee.Amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
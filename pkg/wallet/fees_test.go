@@ -0,0 +1,154 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+var testFees = FeeRules{
+	{Name: "processing", Flat: 10},
+	{Name: "auto", Category: "auto", Rate: "0.015"},
+	{Name: "fun", Category: "fun", Tiers: []FeeTier{
+		{From: 0, Flat: 5},
+		{From: 10_000, Rate: "0.01"},
+		{From: 100_000, Flat: 100, Rate: "0.005"},
+	}},
+}
+
+func TestFeeRules_Fees(t *testing.T) {
+	tests := []struct {
+		amount   types.Money
+		category types.PaymentCategory
+		want     []types.Fee
+	}{
+		{1000, "food", []types.Fee{{Name: "processing", Amount: 10}}},
+		{1000, "Auto", []types.Fee{{Name: "processing", Amount: 10}, {Name: "auto", Amount: 15}}},
+		{33, "auto", []types.Fee{{Name: "processing", Amount: 10}}},
+		{9_999, "fun", []types.Fee{{Name: "processing", Amount: 10}, {Name: "fun", Amount: 5}}},
+		{10_000, "fun", []types.Fee{{Name: "processing", Amount: 10}, {Name: "fun", Amount: 100}}},
+		{200_000, "fun", []types.Fee{{Name: "processing", Amount: 10}, {Name: "fun", Amount: 1100}}},
+	}
+	for _, tt := range tests {
+		got, err := testFees.Fees(types.Account{Currency: types.CurrencyTJS}, tt.amount, tt.category)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Fees(%v, %v) = %v, %v, want %v", tt.amount, tt.category, got, err, tt.want)
+		}
+	}
+}
+
+func TestService_Pay_fees(t *testing.T) {
+	s := &testService{Service: NewService(WithFees(testFees))}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := s.Pay(account.ID, 1000, "auto")
+	if err != nil {
+		t.Fatalf("Pay(): error = %v", err)
+	}
+	want := []types.Fee{{Name: "processing", Amount: 10}, {Name: "auto", Amount: 15}}
+	if !reflect.DeepEqual(payment.Fees, want) || payment.FeeAccount != LedgerFees || payment.Amount != 1000 {
+		t.Errorf("Pay() = %+v, want fees %v to %v", payment, want, LedgerFees)
+	}
+	if s.balance(account) != 2000-1025 {
+		t.Errorf("Pay(): balance = %v, want %v", s.balance(account), 2000-1025)
+	}
+
+	// the fees count towards the balance needed
+	_, err = s.Pay(account.ID, 970, "food")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay(): error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+
+	statement, err := s.ExportAccountStatement(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := statement[len(statement)-1]
+	if fee.Kind != types.TransactionFee || fee.ID != payment.ID || fee.Amount != -25 {
+		t.Errorf("ExportAccountStatement() ends with %+v, want the fees of the payment", fee)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Fatalf("Reject(): error = %v", err)
+	}
+	if s.balance(account) != 2000 {
+		t.Errorf("Reject(): balance = %v, want 2000", s.balance(account))
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+}
+
+func TestService_Pay_feeAccount(t *testing.T) {
+	s := &testService{Service: NewService(WithFees(testFees), WithFeeAccount(1))}
+	bank, err := s.RegisterAccount("+992938151000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 10_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := s.Pay(account.ID, 1000, "fun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.FeeAccount != bank.ID || s.balance(bank) != 15 {
+		t.Errorf("Pay(): fee account %v has %v, want %v to have 15", payment.FeeAccount, s.balance(bank), bank.ID)
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "cinema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.PayFromFavorite(favorite.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repeated, err := s.Repeat(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.balance(bank) != 45 || s.balance(account) != 10_000-3*1015 {
+		t.Errorf("balances = %v, %v, want 45, %v", s.balance(bank), s.balance(account), 10_000-3*1015)
+	}
+
+	// the fee account pays no fees
+	err = s.Deposit(bank.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := s.Pay(bank.ID, 100, "fun")
+	if err != nil || len(own.Fees) != 0 || s.balance(bank) != 45 {
+		t.Errorf("Pay() from the fee account = %+v, %v, balance %v, want no fees", own, err, s.balance(bank))
+	}
+
+	err = s.Reject(repeated.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.balance(bank) != 30 || s.balance(account) != 10_000-2*1015 {
+		t.Errorf("Reject(): balances = %v, %v, want 30, %v", s.balance(bank), s.balance(account), 10_000-2*1015)
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+
+	other, err := s.RegisterAccountIn("+992938151003", types.CurrencyUSD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Deposit(other.ID, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(other.ID, 100, "fun")
+	if err != ErrCurrencyMismatch {
+		t.Errorf("Pay() in another currency: error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+}
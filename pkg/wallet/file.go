@@ -0,0 +1,408 @@
+package wallet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// FileRepository keeps the data in memory like MemoryRepository and writes it
+// to accounts.dump, payments.dump, favorites.dump, postings.dump,
+// deposits.dump, transfers.dump, keys.dump and refunds.dump in its directory
+// on every change, in the same format as Service.Export. Each change rewrites
+// the whole file, so it suits small wallets and tests rather than heavy load.
+// Files are replaced atomically, so a crash leaves either the old or the new
+// one.
+type FileRepository struct {
+	dir    string
+	memory *MemoryRepository
+	// mu serializes writing the files
+	mu sync.Mutex
+}
+
+// NewFileRepository opens the repository in dir, loading the dump files that
+// already exist there. Phones are put in E.164 form, national numbers being
+// in the country with DefaultCountryCode, and two accounts with the same
+// phone give ErrPhoneNumberRegistred.
+func NewFileRepository(dir string) (*FileRepository, error) {
+	return NewFileRepositoryWithCountryCode(dir, DefaultCountryCode)
+}
+
+// NewFileRepositoryWithCountryCode is NewFileRepository for a service made
+// WithCountryCode(code).
+func NewFileRepositoryWithCountryCode(dir string, code string) (*FileRepository, error) {
+	r := &FileRepository{dir: dir, memory: NewMemoryRepository()}
+
+	// an Export into dir cut short by a crash is finished first
+	err := finishDumps(dir, dumpCodec)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readDump(filepath.Join(dir, accountsDump))
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := parseAccounts(data)
+	if err != nil {
+		return nil, err
+	}
+	err = normalizePhones(accounts, code)
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		r.memory.Accounts().Save(account)
+	}
+
+	data, err = readDump(filepath.Join(dir, paymentsDump))
+	if err != nil {
+		return nil, err
+	}
+	payments, err := parsePayments(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, payment := range payments {
+		r.memory.Payments().Save(payment)
+	}
+
+	data, err = readDump(filepath.Join(dir, favoritesDump))
+	if err != nil {
+		return nil, err
+	}
+	favorites, err := parseFavorites(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, favorite := range favorites {
+		r.memory.Favorites().Save(favorite)
+	}
+
+	data, err = readDump(filepath.Join(dir, postingsDump))
+	if err != nil {
+		return nil, err
+	}
+	postings, err := parsePostings(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, posting := range postings {
+		r.memory.Postings().Save(posting)
+	}
+
+	data, err = readDump(filepath.Join(dir, depositsDump))
+	if err != nil {
+		return nil, err
+	}
+	deposits, err := parseDeposits(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, deposit := range deposits {
+		r.memory.Deposits().Save(deposit)
+	}
+
+	data, err = readDump(filepath.Join(dir, transfersDump))
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := parseTransfers(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, transfer := range transfers {
+		r.memory.Transfers().Save(transfer)
+	}
+
+	data, err = readDump(filepath.Join(dir, keysDump))
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		r.memory.Keys().Save(key)
+	}
+
+	data, err = readDump(filepath.Join(dir, refundsDump))
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := parseRefunds(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, refund := range refunds {
+		r.memory.Refunds().Save(refund)
+	}
+
+	return r, nil
+}
+
+// readDump reads the file, a missing file reads as empty.
+func readDump(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (r *FileRepository) Accounts() AccountRepository {
+	return fileAccounts{r.memory.Accounts(), r}
+}
+
+func (r *FileRepository) Payments() PaymentRepository {
+	return filePayments{r.memory.Payments(), r}
+}
+
+func (r *FileRepository) Favorites() FavoriteRepository {
+	return fileFavorites{r.memory.Favorites(), r}
+}
+
+func (r *FileRepository) Postings() PostingRepository {
+	return filePostings{r.memory.Postings(), r}
+}
+
+func (r *FileRepository) Deposits() DepositRepository {
+	return fileDeposits{r.memory.Deposits(), r}
+}
+
+func (r *FileRepository) Transfers() TransferRepository {
+	return fileTransfers{r.memory.Transfers(), r}
+}
+
+func (r *FileRepository) Keys() KeyRepository {
+	return fileKeys{r.memory.Keys(), r}
+}
+
+func (r *FileRepository) Refunds() RefundRepository {
+	return fileRefunds{r.memory.Refunds(), r}
+}
+
+// write replaces one dump file with the current data. A manifest left by
+// Export no longer matches once a file changes, so it is removed.
+func (r *FileRepository) write(name string, encode func(m *MemoryRepository) string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := os.Remove(filepath.Join(r.dir, manifestDump))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	r.memory.mu.RLock()
+	data := encode(r.memory)
+	r.memory.mu.RUnlock()
+
+	return writeFileAtomic(filepath.Join(r.dir, name), data)
+}
+
+type fileAccounts struct {
+	AccountRepository
+	r *FileRepository
+}
+
+func (a fileAccounts) Save(account *types.Account) error {
+	err := a.AccountRepository.Save(account)
+	if err != nil {
+		return err
+	}
+	return a.r.write(accountsDump, func(m *MemoryRepository) string {
+		return encodeAccounts(m.accounts)
+	})
+}
+
+type filePayments struct {
+	PaymentRepository
+	r *FileRepository
+}
+
+func (p filePayments) Save(payment *types.Payment) error {
+	err := p.PaymentRepository.Save(payment)
+	if err != nil {
+		return err
+	}
+	return p.r.write(paymentsDump, func(m *MemoryRepository) string {
+		return encodePayments(m.payments)
+	})
+}
+
+type fileFavorites struct {
+	FavoriteRepository
+	r *FileRepository
+}
+
+func (f fileFavorites) Save(favorite *types.Favorite) error {
+	err := f.FavoriteRepository.Save(favorite)
+	if err != nil {
+		return err
+	}
+	return f.r.write(favoritesDump, func(m *MemoryRepository) string {
+		return encodeFavorites(m.favorites)
+	})
+}
+
+type filePostings struct {
+	PostingRepository
+	r *FileRepository
+}
+
+func (p filePostings) Save(posting *types.Posting) error {
+	err := p.PostingRepository.Save(posting)
+	if err != nil {
+		return err
+	}
+	return p.r.write(postingsDump, func(m *MemoryRepository) string {
+		return encodePostings(m.postings)
+	})
+}
+
+type fileDeposits struct {
+	DepositRepository
+	r *FileRepository
+}
+
+func (d fileDeposits) Save(deposit *types.Deposit) error {
+	err := d.DepositRepository.Save(deposit)
+	if err != nil {
+		return err
+	}
+	return d.r.write(depositsDump, func(m *MemoryRepository) string {
+		return encodeDeposits(m.deposits)
+	})
+}
+
+type fileTransfers struct {
+	TransferRepository
+	r *FileRepository
+}
+
+func (t fileTransfers) Save(transfer *types.Transfer) error {
+	err := t.TransferRepository.Save(transfer)
+	if err != nil {
+		return err
+	}
+	return t.r.write(transfersDump, func(m *MemoryRepository) string {
+		return encodeTransfers(m.transfers)
+	})
+}
+
+type fileRefunds struct {
+	RefundRepository
+	r *FileRepository
+}
+
+func (f fileRefunds) Save(refund *types.Refund) error {
+	err := f.RefundRepository.Save(refund)
+	if err != nil {
+		return err
+	}
+	return f.r.write(refundsDump, func(m *MemoryRepository) string {
+		return encodeRefunds(m.refunds)
+	})
+}
+
+type fileKeys struct {
+	KeyRepository
+	r *FileRepository
+}
+
+func (k fileKeys) Save(key *types.IdempotencyKey) error {
+	err := k.KeyRepository.Save(key)
+	if err != nil {
+		return err
+	}
+	return k.r.write(keysDump, func(m *MemoryRepository) string {
+		return encodeKeys(m.keys)
+	})
+}
+
+func (a fileAccounts) Delete(accountID int64) error {
+	err := a.AccountRepository.Delete(accountID)
+	if err != nil {
+		return err
+	}
+	return a.r.write(accountsDump, func(m *MemoryRepository) string {
+		return encodeAccounts(m.accounts)
+	})
+}
+
+func (p filePayments) Delete(paymentID string) error {
+	err := p.PaymentRepository.Delete(paymentID)
+	if err != nil {
+		return err
+	}
+	return p.r.write(paymentsDump, func(m *MemoryRepository) string {
+		return encodePayments(m.payments)
+	})
+}
+
+func (f fileFavorites) Delete(favoriteID string) error {
+	err := f.FavoriteRepository.Delete(favoriteID)
+	if err != nil {
+		return err
+	}
+	return f.r.write(favoritesDump, func(m *MemoryRepository) string {
+		return encodeFavorites(m.favorites)
+	})
+}
+
+func (p filePostings) Delete(postingID string) error {
+	err := p.PostingRepository.Delete(postingID)
+	if err != nil {
+		return err
+	}
+	return p.r.write(postingsDump, func(m *MemoryRepository) string {
+		return encodePostings(m.postings)
+	})
+}
+
+func (d fileDeposits) Delete(depositID string) error {
+	err := d.DepositRepository.Delete(depositID)
+	if err != nil {
+		return err
+	}
+	return d.r.write(depositsDump, func(m *MemoryRepository) string {
+		return encodeDeposits(m.deposits)
+	})
+}
+
+func (t fileTransfers) Delete(transferID string) error {
+	err := t.TransferRepository.Delete(transferID)
+	if err != nil {
+		return err
+	}
+	return t.r.write(transfersDump, func(m *MemoryRepository) string {
+		return encodeTransfers(m.transfers)
+	})
+}
+
+func (k fileKeys) Delete(accountID int64, key string) error {
+	err := k.KeyRepository.Delete(accountID, key)
+	if err != nil {
+		return err
+	}
+	return k.r.write(keysDump, func(m *MemoryRepository) string {
+		return encodeKeys(m.keys)
+	})
+}
+
+func (f fileRefunds) Delete(refundID string) error {
+	err := f.RefundRepository.Delete(refundID)
+	if err != nil {
+		return err
+	}
+	return f.r.write(refundsDump, func(m *MemoryRepository) string {
+		return encodeRefunds(m.refunds)
+	})
+}
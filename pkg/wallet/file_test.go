@@ -0,0 +1,123 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestFileRepository_reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	repository, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): error = %v", err)
+	}
+	s := &testService{Service: NewService(WithRepository(repository))}
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	favorite, err := s.FavoritePayment(payments[0].ID, "ogastus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatalf("NewFileRepository(): error = %v", err)
+	}
+	restored := NewService(WithRepository(reopened))
+
+	gotAccount, err := restored.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): error = %v", err)
+	}
+	if !reflect.DeepEqual(gotAccount, account) {
+		t.Errorf("FindAccountByID() = %v, want %v", gotAccount, account)
+	}
+
+	gotPayment, err := restored.FindPaymentByID(payments[0].ID)
+	if err != nil {
+		t.Fatalf("FindPaymentByID(): error = %v", err)
+	}
+	if gotPayment.Status != types.PaymentStatusFail {
+		t.Errorf("FindPaymentByID(): status = %v, want %v", gotPayment.Status, types.PaymentStatusFail)
+	}
+
+	gotFavorite, err := restored.FindFavoriteByID(favorite.ID)
+	if err != nil {
+		t.Fatalf("FindFavoriteByID(): error = %v", err)
+	}
+	if !reflect.DeepEqual(gotFavorite, favorite) {
+		t.Errorf("FindFavoriteByID() = %v, want %v", gotFavorite, favorite)
+	}
+
+	next, err := restored.RegisterAccount("+992938151003")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): error = %v", err)
+	}
+	if next.ID != account.ID+1 {
+		t.Errorf("RegisterAccount(): id = %v, want %v", next.ID, account.ID+1)
+	}
+}
+
+func TestNewFileRepository_normalizesPhones(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{"accounts.dump": "1;9127660305;0\n2;9123456789;0\n"})
+	repository, err := NewFileRepositoryWithCountryCode(dir, "7")
+	if err != nil {
+		t.Fatalf("NewFileRepositoryWithCountryCode(): error = %v", err)
+	}
+	s := NewService(WithRepository(repository), WithCountryCode("7"))
+	_, err = s.RegisterAccount("+79123456789")
+	if err != ErrPhoneNumberRegistred {
+		t.Errorf("RegisterAccount(): error = %v, want %v", err, ErrPhoneNumberRegistred)
+	}
+	account, err := s.FindAccountByID(1)
+	if err != nil || account.Phone != "+79127660305" {
+		t.Errorf("FindAccountByID() = %v, %v, want the phone +79127660305", account, err)
+	}
+
+	dir = writeTestFiles(t, map[string]string{"accounts.dump": "1;9127660305;0\n2;+9929127660305;0\n"})
+	_, err = NewFileRepository(dir)
+	if !errors.Is(err, ErrPhoneNumberRegistred) {
+		t.Errorf("NewFileRepository() with a repeated phone: error = %v, want %v", err, ErrPhoneNumberRegistred)
+	}
+}
+
+func TestFileRepository_writeLeavesNoTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testService{Service: NewService(WithRepository(repository))}
+	_, _, err = s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".dump" {
+			t.Errorf("file %v left in the repository", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,675 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// Format selects the files written by ExportFormat and read by ImportFormat.
+// Every format keeps accounts, payments, favorites, postings, deposits,
+// transfers, idempotency keys and refunds in files named after them, e.g.
+// accounts.json, payments.json and so on.
+type Format string
+
+const (
+	// FormatDump is the ";"-separated format of Export and Import.
+	FormatDump Format = "dump"
+	// FormatJSON keeps each file as one JSON array.
+	FormatJSON Format = "json"
+	// FormatJSONL keeps one JSON object per line.
+	FormatJSONL Format = "jsonl"
+	// FormatCSV keeps one record per row, after a header row.
+	FormatCSV Format = "csv"
+)
+
+var ErrUnknownFormat = errors.New("unknown format")
+var ErrCSVHeader = errors.New("csv file has a wrong header")
+var ErrJSONArray = errors.New("json file must hold an array")
+
+// Columns of the CSV files, in the order of the dump fields.
+var (
+	accountColumns  = []string{"id", "phone", "balance", "currency", "max_payment", "max_balance", "spending", "overdraft", "status", "name", "email", "locale", "created"}
+	paymentColumns  = []string{"id", "account_id", "amount", "category", "status", "created", "updated", "currency", "original_amount", "original_currency", "rate", "fees", "fee_account"}
+	favoriteColumns = []string{"id", "account_id", "name", "amount", "category"}
+	postingColumns  = []string{"id", "debit", "credit", "amount", "reference", "created"}
+	depositColumns  = []string{"id", "account_id", "amount", "source", "reference", "created"}
+	transferColumns = []string{"id", "from_account_id", "to_account_id", "amount", "status", "created", "to_amount", "rate"}
+	keyColumns      = []string{"key", "account_id", "operation", "request", "record_id", "created"}
+	refundColumns   = []string{"id", "payment_id", "account_id", "amount", "created"}
+)
+
+// codec encodes and parses the files of one format.
+type codec struct {
+	ext string
+	// manifest names the file holding the checksums of an export
+	manifest string
+
+	encodeAccounts  func(accounts []*types.Account) (string, error)
+	encodePayments  func(payments []*types.Payment) (string, error)
+	encodeFavorites func(favorites []*types.Favorite) (string, error)
+	encodePostings  func(postings []*types.Posting) (string, error)
+	encodeDeposits  func(deposits []*types.Deposit) (string, error)
+	encodeTransfers func(transfers []*types.Transfer) (string, error)
+	encodeKeys      func(keys []*types.IdempotencyKey) (string, error)
+	encodeRefunds   func(refunds []*types.Refund) (string, error)
+
+	// parse funcs report bad records to o and leave them out
+	parseAccounts  func(o *origin, data string) []*types.Account
+	parsePayments  func(o *origin, data string) []*types.Payment
+	parseFavorites func(o *origin, data string) []*types.Favorite
+	parsePostings  func(o *origin, data string) []*types.Posting
+	parseDeposits  func(o *origin, data string) []*types.Deposit
+	parseTransfers func(o *origin, data string) []*types.Transfer
+	parseKeys      func(o *origin, data string) []*types.IdempotencyKey
+	parseRefunds   func(o *origin, data string) []*types.Refund
+}
+
+var dumpCodec = codec{
+	ext:      "dump",
+	manifest: manifestDump,
+	encodeAccounts: func(accounts []*types.Account) (string, error) {
+		return encodeAccounts(accounts), nil
+	},
+	encodePayments: func(payments []*types.Payment) (string, error) {
+		return encodePayments(payments), nil
+	},
+	encodeFavorites: func(favorites []*types.Favorite) (string, error) {
+		return encodeFavorites(favorites), nil
+	},
+	encodePostings: func(postings []*types.Posting) (string, error) {
+		return encodePostings(postings), nil
+	},
+	encodeDeposits: func(deposits []*types.Deposit) (string, error) {
+		return encodeDeposits(deposits), nil
+	},
+	encodeTransfers: func(transfers []*types.Transfer) (string, error) {
+		return encodeTransfers(transfers), nil
+	},
+	encodeKeys: func(keys []*types.IdempotencyKey) (string, error) {
+		return encodeKeys(keys), nil
+	},
+	encodeRefunds: func(refunds []*types.Refund) (string, error) {
+		return encodeRefunds(refunds), nil
+	},
+	parseAccounts:  decodeAccounts,
+	parsePayments:  decodePayments,
+	parseFavorites: decodeFavorites,
+	parsePostings:  decodePostings,
+	parseDeposits:  decodeDeposits,
+	parseTransfers: decodeTransfers,
+	parseKeys:      decodeKeys,
+	parseRefunds:   decodeRefunds,
+}
+
+var codecs = map[Format]codec{
+	FormatDump: dumpCodec,
+	FormatJSON: {
+		ext:      "json",
+		manifest: "json.manifest",
+		encodeAccounts: func(accounts []*types.Account) (string, error) {
+			return encodeJSON(append([]*types.Account{}, accounts...))
+		},
+		encodePayments: func(payments []*types.Payment) (string, error) {
+			return encodeJSON(append([]*types.Payment{}, payments...))
+		},
+		encodeFavorites: func(favorites []*types.Favorite) (string, error) {
+			return encodeJSON(append([]*types.Favorite{}, favorites...))
+		},
+		encodePostings: func(postings []*types.Posting) (string, error) {
+			return encodeJSON(append([]*types.Posting{}, postings...))
+		},
+		encodeDeposits: func(deposits []*types.Deposit) (string, error) {
+			return encodeJSON(append([]*types.Deposit{}, deposits...))
+		},
+		encodeTransfers: func(transfers []*types.Transfer) (string, error) {
+			return encodeJSON(append([]*types.Transfer{}, transfers...))
+		},
+		encodeKeys: func(keys []*types.IdempotencyKey) (string, error) {
+			return encodeJSON(append([]*types.IdempotencyKey{}, keys...))
+		},
+		encodeRefunds: func(refunds []*types.Refund) (string, error) {
+			return encodeJSON(append([]*types.Refund{}, refunds...))
+		},
+		parseAccounts: func(o *origin, data string) (accounts []*types.Account) {
+			parseJSON(o, data, unmarshalAccount(&accounts))
+			return accounts
+		},
+		parsePayments: func(o *origin, data string) (payments []*types.Payment) {
+			parseJSON(o, data, unmarshalPayment(&payments))
+			return payments
+		},
+		parseFavorites: func(o *origin, data string) (favorites []*types.Favorite) {
+			parseJSON(o, data, unmarshalFavorite(&favorites))
+			return favorites
+		},
+		parsePostings: func(o *origin, data string) (postings []*types.Posting) {
+			parseJSON(o, data, unmarshalPosting(&postings))
+			return postings
+		},
+		parseDeposits: func(o *origin, data string) (deposits []*types.Deposit) {
+			parseJSON(o, data, unmarshalDeposit(&deposits))
+			return deposits
+		},
+		parseTransfers: func(o *origin, data string) (transfers []*types.Transfer) {
+			parseJSON(o, data, unmarshalTransfer(&transfers))
+			return transfers
+		},
+		parseKeys: func(o *origin, data string) (keys []*types.IdempotencyKey) {
+			parseJSON(o, data, unmarshalKey(&keys))
+			return keys
+		},
+		parseRefunds: func(o *origin, data string) (refunds []*types.Refund) {
+			parseJSON(o, data, unmarshalRefund(&refunds))
+			return refunds
+		},
+	},
+	FormatJSONL: {
+		ext:      "jsonl",
+		manifest: "jsonl.manifest",
+		encodeAccounts: func(accounts []*types.Account) (string, error) {
+			records := make([]interface{}, len(accounts))
+			for i, account := range accounts {
+				records[i] = account
+			}
+			return encodeJSONL(records)
+		},
+		encodePayments: func(payments []*types.Payment) (string, error) {
+			records := make([]interface{}, len(payments))
+			for i, payment := range payments {
+				records[i] = payment
+			}
+			return encodeJSONL(records)
+		},
+		encodeFavorites: func(favorites []*types.Favorite) (string, error) {
+			records := make([]interface{}, len(favorites))
+			for i, favorite := range favorites {
+				records[i] = favorite
+			}
+			return encodeJSONL(records)
+		},
+		encodePostings: func(postings []*types.Posting) (string, error) {
+			records := make([]interface{}, len(postings))
+			for i, posting := range postings {
+				records[i] = posting
+			}
+			return encodeJSONL(records)
+		},
+		encodeDeposits: func(deposits []*types.Deposit) (string, error) {
+			records := make([]interface{}, len(deposits))
+			for i, deposit := range deposits {
+				records[i] = deposit
+			}
+			return encodeJSONL(records)
+		},
+		encodeTransfers: func(transfers []*types.Transfer) (string, error) {
+			records := make([]interface{}, len(transfers))
+			for i, transfer := range transfers {
+				records[i] = transfer
+			}
+			return encodeJSONL(records)
+		},
+		encodeKeys: func(keys []*types.IdempotencyKey) (string, error) {
+			records := make([]interface{}, len(keys))
+			for i, key := range keys {
+				records[i] = key
+			}
+			return encodeJSONL(records)
+		},
+		encodeRefunds: func(refunds []*types.Refund) (string, error) {
+			records := make([]interface{}, len(refunds))
+			for i, refund := range refunds {
+				records[i] = refund
+			}
+			return encodeJSONL(records)
+		},
+		parseAccounts: func(o *origin, data string) (accounts []*types.Account) {
+			parseJSONL(o, data, unmarshalAccount(&accounts))
+			return accounts
+		},
+		parsePayments: func(o *origin, data string) (payments []*types.Payment) {
+			parseJSONL(o, data, unmarshalPayment(&payments))
+			return payments
+		},
+		parseFavorites: func(o *origin, data string) (favorites []*types.Favorite) {
+			parseJSONL(o, data, unmarshalFavorite(&favorites))
+			return favorites
+		},
+		parsePostings: func(o *origin, data string) (postings []*types.Posting) {
+			parseJSONL(o, data, unmarshalPosting(&postings))
+			return postings
+		},
+		parseDeposits: func(o *origin, data string) (deposits []*types.Deposit) {
+			parseJSONL(o, data, unmarshalDeposit(&deposits))
+			return deposits
+		},
+		parseTransfers: func(o *origin, data string) (transfers []*types.Transfer) {
+			parseJSONL(o, data, unmarshalTransfer(&transfers))
+			return transfers
+		},
+		parseKeys: func(o *origin, data string) (keys []*types.IdempotencyKey) {
+			parseJSONL(o, data, unmarshalKey(&keys))
+			return keys
+		},
+		parseRefunds: func(o *origin, data string) (refunds []*types.Refund) {
+			parseJSONL(o, data, unmarshalRefund(&refunds))
+			return refunds
+		},
+	},
+	FormatCSV: {
+		ext:      "csv",
+		manifest: "csv.manifest",
+		encodeAccounts: func(accounts []*types.Account) (string, error) {
+			records := make([][]string, len(accounts))
+			for i, account := range accounts {
+				records[i] = accountFields(account)
+			}
+			return encodeCSV(accountColumns, records)
+		},
+		encodePayments: func(payments []*types.Payment) (string, error) {
+			records := make([][]string, len(payments))
+			for i, payment := range payments {
+				records[i] = paymentFields(payment)
+			}
+			return encodeCSV(paymentColumns, records)
+		},
+		encodeFavorites: func(favorites []*types.Favorite) (string, error) {
+			records := make([][]string, len(favorites))
+			for i, favorite := range favorites {
+				records[i] = favoriteFields(favorite)
+			}
+			return encodeCSV(favoriteColumns, records)
+		},
+		encodePostings: func(postings []*types.Posting) (string, error) {
+			records := make([][]string, len(postings))
+			for i, posting := range postings {
+				records[i] = postingFields(posting)
+			}
+			return encodeCSV(postingColumns, records)
+		},
+		encodeDeposits: func(deposits []*types.Deposit) (string, error) {
+			records := make([][]string, len(deposits))
+			for i, deposit := range deposits {
+				records[i] = depositFields(deposit)
+			}
+			return encodeCSV(depositColumns, records)
+		},
+		encodeTransfers: func(transfers []*types.Transfer) (string, error) {
+			records := make([][]string, len(transfers))
+			for i, transfer := range transfers {
+				records[i] = transferFields(transfer)
+			}
+			return encodeCSV(transferColumns, records)
+		},
+		encodeKeys: func(keys []*types.IdempotencyKey) (string, error) {
+			records := make([][]string, len(keys))
+			for i, key := range keys {
+				records[i] = keyFields(key)
+			}
+			return encodeCSV(keyColumns, records)
+		},
+		encodeRefunds: func(refunds []*types.Refund) (string, error) {
+			records := make([][]string, len(refunds))
+			for i, refund := range refunds {
+				records[i] = refundFields(refund)
+			}
+			return encodeCSV(refundColumns, records)
+		},
+		parseAccounts: func(o *origin, data string) (accounts []*types.Account) {
+			parseCSV(o, data, accountColumns, appendAccount(&accounts))
+			return accounts
+		},
+		parsePayments: func(o *origin, data string) (payments []*types.Payment) {
+			parseCSV(o, data, paymentColumns, appendPayment(&payments))
+			return payments
+		},
+		parseFavorites: func(o *origin, data string) (favorites []*types.Favorite) {
+			parseCSV(o, data, favoriteColumns, appendFavorite(&favorites))
+			return favorites
+		},
+		parsePostings: func(o *origin, data string) (postings []*types.Posting) {
+			parseCSV(o, data, postingColumns, appendPosting(&postings))
+			return postings
+		},
+		parseDeposits: func(o *origin, data string) (deposits []*types.Deposit) {
+			parseCSV(o, data, depositColumns, appendDeposit(&deposits))
+			return deposits
+		},
+		parseTransfers: func(o *origin, data string) (transfers []*types.Transfer) {
+			parseCSV(o, data, transferColumns, appendTransfer(&transfers))
+			return transfers
+		},
+		parseKeys: func(o *origin, data string) (keys []*types.IdempotencyKey) {
+			parseCSV(o, data, keyColumns, appendKey(&keys))
+			return keys
+		},
+		parseRefunds: func(o *origin, data string) (refunds []*types.Refund) {
+			parseCSV(o, data, refundColumns, appendRefund(&refunds))
+			return refunds
+		},
+	},
+}
+
+// codecOf returns the codec of format.
+func codecOf(format Format) (codec, error) {
+	c, ok := codecs[format]
+	if !ok {
+		return codec{}, ErrUnknownFormat
+	}
+	return c, nil
+}
+
+// names returns the names of the accounts, payments, favorites, postings,
+// deposits, transfers, keys and refunds files.
+func (c codec) names() []string {
+	return []string{
+		accountRecords + "." + c.ext,
+		paymentRecords + "." + c.ext,
+		favoriteRecords + "." + c.ext,
+		postingRecords + "." + c.ext,
+		depositRecords + "." + c.ext,
+		transferRecords + "." + c.ext,
+		keyRecords + "." + c.ext,
+		refundRecords + "." + c.ext,
+	}
+}
+
+func encodeJSON(records interface{}) (string, error) {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// parseJSON calls parse for every element of a JSON array; an empty file has
+// no records.
+func parseJSON(o *origin, data string, parse func(raw []byte) error) {
+	if strings.TrimSpace(data) == "" {
+		return
+	}
+
+	dec := json.NewDecoder(strings.NewReader(data))
+	token, err := dec.Token()
+	if err != nil {
+		o.fail(jsonErrorLine(data, dec, err), err)
+		return
+	}
+	if token != json.Delim('[') {
+		o.fail(lineAt(data, 0), ErrJSONArray)
+		return
+	}
+	for dec.More() {
+		line := lineAt(data, dec.InputOffset())
+		raw := json.RawMessage{}
+		err := dec.Decode(&raw)
+		if err != nil {
+			// the rest of the array can not be found after a syntax error
+			o.fail(jsonErrorLine(data, dec, err), err)
+			return
+		}
+		err = parse(raw)
+		if err != nil {
+			o.fail(line, err)
+			continue
+		}
+		o.parsed(line)
+	}
+}
+
+// lineAt returns the line of the first value at or after offset.
+func lineAt(data string, offset int64) int {
+	i := int(offset)
+	for i < len(data) && strings.IndexByte(" \t\r\n,", data[i]) >= 0 {
+		i++
+	}
+	return strings.Count(data[:i], "\n") + 1
+}
+
+func jsonErrorLine(data string, dec *json.Decoder, err error) int {
+	if syntax, ok := err.(*json.SyntaxError); ok && syntax.Offset <= int64(len(data)) {
+		return strings.Count(data[:syntax.Offset], "\n") + 1
+	}
+	return lineAt(data, dec.InputOffset())
+}
+
+// unmarshalAccount and the other unmarshal funcs return a parse func for
+// parseJSON and parseJSONL collecting the records into the given slice.
+func unmarshalAccount(accounts *[]*types.Account) func(raw []byte) error {
+	return func(raw []byte) error {
+		account := &types.Account{}
+		err := json.Unmarshal(raw, account)
+		if err != nil {
+			return err
+		}
+		if account.Currency == "" {
+			account.Currency = types.DefaultCurrency
+		}
+		if account.Status == "" {
+			account.Status = types.AccountStatusActive
+		}
+		*accounts = append(*accounts, account)
+		return nil
+	}
+}
+
+func unmarshalPayment(payments *[]*types.Payment) func(raw []byte) error {
+	return func(raw []byte) error {
+		payment := &types.Payment{}
+		err := json.Unmarshal(raw, payment)
+		if err != nil {
+			return err
+		}
+		// refunds are kept in their own file, not with the payment
+		payment.Refunds = nil
+		if payment.Currency == "" {
+			payment.Currency = types.DefaultCurrency
+		}
+		*payments = append(*payments, payment)
+		return nil
+	}
+}
+
+func unmarshalFavorite(favorites *[]*types.Favorite) func(raw []byte) error {
+	return func(raw []byte) error {
+		favorite := &types.Favorite{}
+		err := json.Unmarshal(raw, favorite)
+		if err != nil {
+			return err
+		}
+		*favorites = append(*favorites, favorite)
+		return nil
+	}
+}
+
+func unmarshalPosting(postings *[]*types.Posting) func(raw []byte) error {
+	return func(raw []byte) error {
+		posting := &types.Posting{}
+		err := json.Unmarshal(raw, posting)
+		if err != nil {
+			return err
+		}
+		*postings = append(*postings, posting)
+		return nil
+	}
+}
+
+func unmarshalDeposit(deposits *[]*types.Deposit) func(raw []byte) error {
+	return func(raw []byte) error {
+		deposit := &types.Deposit{}
+		err := json.Unmarshal(raw, deposit)
+		if err != nil {
+			return err
+		}
+		*deposits = append(*deposits, deposit)
+		return nil
+	}
+}
+
+func unmarshalTransfer(transfers *[]*types.Transfer) func(raw []byte) error {
+	return func(raw []byte) error {
+		transfer := &types.Transfer{}
+		err := json.Unmarshal(raw, transfer)
+		if err != nil {
+			return err
+		}
+		if transfer.ToAmount == 0 {
+			transfer.ToAmount = transfer.Amount
+		}
+		*transfers = append(*transfers, transfer)
+		return nil
+	}
+}
+
+func unmarshalKey(keys *[]*types.IdempotencyKey) func(raw []byte) error {
+	return func(raw []byte) error {
+		key := &types.IdempotencyKey{}
+		err := json.Unmarshal(raw, key)
+		if err != nil {
+			return err
+		}
+		*keys = append(*keys, key)
+		return nil
+	}
+}
+
+func unmarshalRefund(refunds *[]*types.Refund) func(raw []byte) error {
+	return func(raw []byte) error {
+		refund := &types.Refund{}
+		err := json.Unmarshal(raw, refund)
+		if err != nil {
+			return err
+		}
+		*refunds = append(*refunds, refund)
+		return nil
+	}
+}
+
+func encodeJSONL(records []interface{}) (string, error) {
+	b := strings.Builder{}
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return "", err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// parseJSONL calls parse for every line that is not blank.
+func parseJSONL(o *origin, data string, parse func(raw []byte) error) {
+	for i, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		err := parse([]byte(line))
+		if err != nil {
+			o.fail(i+1, err)
+			continue
+		}
+		o.parsed(i + 1)
+	}
+}
+
+func encodeCSV(columns []string, records [][]string) (string, error) {
+	buf := bytes.Buffer{}
+	w := csv.NewWriter(&buf)
+	err := w.Write(columns)
+	if err != nil {
+		return "", err
+	}
+	err = w.WriteAll(records)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parseCSV checks the header row and calls parse for every other row. The
+// header must name exactly columns, in order; rows may still lack the last
+// fields, like dump records, and parse decides if they are needed. A row is
+// reported at the physical line it starts on, counting the line breaks inside
+// quoted fields and the blank lines csv skips.
+func parseCSV(o *origin, data string, columns []string, parse func(fields []string) error) {
+	r := csv.NewReader(strings.NewReader(data))
+	r.FieldsPerRecord = -1
+
+	lines := strings.Split(data, "\n")
+	// skipBlank returns the first line from line on that csv does not skip
+	skipBlank := func(line int) int {
+		for line <= len(lines) && strings.TrimSuffix(lines[line-1], "\r") == "" {
+			line++
+		}
+		return line
+	}
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return
+	}
+	line := skipBlank(1)
+	if err == nil {
+		err = checkCSVHeader(header, columns)
+	}
+	if err != nil {
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			o.fail(parseErr.StartLine, parseErr.Err)
+		} else {
+			o.fail(line, err)
+		}
+		return
+	}
+
+	line += lineBreaks(header)
+	for {
+		fields, err := r.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				o.fail(parseErr.StartLine, parseErr.Err)
+			} else {
+				o.fail(skipBlank(line+1), err)
+			}
+			// the reader can not find the next row after a syntax error
+			return
+		}
+		line = skipBlank(line + 1)
+
+		err = parse(fields)
+		if err != nil {
+			o.fail(line, err)
+		} else {
+			o.parsed(line)
+		}
+		line += lineBreaks(fields)
+	}
+}
+
+func checkCSVHeader(header []string, columns []string) error {
+	if len(header) != len(columns) {
+		return ErrCSVHeader
+	}
+	for i, column := range columns {
+		if strings.TrimSpace(header[i]) != column {
+			return ErrCSVHeader
+		}
+	}
+	return nil
+}
+
+func lineBreaks(fields []string) int {
+	n := 0
+	for _, field := range fields {
+		n += strings.Count(field, "\n")
+	}
+	return n
+}
@@ -0,0 +1,190 @@
+package wallet
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_ExportFormat_ImportFormat_roundTrip(t *testing.T) {
+	s := newTestService()
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.FavoritePayment(payments[0].ID, `rent, "flat 7"; second line`+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pay takes any category, and so must the import of its export
+	pharmacy, err := s.Pay(account.ID, 300, "pharmacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.FavoritePayment(pharmacy.ID, "pills")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.DepositFrom(account.ID, 500, "card; *1234", "rrn\n42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.RegisterAccount("+992938151003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Transfer(account.ID, other.ID, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Refund(payments[0].ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetLimits(account.ID, &types.Limits{
+		MaxBalance: 50_000_00,
+		Spending: []types.SpendingLimit{
+			{Period: types.LimitPeriodDay, Category: "auto", Amount: 700},
+			{Period: types.LimitPeriodMonth, Amount: 1000},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetOverdraft(other.ID, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.FreezeAccount(other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.UpdateProfile(account.ID, types.Profile{Name: "Rustam; \"Rusik\"\nSharipov", Email: "rustam@example.tj", Locale: "tg-TJ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []Format{FormatDump, FormatJSON, FormatJSONL, FormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			// the other formats must not disturb the export
+			dir := t.TempDir()
+			for _, other := range []Format{FormatDump, FormatJSON, FormatJSONL, FormatCSV} {
+				err := s.ExportFormat(dir, other)
+				if err != nil {
+					t.Fatalf("ExportFormat(%v): error = %v", other, err)
+				}
+			}
+
+			restored := newTestService()
+			err = restored.ImportFormat(dir, format)
+			if err != nil {
+				t.Fatalf("ImportFormat(): error = %v", err)
+			}
+			assertSameState(t, restored.Service, s.Service)
+		})
+	}
+}
+
+func TestService_ImportFormat_sharesValidation(t *testing.T) {
+	s := newTestService()
+
+	_, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	data := "id,phone,balance,currency,max_payment,max_balance,spending,overdraft,status,name,email,locale,created\n7," + string(defaultTestAccount.phone) + ",100\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "accounts.csv"), []byte(data), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.ImportFormat(dir, FormatCSV)
+	if !errors.Is(err, ErrPhoneNumberRegistred) {
+		t.Errorf("ImportFormat(): error = %v, want %v", err, ErrPhoneNumberRegistred)
+	}
+}
+
+func TestService_ImportFormat_csvHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"other order", "phone,id,balance"},
+		{"last columns missing", "id,phone,balance"},
+		{"extra column", "id,phone,balance,currency,max_payment,max_balance,spending,overdraft,status,name,email,locale,created,note"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService()
+			dir := writeTestFiles(t, map[string]string{"accounts.csv": tt.header + "\n"})
+			err := s.ImportFormat(dir, FormatCSV)
+			if !errors.Is(err, ErrCSVHeader) {
+				t.Errorf("ImportFormat(): error = %v, want %v", err, ErrCSVHeader)
+			}
+		})
+	}
+}
+
+func TestService_ImportFormat_csvWithoutLastFields(t *testing.T) {
+	s := newTestService()
+
+	// rows written before payments had times
+	dir := writeTestFiles(t, map[string]string{
+		"accounts.csv": "id,phone,balance,currency,max_payment,max_balance,spending,overdraft,status,name,email,locale,created\n1,+992938151007,100\n",
+		"payments.csv": "id,account_id,amount,category,status,created,updated,currency,original_amount,original_currency,rate,fees,fee_account\np1,1,10,auto,OK\n",
+	})
+	err := s.ImportFormat(dir, FormatCSV)
+	if err != nil {
+		t.Fatalf("ImportFormat(): error = %v", err)
+	}
+	payment, err := s.FindPaymentByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !payment.Created.IsZero() || !payment.Updated.IsZero() {
+		t.Errorf("ImportFormat(): payment times = %v, %v, want zero", payment.Created, payment.Updated)
+	}
+}
+
+func TestService_ExportFormat_unknown(t *testing.T) {
+	s := newTestService()
+
+	err := s.ExportFormat(t.TempDir(), "xml")
+	if err != ErrUnknownFormat {
+		t.Errorf("ExportFormat(): error = %v, want %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestService_HistoryToFilesFormat_csv(t *testing.T) {
+	s := newTestService()
+
+	payments := []types.Payment{
+		{ID: "1", AccountID: 1, Amount: 10, Category: "auto", Status: types.PaymentStatusOk},
+		{ID: "2", AccountID: 1, Amount: 20, Category: "auto", Status: types.PaymentStatusOk},
+		{ID: "3", AccountID: 1, Amount: 30, Category: "auto", Status: types.PaymentStatusOk, Currency: types.CurrencyTJS},
+	}
+	dir := t.TempDir()
+	err := s.HistoryToFilesFormat(payments, dir, 2, FormatCSV)
+	if err != nil {
+		t.Fatalf("HistoryToFilesFormat(): error = %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "payments2.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id,account_id,amount,category,status,created,updated,currency,original_amount,original_currency,rate,fees,fee_account\n" +
+		"3,1,30,auto,OK,,,TJS,,,,,\n"
+	if string(data) != want {
+		t.Errorf("payments2.csv = %q, want %q", data, want)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "payments1.csv")); err != nil {
+		t.Error(err)
+	}
+}
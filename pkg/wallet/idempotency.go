@@ -0,0 +1,135 @@
+package wallet
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// DefaultIdempotencyWindow is how long an idempotency key is kept in mind
+// unless the service is created WithIdempotencyWindow.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+var ErrKeyReused = errors.New("idempotency key was used for another request")
+
+// Operations an idempotency key can be used with.
+const (
+	operationPay     = "pay"
+	operationRepeat  = "repeat"
+	operationDeposit = "deposit"
+)
+
+func knownKeyOperation(operation string) bool {
+	switch operation {
+	case operationPay, operationRepeat, operationDeposit:
+		return true
+	}
+	return false
+}
+
+// request is an operation asked for with an idempotency key. The zero request
+// has no key and is never looked up or remembered.
+type request struct {
+	key       string
+	operation string
+	// params are the parameters of the request, which a retry must repeat
+	params string
+}
+
+// PayWithKey is Pay made safe to retry: a request with the key of an earlier
+// successful one returns the payment it made instead of paying again. The
+// key belongs to the account and is kept in mind for the idempotency window.
+// Using it again with other parameters gives ErrKeyReused. An empty key is
+// the same as Pay.
+func (s *Service) PayWithKey(key string, accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	return s.pay(accountID, amount, "", category, request{
+		key:       key,
+		operation: operationPay,
+		params:    joinFields([]string{strconv.FormatInt(int64(amount), 10), string(category)}),
+	})
+}
+
+// RepeatWithKey is Repeat made safe to retry, like PayWithKey.
+func (s *Service) RepeatWithKey(key string, paymentID string) (*types.Payment, error) {
+	return s.repeat(paymentID, request{key: key, operation: operationRepeat, params: paymentID})
+}
+
+// DepositWithKey is DepositFrom made safe to retry, like PayWithKey.
+func (s *Service) DepositWithKey(key string, accountID int64, amount types.Money, source string, reference string) (*types.Deposit, error) {
+	return s.deposit(accountID, amount, source, reference, request{
+		key:       key,
+		operation: operationDeposit,
+		params:    joinFields([]string{strconv.FormatInt(int64(amount), 10), source, reference}),
+	})
+}
+
+// window returns how long idempotency keys are kept in mind.
+func (s *Service) window() time.Duration {
+	if s.idempotencyWindow <= 0 {
+		return DefaultIdempotencyWindow
+	}
+	return s.idempotencyWindow
+}
+
+func (s *Service) expired(key *types.IdempotencyKey) bool {
+	return !s.now().Before(key.Created.Add(s.window()))
+}
+
+// recall returns the ID of the record made by an earlier request with the
+// key of r, or "" if there was none or its key has expired. The caller holds
+// the lock of the account.
+func (s *Service) recall(accountID int64, r request) (string, error) {
+	if r.key == "" {
+		return "", nil
+	}
+
+	stored, err := s.repo().Keys().ByKey(accountID, r.key)
+	if err == ErrKeyNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if s.expired(stored) {
+		return "", nil
+	}
+	if stored.Operation != r.operation || stored.Request != r.params {
+		return "", ErrKeyReused
+	}
+	return stored.RecordID, nil
+}
+
+// remember returns the key to commit together with the record made by r,
+// none if r has no key. An expired key with the same name is replaced.
+func (s *Service) remember(accountID int64, r request, recordID string) []*types.IdempotencyKey {
+	if r.key == "" {
+		return nil
+	}
+	return []*types.IdempotencyKey{{
+		Key:       r.key,
+		AccountID: accountID,
+		Operation: r.operation,
+		Request:   r.params,
+		RecordID:  recordID,
+		Created:   s.now(),
+	}}
+}
+
+// liveKeys returns the keys that have not expired, which is all that exports
+// and snapshots keep.
+func (s *Service) liveKeys() ([]*types.IdempotencyKey, error) {
+	keys, err := s.repo().Keys().All()
+	if err != nil {
+		return nil, err
+	}
+
+	live := []*types.IdempotencyKey{}
+	for _, key := range keys {
+		if !s.expired(key) {
+			live = append(live, key)
+		}
+	}
+	return live, nil
+}
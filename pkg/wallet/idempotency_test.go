@@ -0,0 +1,150 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_PayWithKey(t *testing.T) {
+	created := time.Date(2021, 3, 8, 10, 0, 0, 0, time.UTC)
+	now := created
+	s := &testService{Service: NewService(
+		WithClock(func() time.Time { return now }),
+		WithIdempotencyWindow(time.Hour),
+	)}
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := s.PayWithKey("k1", account.ID, 10, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatalf("PayWithKey(): error = %v", err)
+	}
+	retried, err := s.PayWithKey("k1", account.ID, 10, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatalf("PayWithKey() retried: error = %v", err)
+	}
+	if retried.ID != payment.ID || s.balance(account) != 90 {
+		t.Errorf("PayWithKey() retried: payment = %v, balance = %v, want %v, 90", retried.ID, s.balance(account), payment.ID)
+	}
+
+	_, err = s.PayWithKey("k1", account.ID, 20, types.PaymentCategoryFood)
+	if err != ErrKeyReused {
+		t.Errorf("PayWithKey() with other amount: error = %v, want %v", err, ErrKeyReused)
+	}
+	_, err = s.RepeatWithKey("k1", payment.ID)
+	if err != ErrKeyReused {
+		t.Errorf("RepeatWithKey() with a pay key: error = %v, want %v", err, ErrKeyReused)
+	}
+
+	// keys belong to the account
+	other, err := s.addAccountWithBalance("+992938151003", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.PayWithKey("k1", other.ID, 20, types.PaymentCategoryFood)
+	if err != nil || s.balance(other) != 80 {
+		t.Errorf("PayWithKey() on another account: balance = %v, error = %v, want 80, nil", s.balance(other), err)
+	}
+
+	// an expired key pays again
+	now = created.Add(time.Hour)
+	again, err := s.PayWithKey("k1", account.ID, 20, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatalf("PayWithKey() after the window: error = %v", err)
+	}
+	if again.ID == payment.ID || s.balance(account) != 70 {
+		t.Errorf("PayWithKey() after the window: payment = %v, balance = %v, want a new one, 70", again.ID, s.balance(account))
+	}
+
+	// no key, no idempotency
+	_, err = s.PayWithKey("", account.ID, 10, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.PayWithKey("", account.ID, 10, types.PaymentCategoryFood)
+	if err != nil || s.balance(account) != 50 {
+		t.Errorf("PayWithKey() without a key: balance = %v, error = %v, want 50, nil", s.balance(account), err)
+	}
+}
+
+func TestService_RepeatWithKey(t *testing.T) {
+	s := newTestService()
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance := s.balance(account)
+
+	first, err := s.RepeatWithKey("r1", payments[0].ID)
+	if err != nil {
+		t.Fatalf("RepeatWithKey(): error = %v", err)
+	}
+	second, err := s.RepeatWithKey("r1", payments[0].ID)
+	if err != nil {
+		t.Fatalf("RepeatWithKey() retried: error = %v", err)
+	}
+	if first.ID != second.ID || s.balance(account) != balance-payments[0].Amount {
+		t.Errorf("RepeatWithKey() retried: payments = %v, %v, balance = %v", first.ID, second.ID, s.balance(account))
+	}
+}
+
+func TestService_DepositWithKey(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deposit, err := s.DepositWithKey("d1", account.ID, 100, "card", "4242")
+	if err != nil {
+		t.Fatalf("DepositWithKey(): error = %v", err)
+	}
+	retried, err := s.DepositWithKey("d1", account.ID, 100, "card", "4242")
+	if err != nil {
+		t.Fatalf("DepositWithKey() retried: error = %v", err)
+	}
+	if retried.ID != deposit.ID || s.balance(account) != 110 {
+		t.Errorf("DepositWithKey() retried: deposit = %v, balance = %v, want %v, 110", retried.ID, s.balance(account), deposit.ID)
+	}
+	_, err = s.DepositWithKey("d1", account.ID, 100, "card", "0000")
+	if err != ErrKeyReused {
+		t.Errorf("DepositWithKey() with other reference: error = %v, want %v", err, ErrKeyReused)
+	}
+}
+
+func TestService_Export_Import_keys(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.PayWithKey("k1", account.ID, 10, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): error = %v", err)
+	}
+	restored := newTestService()
+	err = restored.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): error = %v", err)
+	}
+	assertSameState(t, restored.Service, s.Service)
+
+	retried, err := restored.PayWithKey("k1", account.ID, 10, types.PaymentCategoryFood)
+	if err != nil || retried.ID != payment.ID {
+		t.Errorf("PayWithKey() after Import: payment = %v, error = %v, want %v", retried, err, payment.ID)
+	}
+}
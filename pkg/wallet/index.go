@@ -0,0 +1,377 @@
+package wallet
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// index keeps map-based lookups over the MemoryRepository slices, so finding
+// an account, a payment or a favorite does not scan the whole slice. It is not
+// safe for concurrent use on its own and is guarded by MemoryRepository.mu.
+type index struct {
+	accountsByID      map[int64]*types.Account
+	accountsByPhone   map[types.Phone]*types.Account
+	paymentsByID      map[string]*types.Payment
+	paymentsByAccount map[int64][]*types.Payment
+	favoritesByID     map[string]*types.Favorite
+	postingsByID      map[string]*types.Posting
+	postingsByAccount map[int64][]*types.Posting
+	depositsByID      map[string]*types.Deposit
+	depositsByAccount map[int64][]*types.Deposit
+	transfersByID     map[string]*types.Transfer
+	// transfers are listed under both of their accounts
+	transfersByAccount map[int64][]*types.Transfer
+	keysByID           map[keyID]*types.IdempotencyKey
+	refundsByID        map[string]*types.Refund
+	refundsByPayment   map[string][]*types.Refund
+
+	// the indexed values, so a changed record can be moved to its new keys
+	phoneOf   map[int64]types.Phone
+	accountOf map[string]int64
+	createdOf map[string]time.Time
+}
+
+func (i *index) init() {
+	if i.accountsByID != nil {
+		return
+	}
+	i.accountsByID = make(map[int64]*types.Account)
+	i.accountsByPhone = make(map[types.Phone]*types.Account)
+	i.paymentsByID = make(map[string]*types.Payment)
+	i.paymentsByAccount = make(map[int64][]*types.Payment)
+	i.favoritesByID = make(map[string]*types.Favorite)
+	i.postingsByID = make(map[string]*types.Posting)
+	i.postingsByAccount = make(map[int64][]*types.Posting)
+	i.depositsByID = make(map[string]*types.Deposit)
+	i.depositsByAccount = make(map[int64][]*types.Deposit)
+	i.transfersByID = make(map[string]*types.Transfer)
+	i.transfersByAccount = make(map[int64][]*types.Transfer)
+	i.keysByID = make(map[keyID]*types.IdempotencyKey)
+	i.refundsByID = make(map[string]*types.Refund)
+	i.refundsByPayment = make(map[string][]*types.Refund)
+	i.phoneOf = make(map[int64]types.Phone)
+	i.accountOf = make(map[string]int64)
+	i.createdOf = make(map[string]time.Time)
+}
+
+func (i *index) addAccount(account *types.Account) {
+	i.init()
+	i.accountsByID[account.ID] = account
+	i.accountsByPhone[account.Phone] = account
+	i.phoneOf[account.ID] = account.Phone
+}
+
+// reindexAccount moves an indexed account to its current phone.
+func (i *index) reindexAccount(account *types.Account) {
+	old := i.phoneOf[account.ID]
+	if old == account.Phone {
+		return
+	}
+	if i.accountsByPhone[old] == account {
+		delete(i.accountsByPhone, old)
+	}
+	i.accountsByPhone[account.Phone] = account
+	i.phoneOf[account.ID] = account.Phone
+}
+
+func (i *index) removeAccount(account *types.Account) {
+	delete(i.accountsByID, account.ID)
+	if i.accountsByPhone[i.phoneOf[account.ID]] == account {
+		delete(i.accountsByPhone, i.phoneOf[account.ID])
+	}
+	delete(i.phoneOf, account.ID)
+}
+
+func (i *index) account(accountID int64) *types.Account {
+	return i.accountsByID[accountID]
+}
+
+func (i *index) accountByPhone(phone types.Phone) *types.Account {
+	return i.accountsByPhone[phone]
+}
+
+func (i *index) addPayment(payment *types.Payment) {
+	i.init()
+	i.paymentsByID[payment.ID] = payment
+	i.linkPayment(payment)
+}
+
+// linkPayment adds the payment to the payments of its account, which are
+// kept in creation order. Payments created at the same time stay in the
+// order they were saved.
+func (i *index) linkPayment(payment *types.Payment) {
+	payments := i.paymentsByAccount[payment.AccountID]
+	at := len(payments)
+	for at > 0 && payment.Created.Before(payments[at-1].Created) {
+		at--
+	}
+	payments = append(payments, nil)
+	copy(payments[at+1:], payments[at:])
+	payments[at] = payment
+	i.paymentsByAccount[payment.AccountID] = payments
+	i.accountOf[payment.ID] = payment.AccountID
+	i.createdOf[payment.ID] = payment.Created
+}
+
+// reindexPayment moves an indexed payment to its current account and
+// creation time.
+func (i *index) reindexPayment(payment *types.Payment) {
+	old := i.accountOf[payment.ID]
+	if old == payment.AccountID && i.createdOf[payment.ID].Equal(payment.Created) {
+		return
+	}
+	i.unlinkPayment(payment, old)
+	i.linkPayment(payment)
+}
+
+func (i *index) removePayment(payment *types.Payment) {
+	i.unlinkPayment(payment, i.accountOf[payment.ID])
+	delete(i.paymentsByID, payment.ID)
+	delete(i.accountOf, payment.ID)
+	delete(i.createdOf, payment.ID)
+}
+
+// unlinkPayment drops the payment from the payments of the account.
+func (i *index) unlinkPayment(payment *types.Payment, accountID int64) {
+	payments := i.paymentsByAccount[accountID]
+	for j, p := range payments {
+		if p == payment {
+			payments = append(payments[:j:j], payments[j+1:]...)
+			break
+		}
+	}
+	if len(payments) == 0 {
+		delete(i.paymentsByAccount, accountID)
+	} else {
+		i.paymentsByAccount[accountID] = payments
+	}
+}
+
+func (i *index) payment(paymentID string) *types.Payment {
+	return i.paymentsByID[paymentID]
+}
+
+// accountPayments returns the payments of the account in creation order.
+func (i *index) accountPayments(accountID int64) []*types.Payment {
+	return i.paymentsByAccount[accountID]
+}
+
+// accountPaymentsSince returns the payments of the account created at since
+// or later, in creation order.
+func (i *index) accountPaymentsSince(accountID int64, since time.Time) []*types.Payment {
+	payments := i.paymentsByAccount[accountID]
+	first := sort.Search(len(payments), func(j int) bool {
+		return !payments[j].Created.Before(since)
+	})
+	return payments[first:]
+}
+
+func (i *index) addFavorite(favorite *types.Favorite) {
+	i.init()
+	i.favoritesByID[favorite.ID] = favorite
+}
+
+func (i *index) removeFavorite(favorite *types.Favorite) {
+	delete(i.favoritesByID, favorite.ID)
+}
+
+func (i *index) favorite(favoriteID string) *types.Favorite {
+	return i.favoritesByID[favoriteID]
+}
+
+// addPosting indexes the posting under both of its ledger accounts.
+func (i *index) addPosting(posting *types.Posting) {
+	i.init()
+	i.postingsByID[posting.ID] = posting
+	i.linkPosting(posting)
+}
+
+func (i *index) linkPosting(posting *types.Posting) {
+	i.postingsByAccount[posting.Debit] = append(i.postingsByAccount[posting.Debit], posting)
+	if posting.Credit != posting.Debit {
+		i.postingsByAccount[posting.Credit] = append(i.postingsByAccount[posting.Credit], posting)
+	}
+}
+
+// unlinkPosting drops the posting from the postings of its ledger accounts.
+// It is given the stored version, whose accounts are the indexed ones.
+func (i *index) unlinkPosting(posting *types.Posting, debit int64, credit int64) {
+	for _, accountID := range []int64{debit, credit} {
+		postings := i.postingsByAccount[accountID]
+		for j, p := range postings {
+			if p == posting {
+				postings = append(postings[:j:j], postings[j+1:]...)
+				break
+			}
+		}
+		if len(postings) == 0 {
+			delete(i.postingsByAccount, accountID)
+		} else {
+			i.postingsByAccount[accountID] = postings
+		}
+	}
+}
+
+func (i *index) removePosting(posting *types.Posting) {
+	i.unlinkPosting(posting, posting.Debit, posting.Credit)
+	delete(i.postingsByID, posting.ID)
+}
+
+func (i *index) posting(postingID string) *types.Posting {
+	return i.postingsByID[postingID]
+}
+
+// accountPostings returns the postings of the ledger account in creation order.
+func (i *index) accountPostings(accountID int64) []*types.Posting {
+	return i.postingsByAccount[accountID]
+}
+
+func (i *index) addDeposit(deposit *types.Deposit) {
+	i.init()
+	i.depositsByID[deposit.ID] = deposit
+	i.linkDeposit(deposit)
+}
+
+func (i *index) linkDeposit(deposit *types.Deposit) {
+	i.depositsByAccount[deposit.AccountID] = append(i.depositsByAccount[deposit.AccountID], deposit)
+}
+
+// unlinkDeposit drops the deposit from the deposits of its account. It is
+// given the stored version, whose account is the indexed one.
+func (i *index) unlinkDeposit(deposit *types.Deposit) {
+	deposits := i.depositsByAccount[deposit.AccountID]
+	for j, d := range deposits {
+		if d == deposit {
+			deposits = append(deposits[:j:j], deposits[j+1:]...)
+			break
+		}
+	}
+	if len(deposits) == 0 {
+		delete(i.depositsByAccount, deposit.AccountID)
+	} else {
+		i.depositsByAccount[deposit.AccountID] = deposits
+	}
+}
+
+func (i *index) removeDeposit(deposit *types.Deposit) {
+	i.unlinkDeposit(deposit)
+	delete(i.depositsByID, deposit.ID)
+}
+
+func (i *index) deposit(depositID string) *types.Deposit {
+	return i.depositsByID[depositID]
+}
+
+// accountDeposits returns the deposits of the account in creation order.
+func (i *index) accountDeposits(accountID int64) []*types.Deposit {
+	return i.depositsByAccount[accountID]
+}
+
+func (i *index) addTransfer(transfer *types.Transfer) {
+	i.init()
+	i.transfersByID[transfer.ID] = transfer
+	i.linkTransfer(transfer)
+}
+
+func (i *index) linkTransfer(transfer *types.Transfer) {
+	i.transfersByAccount[transfer.FromAccountID] = append(i.transfersByAccount[transfer.FromAccountID], transfer)
+	if transfer.ToAccountID != transfer.FromAccountID {
+		i.transfersByAccount[transfer.ToAccountID] = append(i.transfersByAccount[transfer.ToAccountID], transfer)
+	}
+}
+
+// unlinkTransfer drops the transfer from the transfers of its accounts. It is
+// given the stored version, whose accounts are the indexed ones.
+func (i *index) unlinkTransfer(transfer *types.Transfer) {
+	for _, accountID := range []int64{transfer.FromAccountID, transfer.ToAccountID} {
+		transfers := i.transfersByAccount[accountID]
+		for j, t := range transfers {
+			if t == transfer {
+				transfers = append(transfers[:j:j], transfers[j+1:]...)
+				break
+			}
+		}
+		if len(transfers) == 0 {
+			delete(i.transfersByAccount, accountID)
+		} else {
+			i.transfersByAccount[accountID] = transfers
+		}
+	}
+}
+
+func (i *index) removeTransfer(transfer *types.Transfer) {
+	i.unlinkTransfer(transfer)
+	delete(i.transfersByID, transfer.ID)
+}
+
+func (i *index) transfer(transferID string) *types.Transfer {
+	return i.transfersByID[transferID]
+}
+
+// accountTransfers returns the transfers from or to the account in creation
+// order.
+func (i *index) accountTransfers(accountID int64) []*types.Transfer {
+	return i.transfersByAccount[accountID]
+}
+
+// keyID identifies an idempotency key, as keys are unique per account.
+type keyID struct {
+	accountID int64
+	key       string
+}
+
+func (i *index) addKey(key *types.IdempotencyKey) {
+	i.init()
+	i.keysByID[keyID{key.AccountID, key.Key}] = key
+}
+
+func (i *index) removeKey(key *types.IdempotencyKey) {
+	delete(i.keysByID, keyID{key.AccountID, key.Key})
+}
+
+func (i *index) key(accountID int64, key string) *types.IdempotencyKey {
+	return i.keysByID[keyID{accountID, key}]
+}
+
+func (i *index) addRefund(refund *types.Refund) {
+	i.init()
+	i.refundsByID[refund.ID] = refund
+	i.linkRefund(refund)
+}
+
+func (i *index) linkRefund(refund *types.Refund) {
+	i.refundsByPayment[refund.PaymentID] = append(i.refundsByPayment[refund.PaymentID], refund)
+}
+
+// unlinkRefund drops the refund from the refunds of its payment. It is given
+// the stored version, whose payment is the indexed one.
+func (i *index) unlinkRefund(refund *types.Refund) {
+	refunds := i.refundsByPayment[refund.PaymentID]
+	for j, r := range refunds {
+		if r == refund {
+			refunds = append(refunds[:j:j], refunds[j+1:]...)
+			break
+		}
+	}
+	if len(refunds) == 0 {
+		delete(i.refundsByPayment, refund.PaymentID)
+	} else {
+		i.refundsByPayment[refund.PaymentID] = refunds
+	}
+}
+
+func (i *index) removeRefund(refund *types.Refund) {
+	i.unlinkRefund(refund)
+	delete(i.refundsByID, refund.ID)
+}
+
+func (i *index) refund(refundID string) *types.Refund {
+	return i.refundsByID[refundID]
+}
+
+// paymentRefunds returns the refunds of the payment in creation order.
+func (i *index) paymentRefunds(paymentID string) []*types.Refund {
+	return i.refundsByPayment[paymentID]
+}
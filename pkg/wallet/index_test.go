@@ -0,0 +1,272 @@
+package wallet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_index_afterImport(t *testing.T) {
+	s := newTestService()
+
+	first, err := s.addAccountWithBalance("+992938151001", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.addAccountWithBalance("+992938151002", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(first.ID, 10, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	accounts := fmt.Sprintf("%v;+992938151009;100;\n", first.ID)
+	payments := fmt.Sprintf("%v;%v;10;Food;INPROGRESS;\n", payment.ID, second.ID)
+	favorites := fmt.Sprintf("fav;%v;lunch;10;Food;\n", second.ID)
+	for name, data := range map[string]string{
+		"accounts.dump":  accounts,
+		"payments.dump":  payments,
+		"favorites.dump": favorites,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Import(dir); err != nil {
+		t.Fatalf("Import(): error = %v", err)
+	}
+
+	if _, err := s.RegisterAccount("+992938151001"); err != nil {
+		t.Errorf("RegisterAccount(): old phone must be free after import, error = %v", err)
+	}
+	if _, err := s.RegisterAccount("+992938151009"); err != ErrPhoneNumberRegistred {
+		t.Errorf("RegisterAccount(): imported phone must be taken, error = %v", err)
+	}
+
+	history, err := s.ExportAccountHistory(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("ExportAccountHistory(%v) = %v, want no payments", first.ID, history)
+	}
+	history, err = s.ExportAccountHistory(second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].ID != payment.ID {
+		t.Errorf("ExportAccountHistory(%v) = %v, want moved payment", second.ID, history)
+	}
+
+	if _, err := s.FindFavoriteByID("fav"); err != nil {
+		t.Errorf("FindFavoriteByID(): error = %v", err)
+	}
+}
+
+// newBenchService fills a service with accounts and payments without going
+// through Pay, so setup stays cheap for large sizes.
+func newBenchService(accounts, payments int) *Service {
+	repository := NewMemoryRepository()
+	for i := 1; i <= accounts; i++ {
+		repository.Accounts().Save(&types.Account{
+			ID:    int64(i),
+			Phone: types.Phone(fmt.Sprintf("+992%09d", i)),
+		})
+	}
+	for i := 0; i < payments; i++ {
+		repository.Payments().Save(&types.Payment{
+			ID:        fmt.Sprintf("payment-%d", i),
+			AccountID: int64(i%accounts + 1),
+			Amount:    1,
+		})
+	}
+	return NewService(WithRepository(repository))
+}
+
+// scanAccountByID and scanPaymentByID are the lookups the service used before
+// it had an index; they are kept here to compare against.
+func scanAccountByID(accounts []*types.Account, accountID int64) *types.Account {
+	for _, account := range accounts {
+		if account.ID == accountID {
+			return account
+		}
+	}
+	return nil
+}
+
+func TestMemoryRepository_ByAccountSince(t *testing.T) {
+	r := NewMemoryRepository()
+	start := time.Date(2021, 3, 8, 0, 0, 0, 0, time.UTC)
+	// an import may save older payments after newer ones
+	for i, created := range []time.Time{{}, start.Add(-time.Hour), start, start.Add(time.Hour), start.Add(-time.Minute), start.Add(time.Minute)} {
+		err := r.Payments().Save(&types.Payment{ID: fmt.Sprint(i), AccountID: 1, Created: created})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := r.Payments().Save(&types.Payment{ID: "other", AccountID: 2, Created: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payments, err := r.Payments().ByAccountSince(1, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, payment := range payments {
+		got = append(got, payment.ID)
+	}
+	if !reflect.DeepEqual(got, []string{"2", "5", "3"}) {
+		t.Errorf("ByAccountSince() = %v, want [2 5 3]", got)
+	}
+}
+
+func TestMemoryRepository_copies(t *testing.T) {
+	r := NewMemoryRepository()
+	payment := &types.Payment{ID: "1", AccountID: 1, Status: types.PaymentStatusInProgress, Fees: []types.Fee{{Name: "flat", Amount: 1}}}
+	err := r.Payments().Save(payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment.Fees[0].Amount = 2
+
+	read, err := r.Payments().ByID("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := *read
+	completed.Status = types.PaymentStatusOk
+	err = r.Payments().Save(&completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Status != types.PaymentStatusInProgress {
+		t.Errorf("Save(): a payment read before changed to %v", read.Status)
+	}
+
+	stored, err := r.Payments().ByID("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != types.PaymentStatusOk || stored.Fees[0].Amount != 1 {
+		t.Errorf("ByID() = %+v, want the saved payment", stored)
+	}
+}
+
+func scanPaymentByID(payments []*types.Payment, paymentID string) *types.Payment {
+	for _, payment := range payments {
+		if payment.ID == paymentID {
+			return payment
+		}
+	}
+	return nil
+}
+
+const (
+	benchAccounts = 10_000
+	benchPayments = 100_000
+)
+
+func BenchmarkFindAccountByID_index(b *testing.B) {
+	s := newBenchService(benchAccounts, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.FindAccountByID(int64(i%benchAccounts + 1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindAccountByID_scan(b *testing.B) {
+	s := newBenchService(benchAccounts, 0)
+	accounts, _ := s.repo().Accounts().All()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if scanAccountByID(accounts, int64(i%benchAccounts+1)) == nil {
+			b.Fatal("account not found")
+		}
+	}
+}
+
+func BenchmarkFindPaymentByID_index(b *testing.B) {
+	s := newBenchService(benchAccounts, benchPayments)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.FindPaymentByID(fmt.Sprintf("payment-%d", i%benchPayments)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindPaymentByID_scan(b *testing.B) {
+	s := newBenchService(benchAccounts, benchPayments)
+	payments, _ := s.repo().Payments().All()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if scanPaymentByID(payments, fmt.Sprintf("payment-%d", i%benchPayments)) == nil {
+			b.Fatal("payment not found")
+		}
+	}
+}
+
+func BenchmarkRegisterAccount_index(b *testing.B) {
+	s := newBenchService(benchAccounts, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.RegisterAccount(types.Phone(fmt.Sprintf("+7%010d", i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegisterAccount_scan(b *testing.B) {
+	s := newBenchService(benchAccounts, 0)
+	accounts, _ := s.repo().Accounts().All()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		phone := types.Phone(fmt.Sprintf("+7%010d", i))
+		for _, account := range accounts {
+			if account.Phone == phone {
+				b.Fatal("phone already registred")
+			}
+		}
+		account := &types.Account{ID: int64(len(accounts) + 1), Phone: phone}
+		accounts = append(accounts, account)
+		s.repo().Accounts().Save(account)
+	}
+}
+
+func BenchmarkExportAccountHistory_index(b *testing.B) {
+	s := newBenchService(benchAccounts, benchPayments)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.ExportAccountHistory(int64(i%benchAccounts + 1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExportAccountHistory_scan(b *testing.B) {
+	s := newBenchService(benchAccounts, benchPayments)
+	payments, _ := s.repo().Payments().All()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		accountID := int64(i%benchAccounts + 1)
+		history := []types.Payment{}
+		for _, payment := range payments {
+			if payment.AccountID == accountID {
+				history = append(history, *payment)
+			}
+		}
+	}
+}
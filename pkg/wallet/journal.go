@@ -0,0 +1,464 @@
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// JournalFile is the name of the journal inside the directory given to Open.
+const JournalFile = "journal.log"
+
+// change is the set of records written by one operation. It is saved to the
+// repository and, when the service has a journal, appended to it first.
+type change struct {
+	op        string
+	accounts  []*types.Account
+	payments  []*types.Payment
+	favorites []*types.Favorite
+	postings  []*types.Posting
+	deposits  []*types.Deposit
+	transfers []*types.Transfer
+	keys      []*types.IdempotencyKey
+	refunds   []*types.Refund
+}
+
+// journalEntry is one line of the journal. Records are kept as dump records
+// (without the header), so the journal and the snapshot share a format.
+type journalEntry struct {
+	Op        string   `json:"op"`
+	Accounts  []string `json:"accounts,omitempty"`
+	Payments  []string `json:"payments,omitempty"`
+	Favorites []string `json:"favorites,omitempty"`
+	Postings  []string `json:"postings,omitempty"`
+	Deposits  []string `json:"deposits,omitempty"`
+	Transfers []string `json:"transfers,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+	Refunds   []string `json:"refunds,omitempty"`
+}
+
+// Journal is an append-only log of the records changed by every operation.
+// Each entry holds the new state of the records, so replaying an entry twice
+// or on top of a newer snapshot gives the same result.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	file journalFile
+	// broken is set when a failed append could not be undone: the entry is
+	// torn, and replay would not read any entry written after it.
+	broken error
+}
+
+// journalFile is the part of *os.File the journal uses.
+type journalFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenJournal opens the journal at path, creating it if needed.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{path: path, file: file}, nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+// append writes the change and syncs it to disk. If that fails the journal is
+// cut back to where the entry started, so the next entry starts a line.
+func (j *Journal) append(c change) error {
+	entry := journalEntry{Op: c.op}
+	for _, account := range c.accounts {
+		entry.Accounts = append(entry.Accounts, joinFields(accountFields(account)))
+	}
+	for _, payment := range c.payments {
+		entry.Payments = append(entry.Payments, joinFields(paymentFields(payment)))
+	}
+	for _, favorite := range c.favorites {
+		entry.Favorites = append(entry.Favorites, joinFields(favoriteFields(favorite)))
+	}
+	for _, posting := range c.postings {
+		entry.Postings = append(entry.Postings, joinFields(postingFields(posting)))
+	}
+	for _, deposit := range c.deposits {
+		entry.Deposits = append(entry.Deposits, joinFields(depositFields(deposit)))
+	}
+	for _, transfer := range c.transfers {
+		entry.Transfers = append(entry.Transfers, joinFields(transferFields(transfer)))
+	}
+	for _, key := range c.keys {
+		entry.Keys = append(entry.Keys, joinFields(keyFields(key)))
+	}
+	for _, refund := range c.refunds {
+		entry.Refunds = append(entry.Refunds, joinFields(refundFields(refund)))
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.broken != nil {
+		return j.broken
+	}
+	offset, err := j.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = j.file.Write(line)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		if truncateErr := j.file.Truncate(offset); truncateErr != nil {
+			j.broken = fmt.Errorf("%s: torn entry left after %v: %w", j.path, err, truncateErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// replay reads the journal and calls apply for every entry. A torn last line,
+// left by a crash in the middle of append, is dropped from the file.
+func (j *Journal) replay(apply func(c change) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err := j.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(j.file)
+	if err != nil {
+		return err
+	}
+
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete != len(data) {
+		err = j.file.Truncate(int64(complete))
+		if err != nil {
+			return err
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data[:complete]))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		var entry journalEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", j.path, line, err)
+		}
+		c, err := entry.change()
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", j.path, line, err)
+		}
+		err = apply(c)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", j.path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// truncate drops every entry, once they are in a snapshot.
+func (j *Journal) truncate() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	err := j.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	j.broken = nil
+	return j.file.Sync()
+}
+
+func (e journalEntry) change() (change, error) {
+	c := change{op: e.Op}
+	for _, line := range e.Accounts {
+		fields, err := splitFields(line)
+		if err != nil {
+			return change{}, err
+		}
+		account, err := accountFromFields(fields)
+		if err != nil {
+			return change{}, err
+		}
+		c.accounts = append(c.accounts, account)
+	}
+	for _, line := range e.Payments {
+		fields, err := splitFields(line)
+		if err != nil {
+			return change{}, err
+		}
+		payment, err := paymentFromFields(fields)
+		if err != nil {
+			return change{}, err
+		}
+		c.payments = append(c.payments, payment)
+	}
+	for _, line := range e.Favorites {
+		fields, err := splitFields(line)
+		if err != nil {
+			return change{}, err
+		}
+		favorite, err := favoriteFromFields(fields)
+		if err != nil {
+			return change{}, err
+		}
+		c.favorites = append(c.favorites, favorite)
+	}
+	for _, line := range e.Postings {
+		fields, err := splitFields(line)
+		if err != nil {
+			return change{}, err
+		}
+		posting, err := postingFromFields(fields)
+		if err != nil {
+			return change{}, err
+		}
+		c.postings = append(c.postings, posting)
+	}
+	for _, line := range e.Deposits {
+		fields, err := splitFields(line)
+		if err != nil {
+			return change{}, err
+		}
+		deposit, err := depositFromFields(fields)
+		if err != nil {
+			return change{}, err
+		}
+		c.deposits = append(c.deposits, deposit)
+	}
+	for _, line := range e.Transfers {
+		fields, err := splitFields(line)
+		if err != nil {
+			return change{}, err
+		}
+		transfer, err := transferFromFields(fields)
+		if err != nil {
+			return change{}, err
+		}
+		c.transfers = append(c.transfers, transfer)
+	}
+	for _, line := range e.Keys {
+		fields, err := splitFields(line)
+		if err != nil {
+			return change{}, err
+		}
+		key, err := keyFromFields(fields)
+		if err != nil {
+			return change{}, err
+		}
+		c.keys = append(c.keys, key)
+	}
+	for _, line := range e.Refunds {
+		fields, err := splitFields(line)
+		if err != nil {
+			return change{}, err
+		}
+		refund, err := refundFromFields(fields)
+		if err != nil {
+			return change{}, err
+		}
+		c.refunds = append(c.refunds, refund)
+	}
+	return c, nil
+}
+
+// Open restores a service from the snapshot in dir (the files written by
+// Export and Compact) and the journal next to it, and keeps journaling every
+// change there. Call Compact from time to time to fold the journal into the
+// snapshot, and Close when done.
+func Open(dir string, options ...Option) (*Service, error) {
+	s := NewService(options...)
+
+	err := s.restore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := OpenJournal(filepath.Join(dir, JournalFile))
+	if err != nil {
+		return nil, err
+	}
+	err = journal.replay(func(c change) error {
+		err := s.loadAccounts(c.accounts)
+		if err != nil {
+			return err
+		}
+		return s.save(c)
+	})
+	if err != nil {
+		journal.Close()
+		return nil, err
+	}
+
+	s.journal = journal
+	s.journalDir = dir
+	return s, nil
+}
+
+// restore loads the snapshot in dir into the repository as is, keeping IDs.
+// Only phones are put in E.164 form, see loadAccounts.
+func (s *Service) restore(dir string) error {
+	files, err := readDumps(dir, dumpCodec)
+	if err != nil {
+		return err
+	}
+
+	c := change{op: "restore"}
+	c.accounts, err = parseAccounts(files[accountsDump])
+	if err != nil {
+		return err
+	}
+	err = s.loadAccounts(c.accounts)
+	if err != nil {
+		return err
+	}
+	c.payments, err = parsePayments(files[paymentsDump])
+	if err != nil {
+		return err
+	}
+	c.favorites, err = parseFavorites(files[favoritesDump])
+	if err != nil {
+		return err
+	}
+	c.postings, err = parsePostings(files[postingsDump])
+	if err != nil {
+		return err
+	}
+	c.deposits, err = parseDeposits(files[depositsDump])
+	if err != nil {
+		return err
+	}
+	c.transfers, err = parseTransfers(files[transfersDump])
+	if err != nil {
+		return err
+	}
+	c.keys, err = parseKeys(files[keysDump])
+	if err != nil {
+		return err
+	}
+	c.refunds, err = parseRefunds(files[refundsDump])
+	if err != nil {
+		return err
+	}
+	// snapshots written before the ledger have balances without postings
+	err = s.balanceLedger(&c)
+	if err != nil {
+		return err
+	}
+
+	return s.save(c)
+}
+
+// Compact writes the current state as a snapshot into the directory of the
+// journal and empties the journal.
+func (s *Service) Compact() error {
+	if s.journal == nil {
+		return ErrNoJournal
+	}
+
+	unlock, err := s.lockAll(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := s.dumpFiles(dumpCodec)
+	if err != nil {
+		return err
+	}
+	err = writeDumps(s.journalDir, dumpCodec, files)
+	if err != nil {
+		return err
+	}
+
+	// a crash before this point replays the journal over the new snapshot,
+	// which is harmless as entries hold whole records
+	return s.journal.truncate()
+}
+
+// Close closes the journal of a service created by Open.
+func (s *Service) Close() error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Close()
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and
+// renames it over path, so readers see either the old or the new file.
+func writeFileAtomic(path string, data string) error {
+	tmp, err := writeTempFile(path, data)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// writeTempFile writes data to a synced temporary file next to path and
+// returns its name.
+func writeTempFile(path string, data string) (string, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	tmp := file.Name()
+
+	_, err = file.WriteString(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// not every platform can sync a directory, the rename is done anyway
+	d.Sync()
+	return nil
+}
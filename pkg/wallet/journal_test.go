@@ -0,0 +1,338 @@
+package wallet
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// openTestService opens a journaled service in dir and registers it to be
+// closed at the end of the test.
+func openTestService(t *testing.T, dir string) *testService {
+	t.Helper()
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open(): error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return &testService{Service: s}
+}
+
+func assertSameState(t *testing.T, got, want *Service) {
+	t.Helper()
+
+	gotAccounts, _ := got.repo().Accounts().All()
+	wantAccounts, _ := want.repo().Accounts().All()
+	if !reflect.DeepEqual(gotAccounts, wantAccounts) {
+		t.Errorf("accounts = %v, want %v", gotAccounts, wantAccounts)
+	}
+	gotPayments, _ := got.repo().Payments().All()
+	wantPayments, _ := want.repo().Payments().All()
+	if !reflect.DeepEqual(gotPayments, wantPayments) {
+		t.Errorf("payments = %v, want %v", gotPayments, wantPayments)
+	}
+	gotFavorites, _ := got.repo().Favorites().All()
+	wantFavorites, _ := want.repo().Favorites().All()
+	if !reflect.DeepEqual(gotFavorites, wantFavorites) {
+		t.Errorf("favorites = %v, want %v", gotFavorites, wantFavorites)
+	}
+	gotPostings, _ := got.repo().Postings().All()
+	wantPostings, _ := want.repo().Postings().All()
+	if !reflect.DeepEqual(gotPostings, wantPostings) {
+		t.Errorf("postings = %v, want %v", gotPostings, wantPostings)
+	}
+	gotDeposits, _ := got.repo().Deposits().All()
+	wantDeposits, _ := want.repo().Deposits().All()
+	if !reflect.DeepEqual(gotDeposits, wantDeposits) {
+		t.Errorf("deposits = %v, want %v", gotDeposits, wantDeposits)
+	}
+	gotTransfers, _ := got.repo().Transfers().All()
+	wantTransfers, _ := want.repo().Transfers().All()
+	if !reflect.DeepEqual(gotTransfers, wantTransfers) {
+		t.Errorf("transfers = %v, want %v", gotTransfers, wantTransfers)
+	}
+	gotKeys, _ := got.repo().Keys().All()
+	wantKeys, _ := want.repo().Keys().All()
+	if !reflect.DeepEqual(gotKeys, wantKeys) {
+		t.Errorf("keys = %v, want %v", gotKeys, wantKeys)
+	}
+	gotRefunds, _ := got.repo().Refunds().All()
+	wantRefunds, _ := want.repo().Refunds().All()
+	if !reflect.DeepEqual(gotRefunds, wantRefunds) {
+		t.Errorf("refunds = %v, want %v", gotRefunds, wantRefunds)
+	}
+}
+
+func TestOpen_replays(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is done to the journaled service before it "dies"
+		change func(s *testService, payment *types.Payment) error
+		// check is done on the service restored from the journal
+		check func(t *testing.T, s *testService, payment *types.Payment)
+	}{
+		{
+			name: "favorite and reject",
+			change: func(s *testService, payment *types.Payment) error {
+				_, err := s.FavoritePayment(payment.ID, "ogastus")
+				if err != nil {
+					return err
+				}
+				return s.Reject(payment.ID)
+			},
+			check: func(t *testing.T, s *testService, payment *types.Payment) {
+				account, err := s.RegisterAccount("+992938151003")
+				if err != nil || account.ID != 2 {
+					t.Errorf("RegisterAccount() after Open = %v, %v, want id 2", account, err)
+				}
+			},
+		},
+		{
+			name: "limits",
+			change: func(s *testService, payment *types.Payment) error {
+				return s.SetLimits(payment.AccountID, &types.Limits{MaxPayment: 10})
+			},
+			check: func(t *testing.T, s *testService, payment *types.Payment) {
+				_, err := s.Pay(payment.AccountID, 11, "auto")
+				if err != ErrPaymentLimit {
+					t.Errorf("Pay() after Open: error = %v, want %v", err, ErrPaymentLimit)
+				}
+			},
+		},
+		{
+			name: "phone and profile",
+			change: func(s *testService, payment *types.Payment) error {
+				err := s.ChangePhone(payment.AccountID, "+992938151009")
+				if err != nil {
+					return err
+				}
+				return s.UpdateProfile(payment.AccountID, types.Profile{Name: "Rustam;\nSharipov", Email: "rustam@example.tj", Locale: "tg"})
+			},
+			check: func(t *testing.T, s *testService, payment *types.Payment) {
+				accounts, err := s.SearchAccounts("+992938151009")
+				if err != nil || len(accounts) != 1 || accounts[0].ID != payment.AccountID {
+					t.Errorf("SearchAccounts() after Open = %v, %v, want account %v", accounts, err, payment.AccountID)
+				}
+			},
+		},
+		{
+			name: "refund",
+			change: func(s *testService, payment *types.Payment) error {
+				_, err := s.Refund(payment.ID, 10)
+				return err
+			},
+			check: func(t *testing.T, s *testService, payment *types.Payment) {
+				_, err := s.Refund(payment.ID, payment.Amount)
+				if !errors.Is(err, ErrRefundTooLarge) {
+					t.Errorf("Refund() after Open: error = %v, want %v", err, ErrRefundTooLarge)
+				}
+			},
+		},
+		{
+			name: "account status",
+			change: func(s *testService, payment *types.Payment) error {
+				return s.BlockAccount(payment.AccountID)
+			},
+			check: func(t *testing.T, s *testService, payment *types.Payment) {
+				_, err := s.Pay(payment.AccountID, 10, "auto")
+				if err != ErrAccountBlocked {
+					t.Errorf("Pay() after Open: error = %v, want %v", err, ErrAccountBlocked)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openTestService(t, dir)
+
+			_, payments, err := s.addAcoount(defaultTestAccount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.change(s, payments[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// no Compact and no Close: the process "dies" here
+			restored := openTestService(t, dir)
+			assertSameState(t, restored.Service, s.Service)
+			tt.check(t, restored, payments[0])
+		})
+	}
+}
+
+func TestService_Compact(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestService(t, dir)
+
+	_, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Compact()
+	if err != nil {
+		t.Fatalf("Compact(): error = %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, JournalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("Compact(): journal has %v bytes left", info.Size())
+	}
+
+	_, err = s.addAccountWithBalance("+992938151003", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openTestService(t, dir)
+	assertSameState(t, restored.Service, s.Service)
+}
+
+func TestOpen_tornJournalEntry(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestService(t, dir)
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	journal := filepath.Join(dir, JournalFile)
+	data, err := ioutil.ReadFile(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, `{"op":"deposit","accounts":["1;+99`...)
+	err = ioutil.WriteFile(journal, data, 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openTestService(t, dir)
+	got, err := restored.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): error = %v", err)
+	}
+	if got.Balance != 100 {
+		t.Errorf("FindAccountByID(): balance = %v, want 100", got.Balance)
+	}
+
+	_, err = restored.Pay(account.ID, 40, types.PaymentCategoryFun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := openTestService(t, dir)
+	got, err = again.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): error = %v", err)
+	}
+	if got.Balance != 60 {
+		t.Errorf("FindAccountByID(): balance = %v, want 60", got.Balance)
+	}
+}
+
+// shortWriteFile writes half of every entry and fails, like a full disk.
+type shortWriteFile struct {
+	*os.File
+}
+
+func (f shortWriteFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, io.ErrShortWrite
+}
+
+func TestJournal_append_shortWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestService(t, dir)
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := s.journal.file
+	s.journal.file = shortWriteFile{file.(*os.File)}
+	_, err = s.Pay(account.ID, 40, types.PaymentCategoryFun)
+	if err != io.ErrShortWrite {
+		t.Errorf("Pay() with a short write: error = %v, want %v", err, io.ErrShortWrite)
+	}
+	s.journal.file = file
+	_, err = s.Pay(account.ID, 30, types.PaymentCategoryFun)
+	if err != nil {
+		t.Fatalf("Pay() after a short write: error = %v", err)
+	}
+	if s.balance(account) != 70 {
+		t.Errorf("Pay(): balance = %v, want 70", s.balance(account))
+	}
+
+	restored := openTestService(t, dir)
+	assertSameState(t, restored.Service, s.Service)
+}
+
+func TestService_Compact_withoutJournal(t *testing.T) {
+	s := newTestService()
+
+	if err := s.Compact(); err != ErrNoJournal {
+		t.Errorf("Compact(): error = %v, want %v", err, ErrNoJournal)
+	}
+}
+
+func TestOpen_normalizesPhones(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"accounts.dump": "1;9127660305;0\n1;9127660305;0\n",
+		JournalFile:     `{"op":"register","accounts":["2;0938151003;0"]}` + "\n",
+	})
+	s := openTestService(t, dir)
+
+	for _, tt := range []struct {
+		id    int64
+		phone types.Phone
+	}{
+		{1, "+9929127660305"},
+		{2, "+992938151003"},
+	} {
+		account, err := s.FindAccountByID(tt.id)
+		if err != nil || account.Phone != tt.phone {
+			t.Errorf("FindAccountByID(%v) = %v, %v, want the phone %v", tt.id, account, err, tt.phone)
+		}
+		_, err = s.RegisterAccount(tt.phone)
+		if err != ErrPhoneNumberRegistred {
+			t.Errorf("RegisterAccount(%v): error = %v, want %v", tt.phone, err, ErrPhoneNumberRegistred)
+		}
+	}
+	_, err := s.RegisterAccount("9127660305")
+	if err != ErrPhoneNumberRegistred {
+		t.Errorf("RegisterAccount(9127660305): error = %v, want %v", err, ErrPhoneNumberRegistred)
+	}
+}
+
+func TestOpen_duplicatePhone(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"snapshot": {
+			"accounts.dump": "1;9127660305;0\n2;+9929127660305;0\n",
+		},
+		"journal": {
+			"accounts.dump": "1;9127660305;0\n",
+			JournalFile:     `{"op":"register","accounts":["2;09127660305;0"]}` + "\n",
+		},
+	} {
+		_, err := Open(writeTestFiles(t, files))
+		if !errors.Is(err, ErrPhoneNumberRegistred) {
+			t.Errorf("Open() with a phone repeated in the %v: error = %v, want %v", name, err, ErrPhoneNumberRegistred)
+		}
+	}
+}
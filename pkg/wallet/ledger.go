@@ -0,0 +1,285 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+// System ledger accounts. They hold the other side of the money entering and
+// leaving accounts, so every posting moves money between two ledger accounts
+// and the ledger as a whole always balances.
+const (
+	// LedgerDeposits is where Deposit takes money from. Transfers move money
+	// between two accounts directly.
+	LedgerDeposits int64 = -1
+	// LedgerPayments is where Pay sends money and Reject and Refund take it
+	// back from.
+	LedgerPayments int64 = -2
+	// LedgerAdjustments explains balances brought in by imports without the
+	// postings behind them.
+	LedgerAdjustments int64 = -3
+)
+
+var ErrUnknownLedgerAccount = errors.New("unknown ledger account")
+var ErrSameLedgerAccount = errors.New("posting debits and credits the same account")
+
+// systemLedgerAccount reports whether accountID is one of the system ledger
+// accounts.
+func systemLedgerAccount(accountID int64) bool {
+	switch accountID {
+	case LedgerDeposits, LedgerPayments, LedgerAdjustments, LedgerExchange, LedgerOverdraft, LedgerFees:
+		return true
+	}
+	return false
+}
+
+// newPosting makes a posting moving amount from debit to credit.
+func (s *Service) newPosting(debit int64, credit int64, amount types.Money, reference string) *types.Posting {
+	return &types.Posting{
+		ID:        uuid.New().String(),
+		Debit:     debit,
+		Credit:    credit,
+		Amount:    amount,
+		Reference: reference,
+		Created:   s.now(),
+	}
+}
+
+// ledgerBalance returns the balance of accountID according to postings: its
+// credits minus its debits. It gives ErrOverflow if they do not fit in Money.
+func ledgerBalance(accountID int64, postings []*types.Posting) (types.Money, error) {
+	var balance types.Money
+	var err error
+	for _, posting := range postings {
+		if posting.Credit == accountID {
+			balance, err = balance.Add(posting.Amount)
+		}
+		if posting.Debit == accountID {
+			balance, err = balance.Sub(posting.Amount)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return balance, nil
+}
+
+// LedgerMismatch is an account whose balance differs from its postings.
+type LedgerMismatch struct {
+	AccountID int64
+	Balance   types.Money
+	Ledger    types.Money
+}
+
+// LedgerError lists the accounts found by CheckLedger.
+type LedgerError struct {
+	Mismatches []LedgerMismatch
+}
+
+func (e *LedgerError) Error() string {
+	accounts := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		accounts[i] = fmt.Sprintf("account %d: balance %d, ledger %d", m.AccountID, m.Balance, m.Ledger)
+	}
+	return "balances do not match the ledger: " + strings.Join(accounts, "; ")
+}
+
+// CheckLedger checks that the balance of every account equals the sum of its
+// postings. It returns a *LedgerError listing the accounts that do not.
+func (s *Service) CheckLedger() error {
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	accounts, err := s.repo().Accounts().All()
+	if err != nil {
+		return err
+	}
+
+	ledgerErr := &LedgerError{}
+	for _, account := range accounts {
+		postings, err := s.repo().Postings().ByAccount(account.ID)
+		if err != nil {
+			return err
+		}
+		ledger, err := ledgerBalance(account.ID, postings)
+		if err != nil {
+			return err
+		}
+		if ledger != account.Balance {
+			ledgerErr.Mismatches = append(ledgerErr.Mismatches, LedgerMismatch{
+				AccountID: account.ID,
+				Balance:   account.Balance,
+				Ledger:    ledger,
+			})
+		}
+	}
+
+	if len(ledgerErr.Mismatches) != 0 {
+		return ledgerErr
+	}
+	return nil
+}
+
+// AccountLedger returns the postings of the account, oldest first, which add
+// up to its balance.
+func (s *Service) AccountLedger(accountID int64) ([]types.Posting, error) {
+	_, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	postings, err := s.repo().Postings().ByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := make([]types.Posting, len(postings))
+	for i, posting := range postings {
+		ledger[i] = *posting
+	}
+	return ledger, nil
+}
+
+// ExportAccountStatement returns every movement of money on the account,
+// oldest first: deposits, payments, refunds (of rejected payments too),
+// transfers (and their reversals), fees of payments (and their refunds),
+// overdraft fees and interest, and adjustments made by imports. The amounts
+// add up to the balance, which each transaction carries as it was right
+// after it.
+func (s *Service) ExportAccountStatement(accountID int64) ([]types.Transaction, error) {
+	postings, err := s.AccountLedger(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := make([]types.Transaction, len(postings))
+	var balance types.Money
+	for i, posting := range postings {
+		transaction := types.Transaction{
+			AccountID: accountID,
+			Amount:    posting.Amount,
+			Created:   posting.Created,
+		}
+		other := posting.Debit
+		if posting.Debit == accountID {
+			transaction.Amount = -posting.Amount
+			other = posting.Credit
+		}
+
+		switch other {
+		case LedgerDeposits:
+			transaction.Kind = types.TransactionDeposit
+			transaction.ID = posting.Reference
+		case LedgerPayments:
+			transaction.Kind = types.TransactionPayment
+			if transaction.Amount > 0 {
+				transaction.Kind = types.TransactionRefund
+			}
+			transaction.ID = posting.Reference
+		case LedgerAdjustments:
+			transaction.Kind = types.TransactionAdjustment
+		case LedgerExchange:
+			transaction.Kind = types.TransactionTransfer
+			transaction.ID = posting.Reference
+		case LedgerOverdraft, LedgerFees:
+			transaction.Kind = types.TransactionFee
+			transaction.ID = posting.Reference
+		default:
+			// between two accounts: a transfer, or the fees of a payment
+			// going to the fee account
+			transaction.Kind = types.TransactionTransfer
+			_, err = s.repo().Payments().ByID(posting.Reference)
+			if err == nil {
+				transaction.Kind = types.TransactionFee
+			} else if err != ErrPaymentNotFound {
+				return nil, err
+			}
+			transaction.ID = posting.Reference
+		}
+
+		balance, err = balance.Add(transaction.Amount)
+		if err != nil {
+			return nil, err
+		}
+		transaction.Balance = balance
+		statement[i] = transaction
+	}
+	return statement, nil
+}
+
+// balanceLedger adds a posting from or to LedgerAdjustments for every account
+// whose balance after the change would differ from its postings, as happens
+// when importing files written before the ledger existed. Callers hold
+// lockAll(true).
+func (s *Service) balanceLedger(c *change) error {
+	imported := map[string]bool{}
+	byAccount := map[int64][]*types.Posting{}
+	for _, posting := range c.postings {
+		imported[posting.ID] = true
+		byAccount[posting.Debit] = append(byAccount[posting.Debit], posting)
+		if posting.Credit != posting.Debit {
+			byAccount[posting.Credit] = append(byAccount[posting.Credit], posting)
+		}
+	}
+
+	accounts := map[int64]*types.Account{}
+	ids := []int64{}
+	for _, account := range c.accounts {
+		accounts[account.ID] = account
+		ids = append(ids, account.ID)
+	}
+	for _, posting := range c.postings {
+		for _, accountID := range []int64{posting.Debit, posting.Credit} {
+			if accountID <= 0 || accounts[accountID] != nil {
+				continue
+			}
+			account, err := s.repo().Accounts().ByID(accountID)
+			if err == ErrAccountNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			accounts[accountID] = account
+			ids = append(ids, accountID)
+		}
+	}
+
+	for _, accountID := range ids {
+		stored, err := s.repo().Postings().ByAccount(accountID)
+		if err != nil {
+			return err
+		}
+		postings := []*types.Posting{}
+		for _, posting := range stored {
+			if !imported[posting.ID] {
+				postings = append(postings, posting)
+			}
+		}
+		postings = append(postings, byAccount[accountID]...)
+
+		ledger, err := ledgerBalance(accountID, postings)
+		if err != nil {
+			return err
+		}
+		diff, err := accounts[accountID].Balance.Sub(ledger)
+		if err != nil {
+			return err
+		}
+		switch {
+		case diff > 0:
+			c.postings = append(c.postings, s.newPosting(LedgerAdjustments, accountID, diff, c.op))
+		case diff < 0:
+			c.postings = append(c.postings, s.newPosting(accountID, LedgerAdjustments, -diff, c.op))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_AccountLedger(t *testing.T) {
+	now := time.Date(2021, 3, 8, 10, 0, 0, 0, time.UTC)
+	s := &testService{Service: NewService(WithClock(func() time.Time { return now }))}
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposits, err := s.ExportAccountDeposits(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ledger, err := s.AccountLedger(account.ID)
+	if err != nil {
+		t.Fatalf("AccountLedger(): error = %v", err)
+	}
+	want := []struct {
+		debit, credit int64
+		amount        types.Money
+		reference     string
+	}{
+		{LedgerDeposits, account.ID, defaultTestAccount.balance, deposits[0].ID},
+		{account.ID, LedgerPayments, payments[0].Amount, payments[0].ID},
+		{LedgerPayments, account.ID, payments[0].Amount, payments[0].ID},
+	}
+	if len(ledger) != len(want) {
+		t.Fatalf("AccountLedger(): %v postings, want %v", len(ledger), len(want))
+	}
+	for i, posting := range ledger {
+		if posting.Debit != want[i].debit || posting.Credit != want[i].credit ||
+			posting.Amount != want[i].amount || posting.Reference != want[i].reference {
+			t.Errorf("AccountLedger()[%v] = %+v, want %+v", i, posting, want[i])
+		}
+		if !posting.Created.Equal(now) {
+			t.Errorf("AccountLedger()[%v]: created = %v, want %v", i, posting.Created, now)
+		}
+	}
+	if balance, _ := ledgerBalance(account.ID, postingPointers(ledger)); balance != s.balance(account) {
+		t.Errorf("AccountLedger(): postings add up to %v, balance is %v", balance, s.balance(account))
+	}
+
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+}
+
+func postingPointers(postings []types.Posting) []*types.Posting {
+	pointers := make([]*types.Posting, len(postings))
+	for i := range postings {
+		pointers[i] = &postings[i]
+	}
+	return pointers
+}
+
+func TestService_CheckLedger_mismatch(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a balance changed behind the service's back
+	tampered := *account
+	tampered.Balance = 150
+	err = s.repo().Accounts().Save(&tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.CheckLedger()
+	ledgerErr, ok := err.(*LedgerError)
+	if !ok {
+		t.Fatalf("CheckLedger(): error = %v, want *LedgerError", err)
+	}
+	want := LedgerMismatch{AccountID: account.ID, Balance: 150, Ledger: 100}
+	if len(ledgerErr.Mismatches) != 1 || ledgerErr.Mismatches[0] != want {
+		t.Errorf("CheckLedger(): mismatches = %v, want %v", ledgerErr.Mismatches, want)
+	}
+}
+
+func TestService_Import_withoutPostings(t *testing.T) {
+	s := newTestService()
+
+	dir := writeTestFiles(t, map[string]string{
+		"accounts.dump": "1;+992938151007;100;\n",
+	})
+	err := s.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): error = %v", err)
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+
+	ledger, err := s.AccountLedger(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledger) != 1 || ledger[0].Debit != LedgerAdjustments || ledger[0].Amount != 100 {
+		t.Errorf("AccountLedger() = %+v, want one adjustment of 100", ledger)
+	}
+}
+
+func TestService_ImportMerge_sameExport(t *testing.T) {
+	s := newTestService()
+
+	_, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing has changed since the export, so there is nothing to conflict
+	err = s.ImportMerge(dir, FormatDump, MergeFail)
+	if err != nil {
+		t.Errorf("ImportMerge(): error = %v", err)
+	}
+}
+
+func TestService_ExportAccountStatement(t *testing.T) {
+	s := newTestService()
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposits, err := s.ExportAccountDeposits(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statement, err := s.ExportAccountStatement(account.ID)
+	if err != nil {
+		t.Fatalf("ExportAccountStatement(): error = %v", err)
+	}
+	want := []struct {
+		id     string
+		kind   types.TransactionKind
+		amount types.Money
+	}{
+		{deposits[0].ID, types.TransactionDeposit, defaultTestAccount.balance},
+	}
+	for _, payment := range payments {
+		want = append(want, struct {
+			id     string
+			kind   types.TransactionKind
+			amount types.Money
+		}{payment.ID, types.TransactionPayment, -payment.Amount})
+	}
+	want = append(want, struct {
+		id     string
+		kind   types.TransactionKind
+		amount types.Money
+	}{payments[0].ID, types.TransactionRefund, payments[0].Amount})
+
+	if len(statement) != len(want) {
+		t.Fatalf("ExportAccountStatement(): %v transactions, want %v", len(statement), len(want))
+	}
+	var balance types.Money
+	for i, transaction := range statement {
+		balance += want[i].amount
+		if transaction.ID != want[i].id || transaction.Kind != want[i].kind ||
+			transaction.Amount != want[i].amount || transaction.Balance != balance {
+			t.Errorf("ExportAccountStatement()[%v] = %+v, want %+v with balance %v", i, transaction, want[i], balance)
+		}
+	}
+	if balance != s.balance(account) {
+		t.Errorf("ExportAccountStatement(): ends at %v, balance is %v", balance, s.balance(account))
+	}
+}
@@ -0,0 +1,156 @@
+package wallet
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+var ErrPaymentLimit = errors.New("payment is over the limit of a single payment")
+var ErrDailyLimit = errors.New("payment is over the daily spending limit")
+var ErrMonthlyLimit = errors.New("payment is over the monthly spending limit")
+var ErrBalanceLimit = errors.New("balance would go over its limit")
+
+// WithLimits holds the accounts without limits of their own to limits.
+func WithLimits(limits types.Limits) Option {
+	return func(s *Service) {
+		s.limits = limits
+	}
+}
+
+// SetLimits holds the account to limits instead of the default ones, or
+// back to the default ones if limits is nil. The limits only apply to later
+// payments and money coming in: a balance already over the new limit is kept.
+func (s *Service) SetLimits(accountID int64, limits *types.Limits) error {
+	err := checkLimits(limits)
+	if err != nil {
+		return err
+	}
+
+	account, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	updated := *account
+	updated.Limits = nil
+	if limits != nil {
+		copied := *limits
+		copied.Spending = append([]types.SpendingLimit(nil), limits.Spending...)
+		updated.Limits = &copied
+	}
+	return s.commit(change{op: "limits", accounts: []*types.Account{&updated}})
+}
+
+// Limits returns the limits the account is held to: its own ones or, if it
+// has none, the default ones.
+func (s *Service) Limits(accountID int64) (types.Limits, error) {
+	account, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return types.Limits{}, err
+	}
+	defer unlock()
+
+	return s.limitsOf(account), nil
+}
+
+// limitsOf returns the limits the account is held to.
+func (s *Service) limitsOf(account *types.Account) types.Limits {
+	if account.Limits != nil {
+		return *account.Limits
+	}
+	return s.limits
+}
+
+// checkBalanceLimit checks that the account may hold balance. It is called
+// for every account whose balance goes up: deposits, transfers and their
+// rejections, refunds, rejected payments and fees credited to the fee
+// account. The caller holds the lock of the account.
+func (s *Service) checkBalanceLimit(account *types.Account, balance types.Money) error {
+	limits := s.limitsOf(account)
+	if limits.MaxBalance > 0 && balance > limits.MaxBalance {
+		return ErrBalanceLimit
+	}
+	return nil
+}
+
+// checkSpendingLimits checks that the account may pay amount in category.
+// Spending counts the payments made since the start of the day or month by
+// the clock of the service, less what was refunded of them; rejected
+// payments do not count. The caller holds the lock of the account.
+func (s *Service) checkSpendingLimits(account *types.Account, amount types.Money, category types.PaymentCategory) error {
+	limits := s.limitsOf(account)
+	if limits.MaxPayment > 0 && amount > limits.MaxPayment {
+		return ErrPaymentLimit
+	}
+
+	now := s.now()
+	checks := []spendingCheck{}
+	earliest := now
+	for _, limit := range limits.Spending {
+		if limit.Category != "" && !strings.EqualFold(string(limit.Category), string(category)) {
+			continue
+		}
+		check := spendingCheck{
+			limit: limit,
+			since: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()),
+			err:   ErrDailyLimit,
+			spent: amount,
+		}
+		if limit.Period == types.LimitPeriodMonth {
+			check.since, check.err = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()), ErrMonthlyLimit
+		}
+		if check.since.Before(earliest) {
+			earliest = check.since
+		}
+		checks = append(checks, check)
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	payments, err := s.repo().Payments().ByAccountSince(account.ID, earliest)
+	if err != nil {
+		return err
+	}
+	for _, payment := range payments {
+		left := types.Money(-1)
+		for j := range checks {
+			check := &checks[j]
+			if payment.Created.Before(check.since) {
+				continue
+			}
+			if check.limit.Category != "" && !strings.EqualFold(string(check.limit.Category), string(payment.Category)) {
+				continue
+			}
+			if left < 0 {
+				left, err = s.refundable(payment)
+				if err != nil {
+					return err
+				}
+			}
+			check.spent, err = check.spent.Add(left)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, check := range checks {
+		if check.spent > check.limit.Amount {
+			return check.err
+		}
+	}
+	return nil
+}
+
+// spendingCheck is the spending of one limit counted by checkSpendingLimits.
+type spendingCheck struct {
+	limit types.SpendingLimit
+	since time.Time
+	err   error
+	spent types.Money
+}
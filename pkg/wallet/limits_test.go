@@ -0,0 +1,303 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_Pay_limits(t *testing.T) {
+	now := time.Date(2021, 3, 8, 10, 0, 0, 0, time.UTC)
+	s := &testService{Service: NewService(
+		WithClock(func() time.Time { return now }),
+		WithLimits(types.Limits{
+			MaxPayment: 500,
+			Spending: []types.SpendingLimit{
+				{Period: types.LimitPeriodDay, Category: "auto", Amount: 700},
+				{Period: types.LimitPeriodMonth, Amount: 1000},
+			},
+		}),
+	)}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 10_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, 501, "food")
+	if err != ErrPaymentLimit {
+		t.Errorf("Pay(501): error = %v, want %v", err, ErrPaymentLimit)
+	}
+	payment, err := s.Pay(account.ID, 400, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Repeat(payment.ID)
+	if err != ErrDailyLimit {
+		t.Errorf("Repeat(): error = %v, want %v", err, ErrDailyLimit)
+	}
+	favorite, err := s.FavoritePayment(payment.ID, "fuel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.PayFromFavorite(favorite.ID)
+	if err != ErrDailyLimit {
+		t.Errorf("PayFromFavorite(): error = %v, want %v", err, ErrDailyLimit)
+	}
+
+	// a refund gives back the spending, a rejected payment does not count
+	_, err = s.Refund(payment.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(account.ID, 400, "AUTO")
+	if err != nil {
+		t.Errorf("Pay() after Refund(): error = %v", err)
+	}
+	other, err := s.Pay(account.ID, 300, "food")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.AddDate(0, 0, 1)
+	_, err = s.Pay(account.ID, 301, "food")
+	if err != ErrMonthlyLimit {
+		t.Errorf("Pay() the next day: error = %v, want %v", err, ErrMonthlyLimit)
+	}
+	_, err = s.Pay(account.ID, 301, "auto")
+	if err != ErrMonthlyLimit {
+		t.Errorf("Pay() the next day: error = %v, want %v", err, ErrMonthlyLimit)
+	}
+	_, err = s.Pay(account.ID, 301, "fun")
+	if err != ErrMonthlyLimit {
+		t.Errorf("Pay() the next day: error = %v, want %v", err, ErrMonthlyLimit)
+	}
+
+	now = time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	_, err = s.Pay(account.ID, 500, "auto")
+	if err != nil {
+		t.Errorf("Pay() the next month: error = %v", err)
+	}
+	if s.balance(account) != 10_000-300-400-500 {
+		t.Errorf("balance = %v, want %v", s.balance(account), 10_000-300-400-500)
+	}
+}
+
+func TestService_Pay_limitsAfterImport(t *testing.T) {
+	now := time.Date(2021, 3, 8, 10, 0, 0, 0, time.UTC)
+	s := &testService{Service: NewService(
+		WithClock(func() time.Time { return now }),
+		WithLimits(types.Limits{
+			Spending: []types.SpendingLimit{{Period: types.LimitPeriodDay, Amount: 1000}},
+		}),
+	)}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 10_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(account.ID, 900, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the imported payment of yesterday is saved after the one of today
+	dir := writeTestFiles(t, map[string]string{
+		"payments.dump": "#wallet-dump;2;payments\n" +
+			fmt.Sprintf("p1;%d;10;auto;OK;2021-03-07T10:00:00Z;2021-03-07T10:00:00Z;TJS\n", account.ID),
+	})
+	err = s.Import(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, 900, "auto")
+	if err != ErrDailyLimit {
+		t.Errorf("Pay() after Import(): error = %v, want %v", err, ErrDailyLimit)
+	}
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Errorf("Pay() within the limit after Import(): error = %v", err)
+	}
+}
+
+func TestService_SetLimits(t *testing.T) {
+	s := &testService{Service: NewService(WithLimits(types.Limits{MaxBalance: 1000}))}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Deposit(account.ID, 101)
+	if err != ErrBalanceLimit {
+		t.Errorf("Deposit(): error = %v, want %v", err, ErrBalanceLimit)
+	}
+	if s.balance(account) != 900 {
+		t.Errorf("Deposit(): balance = %v, want 900", s.balance(account))
+	}
+
+	own := &types.Limits{MaxPayment: 100}
+	err = s.SetLimits(account.ID, own)
+	if err != nil {
+		t.Fatalf("SetLimits(): error = %v", err)
+	}
+	err = s.Deposit(account.ID, 101)
+	if err != nil {
+		t.Errorf("Deposit() with own limits: error = %v", err)
+	}
+	_, err = s.Pay(account.ID, 101, "auto")
+	if err != ErrPaymentLimit {
+		t.Errorf("Pay() with own limits: error = %v, want %v", err, ErrPaymentLimit)
+	}
+	limits, err := s.Limits(account.ID)
+	if err != nil || !reflect.DeepEqual(limits, *own) {
+		t.Errorf("Limits() = %v, %v, want %v", limits, err, *own)
+	}
+
+	err = s.SetLimits(account.ID, nil)
+	if err != nil {
+		t.Fatalf("SetLimits(nil): error = %v", err)
+	}
+	limits, err = s.Limits(account.ID)
+	if err != nil || limits.MaxBalance != 1000 || limits.MaxPayment != 0 {
+		t.Errorf("Limits() = %v, %v, want the default ones", limits, err)
+	}
+
+	tests := []struct {
+		limits *types.Limits
+		err    error
+	}{
+		{&types.Limits{MaxPayment: -1}, ErrNegativeLimit},
+		{&types.Limits{Spending: []types.SpendingLimit{{Period: "WEEK", Amount: 1}}}, ErrUnknownPeriod},
+		{&types.Limits{Spending: []types.SpendingLimit{{Period: types.LimitPeriodDay, Category: "toys", Amount: 1}}}, ErrUnknownCategory},
+		{&types.Limits{Spending: []types.SpendingLimit{{Period: types.LimitPeriodDay}}}, ErrAmountMustBePositive},
+	}
+	for _, tt := range tests {
+		err = s.SetLimits(account.ID, tt.limits)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("SetLimits(%v): error = %v, want %v", tt.limits, err, tt.err)
+		}
+	}
+	err = s.SetLimits(account.ID+1, own)
+	if err != ErrAccountNotFound {
+		t.Errorf("SetLimits(unknown account): error = %v, want %v", err, ErrAccountNotFound)
+	}
+}
+
+func TestService_balanceLimit_moneyComingIn(t *testing.T) {
+	s := &testService{Service: NewService(WithLimits(types.Limits{MaxBalance: 1000}))}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.addAccountWithBalance("+992938151003", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Transfer(other.ID, account.ID, 1)
+	if err != ErrBalanceLimit {
+		t.Errorf("Transfer() to a full account: error = %v, want %v", err, ErrBalanceLimit)
+	}
+
+	payment, err := s.Pay(account.ID, 100, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(other.ID, 100, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := s.Transfer(account.ID, other.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Deposit(account.ID, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Refund(payment.ID, 1)
+	if err != ErrBalanceLimit {
+		t.Errorf("Refund() to a full account: error = %v, want %v", err, ErrBalanceLimit)
+	}
+	err = s.Reject(payment.ID)
+	if err != ErrBalanceLimit {
+		t.Errorf("Reject() to a full account: error = %v, want %v", err, ErrBalanceLimit)
+	}
+	err = s.RejectTransfer(transfer.ID)
+	if err != ErrBalanceLimit {
+		t.Errorf("RejectTransfer() to a full account: error = %v, want %v", err, ErrBalanceLimit)
+	}
+	if s.balance(account) != 1000 || s.balance(other) != 1000 {
+		t.Errorf("balances = %v, %v, want 1000, 1000", s.balance(account), s.balance(other))
+	}
+
+	// the fee account is held to its limits too
+	s = &testService{Service: NewService(
+		WithLimits(types.Limits{MaxBalance: 1000}),
+		WithFees(FeeRules{{Name: "processing", Flat: 10}}),
+		WithFeeAccount(1),
+	)}
+	bank, err := s.addAccountWithBalance("+992938151000", 995)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err = s.addAccountWithBalance(defaultTestAccount.phone, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(account.ID, 100, "auto")
+	if err != ErrBalanceLimit {
+		t.Errorf("Pay() with fees to a full fee account: error = %v, want %v", err, ErrBalanceLimit)
+	}
+	if s.balance(account) != 1000 || s.balance(bank) != 995 {
+		t.Errorf("balances = %v, %v, want 1000, 995", s.balance(account), s.balance(bank))
+	}
+}
+
+// BenchmarkPay_spendingLimits pays from an account with a long history made
+// before the periods of its limits, which the limits need not go through.
+func BenchmarkPay_spendingLimits(b *testing.B) {
+	now := time.Date(2021, 3, 8, 10, 0, 0, 0, time.UTC)
+	s := &testService{Service: NewService(
+		WithClock(func() time.Time { return now }),
+		WithLimits(types.Limits{
+			Spending: []types.SpendingLimit{
+				{Period: types.LimitPeriodDay, Amount: 1 << 40},
+				{Period: types.LimitPeriodMonth, Amount: 1 << 40},
+			},
+		}),
+	)}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 1<<40)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchPayments; i++ {
+		err = s.repo().Payments().Save(&types.Payment{
+			ID:        fmt.Sprintf("old-%d", i),
+			AccountID: account.ID,
+			Amount:    1,
+			Category:  "auto",
+			Status:    types.PaymentStatusOk,
+			Created:   now.AddDate(0, -1, 0),
+			Currency:  account.Currency,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Pay(account.ID, 1, "auto"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
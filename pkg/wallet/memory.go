@@ -0,0 +1,697 @@
+package wallet
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// MemoryRepository keeps accounts, payments and favorites in memory. It is the
+// repository of a zero Service.
+//
+// It keeps copies of the records it is given and hands out copies of the stored
+// ones, so a record changed and saved again never changes under its readers.
+type MemoryRepository struct {
+	mu            sync.RWMutex
+	nextAccountID int64
+	accounts      []*types.Account
+	payments      []*types.Payment
+	favorites     []*types.Favorite
+	postings      []*types.Posting
+	deposits      []*types.Deposit
+	transfers     []*types.Transfer
+	keys          []*types.IdempotencyKey
+	refunds       []*types.Refund
+	index         index
+}
+
+// NewMemoryRepository creates an empty repository.
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{}
+}
+
+func (r *MemoryRepository) Accounts() AccountRepository {
+	return memoryAccounts{r}
+}
+
+func (r *MemoryRepository) Payments() PaymentRepository {
+	return memoryPayments{r}
+}
+
+func (r *MemoryRepository) Favorites() FavoriteRepository {
+	return memoryFavorites{r}
+}
+
+func (r *MemoryRepository) Postings() PostingRepository {
+	return memoryPostings{r}
+}
+
+func (r *MemoryRepository) Deposits() DepositRepository {
+	return memoryDeposits{r}
+}
+
+func (r *MemoryRepository) Transfers() TransferRepository {
+	return memoryTransfers{r}
+}
+
+func (r *MemoryRepository) Keys() KeyRepository {
+	return memoryKeys{r}
+}
+
+func (r *MemoryRepository) Refunds() RefundRepository {
+	return memoryRefunds{r}
+}
+
+type memoryAccounts struct {
+	r *MemoryRepository
+}
+
+func (a memoryAccounts) NextID() (int64, error) {
+	a.r.mu.Lock()
+	defer a.r.mu.Unlock()
+
+	a.r.nextAccountID++
+	return a.r.nextAccountID, nil
+}
+
+func (a memoryAccounts) Save(account *types.Account) error {
+	a.r.mu.Lock()
+	defer a.r.mu.Unlock()
+
+	account = copyAccount(account)
+	if existing := a.r.index.account(account.ID); existing != nil {
+		*existing = *account
+		a.r.index.reindexAccount(existing)
+		return nil
+	}
+
+	a.r.accounts = append(a.r.accounts, account)
+	a.r.index.addAccount(account)
+	if account.ID > a.r.nextAccountID {
+		a.r.nextAccountID = account.ID
+	}
+	return nil
+}
+
+func (a memoryAccounts) ByID(accountID int64) (*types.Account, error) {
+	a.r.mu.RLock()
+	defer a.r.mu.RUnlock()
+
+	account := a.r.index.account(accountID)
+	if account == nil {
+		return nil, ErrAccountNotFound
+	}
+	return copyAccount(account), nil
+}
+
+func (a memoryAccounts) ByPhone(phone types.Phone) (*types.Account, error) {
+	a.r.mu.RLock()
+	defer a.r.mu.RUnlock()
+
+	account := a.r.index.accountByPhone(phone)
+	if account == nil {
+		return nil, ErrAccountNotFound
+	}
+	return copyAccount(account), nil
+}
+
+func (a memoryAccounts) All() ([]*types.Account, error) {
+	a.r.mu.RLock()
+	defer a.r.mu.RUnlock()
+
+	return copyAccounts(a.r.accounts), nil
+}
+
+func (a memoryAccounts) Delete(accountID int64) error {
+	a.r.mu.Lock()
+	defer a.r.mu.Unlock()
+
+	account := a.r.index.account(accountID)
+	if account == nil {
+		return ErrAccountNotFound
+	}
+	a.r.index.removeAccount(account)
+	for i, acc := range a.r.accounts {
+		if acc == account {
+			a.r.accounts = append(a.r.accounts[:i:i], a.r.accounts[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type memoryPayments struct {
+	r *MemoryRepository
+}
+
+func (p memoryPayments) Save(payment *types.Payment) error {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+
+	payment = copyPayment(payment)
+	if existing := p.r.index.payment(payment.ID); existing != nil {
+		*existing = *payment
+		p.r.index.reindexPayment(existing)
+		return nil
+	}
+
+	p.r.payments = append(p.r.payments, payment)
+	p.r.index.addPayment(payment)
+	return nil
+}
+
+func (p memoryPayments) ByID(paymentID string) (*types.Payment, error) {
+	p.r.mu.RLock()
+	defer p.r.mu.RUnlock()
+
+	payment := p.r.index.payment(paymentID)
+	if payment == nil {
+		return nil, ErrPaymentNotFound
+	}
+	return copyPayment(payment), nil
+}
+
+func (p memoryPayments) ByAccount(accountID int64) ([]*types.Payment, error) {
+	p.r.mu.RLock()
+	defer p.r.mu.RUnlock()
+
+	return copyPayments(p.r.index.accountPayments(accountID)), nil
+}
+
+func (p memoryPayments) ByAccountSince(accountID int64, since time.Time) ([]*types.Payment, error) {
+	p.r.mu.RLock()
+	defer p.r.mu.RUnlock()
+
+	return copyPayments(p.r.index.accountPaymentsSince(accountID, since)), nil
+}
+
+func (p memoryPayments) All() ([]*types.Payment, error) {
+	p.r.mu.RLock()
+	defer p.r.mu.RUnlock()
+
+	return copyPayments(p.r.payments), nil
+}
+
+func (p memoryPayments) Delete(paymentID string) error {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+
+	payment := p.r.index.payment(paymentID)
+	if payment == nil {
+		return ErrPaymentNotFound
+	}
+	p.r.index.removePayment(payment)
+	for i, pay := range p.r.payments {
+		if pay == payment {
+			p.r.payments = append(p.r.payments[:i:i], p.r.payments[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type memoryFavorites struct {
+	r *MemoryRepository
+}
+
+func (f memoryFavorites) Save(favorite *types.Favorite) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+
+	favorite = copyFavorite(favorite)
+	if existing := f.r.index.favorite(favorite.ID); existing != nil {
+		*existing = *favorite
+		return nil
+	}
+
+	f.r.favorites = append(f.r.favorites, favorite)
+	f.r.index.addFavorite(favorite)
+	return nil
+}
+
+func (f memoryFavorites) ByID(favoriteID string) (*types.Favorite, error) {
+	f.r.mu.RLock()
+	defer f.r.mu.RUnlock()
+
+	favorite := f.r.index.favorite(favoriteID)
+	if favorite == nil {
+		return nil, ErrFavoriteNotFound
+	}
+	return copyFavorite(favorite), nil
+}
+
+func (f memoryFavorites) All() ([]*types.Favorite, error) {
+	f.r.mu.RLock()
+	defer f.r.mu.RUnlock()
+
+	return copyFavorites(f.r.favorites), nil
+}
+
+func (f memoryFavorites) Delete(favoriteID string) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+
+	favorite := f.r.index.favorite(favoriteID)
+	if favorite == nil {
+		return ErrFavoriteNotFound
+	}
+	f.r.index.removeFavorite(favorite)
+	for i, fav := range f.r.favorites {
+		if fav == favorite {
+			f.r.favorites = append(f.r.favorites[:i:i], f.r.favorites[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type memoryPostings struct {
+	r *MemoryRepository
+}
+
+func (p memoryPostings) Save(posting *types.Posting) error {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+
+	posting = copyPosting(posting)
+	if existing := p.r.index.posting(posting.ID); existing != nil {
+		p.r.index.unlinkPosting(existing, existing.Debit, existing.Credit)
+		*existing = *posting
+		p.r.index.linkPosting(existing)
+		return nil
+	}
+
+	p.r.postings = append(p.r.postings, posting)
+	p.r.index.addPosting(posting)
+	return nil
+}
+
+func (p memoryPostings) ByID(postingID string) (*types.Posting, error) {
+	p.r.mu.RLock()
+	defer p.r.mu.RUnlock()
+
+	posting := p.r.index.posting(postingID)
+	if posting == nil {
+		return nil, ErrPostingNotFound
+	}
+	return copyPosting(posting), nil
+}
+
+func (p memoryPostings) ByAccount(accountID int64) ([]*types.Posting, error) {
+	p.r.mu.RLock()
+	defer p.r.mu.RUnlock()
+
+	return copyPostings(p.r.index.accountPostings(accountID)), nil
+}
+
+func (p memoryPostings) All() ([]*types.Posting, error) {
+	p.r.mu.RLock()
+	defer p.r.mu.RUnlock()
+
+	return copyPostings(p.r.postings), nil
+}
+
+func (p memoryPostings) Delete(postingID string) error {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+
+	posting := p.r.index.posting(postingID)
+	if posting == nil {
+		return ErrPostingNotFound
+	}
+	p.r.index.removePosting(posting)
+	for i, post := range p.r.postings {
+		if post == posting {
+			p.r.postings = append(p.r.postings[:i:i], p.r.postings[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type memoryDeposits struct {
+	r *MemoryRepository
+}
+
+func (d memoryDeposits) Save(deposit *types.Deposit) error {
+	d.r.mu.Lock()
+	defer d.r.mu.Unlock()
+
+	deposit = copyDeposit(deposit)
+	if existing := d.r.index.deposit(deposit.ID); existing != nil {
+		d.r.index.unlinkDeposit(existing)
+		*existing = *deposit
+		d.r.index.linkDeposit(existing)
+		return nil
+	}
+
+	d.r.deposits = append(d.r.deposits, deposit)
+	d.r.index.addDeposit(deposit)
+	return nil
+}
+
+func (d memoryDeposits) ByID(depositID string) (*types.Deposit, error) {
+	d.r.mu.RLock()
+	defer d.r.mu.RUnlock()
+
+	deposit := d.r.index.deposit(depositID)
+	if deposit == nil {
+		return nil, ErrDepositNotFound
+	}
+	return copyDeposit(deposit), nil
+}
+
+func (d memoryDeposits) ByAccount(accountID int64) ([]*types.Deposit, error) {
+	d.r.mu.RLock()
+	defer d.r.mu.RUnlock()
+
+	return copyDeposits(d.r.index.accountDeposits(accountID)), nil
+}
+
+func (d memoryDeposits) All() ([]*types.Deposit, error) {
+	d.r.mu.RLock()
+	defer d.r.mu.RUnlock()
+
+	return copyDeposits(d.r.deposits), nil
+}
+
+func (d memoryDeposits) Delete(depositID string) error {
+	d.r.mu.Lock()
+	defer d.r.mu.Unlock()
+
+	deposit := d.r.index.deposit(depositID)
+	if deposit == nil {
+		return ErrDepositNotFound
+	}
+	d.r.index.removeDeposit(deposit)
+	for i, dep := range d.r.deposits {
+		if dep == deposit {
+			d.r.deposits = append(d.r.deposits[:i:i], d.r.deposits[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type memoryTransfers struct {
+	r *MemoryRepository
+}
+
+func (t memoryTransfers) Save(transfer *types.Transfer) error {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+
+	transfer = copyTransfer(transfer)
+	if existing := t.r.index.transfer(transfer.ID); existing != nil {
+		t.r.index.unlinkTransfer(existing)
+		*existing = *transfer
+		t.r.index.linkTransfer(existing)
+		return nil
+	}
+
+	t.r.transfers = append(t.r.transfers, transfer)
+	t.r.index.addTransfer(transfer)
+	return nil
+}
+
+func (t memoryTransfers) ByID(transferID string) (*types.Transfer, error) {
+	t.r.mu.RLock()
+	defer t.r.mu.RUnlock()
+
+	transfer := t.r.index.transfer(transferID)
+	if transfer == nil {
+		return nil, ErrTransferNotFound
+	}
+	return copyTransfer(transfer), nil
+}
+
+func (t memoryTransfers) ByAccount(accountID int64) ([]*types.Transfer, error) {
+	t.r.mu.RLock()
+	defer t.r.mu.RUnlock()
+
+	return copyTransfers(t.r.index.accountTransfers(accountID)), nil
+}
+
+func (t memoryTransfers) All() ([]*types.Transfer, error) {
+	t.r.mu.RLock()
+	defer t.r.mu.RUnlock()
+
+	return copyTransfers(t.r.transfers), nil
+}
+
+func (t memoryTransfers) Delete(transferID string) error {
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+
+	transfer := t.r.index.transfer(transferID)
+	if transfer == nil {
+		return ErrTransferNotFound
+	}
+	t.r.index.removeTransfer(transfer)
+	for i, tr := range t.r.transfers {
+		if tr == transfer {
+			t.r.transfers = append(t.r.transfers[:i:i], t.r.transfers[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type memoryKeys struct {
+	r *MemoryRepository
+}
+
+func (k memoryKeys) Save(key *types.IdempotencyKey) error {
+	k.r.mu.Lock()
+	defer k.r.mu.Unlock()
+
+	key = copyIdempotencyKey(key)
+	if existing := k.r.index.key(key.AccountID, key.Key); existing != nil {
+		*existing = *key
+		return nil
+	}
+
+	k.r.keys = append(k.r.keys, key)
+	k.r.index.addKey(key)
+	return nil
+}
+
+func (k memoryKeys) ByKey(accountID int64, key string) (*types.IdempotencyKey, error) {
+	k.r.mu.RLock()
+	defer k.r.mu.RUnlock()
+
+	stored := k.r.index.key(accountID, key)
+	if stored == nil {
+		return nil, ErrKeyNotFound
+	}
+	return copyIdempotencyKey(stored), nil
+}
+
+func (k memoryKeys) All() ([]*types.IdempotencyKey, error) {
+	k.r.mu.RLock()
+	defer k.r.mu.RUnlock()
+
+	return copyIdempotencyKeys(k.r.keys), nil
+}
+
+func (k memoryKeys) Delete(accountID int64, key string) error {
+	k.r.mu.Lock()
+	defer k.r.mu.Unlock()
+
+	stored := k.r.index.key(accountID, key)
+	if stored == nil {
+		return ErrKeyNotFound
+	}
+	k.r.index.removeKey(stored)
+	for i, kk := range k.r.keys {
+		if kk == stored {
+			k.r.keys = append(k.r.keys[:i:i], k.r.keys[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type memoryRefunds struct {
+	r *MemoryRepository
+}
+
+func (f memoryRefunds) Save(refund *types.Refund) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+
+	refund = copyRefund(refund)
+	if existing := f.r.index.refund(refund.ID); existing != nil {
+		f.r.index.unlinkRefund(existing)
+		*existing = *refund
+		f.r.index.linkRefund(existing)
+		return nil
+	}
+
+	f.r.refunds = append(f.r.refunds, refund)
+	f.r.index.addRefund(refund)
+	return nil
+}
+
+func (f memoryRefunds) ByID(refundID string) (*types.Refund, error) {
+	f.r.mu.RLock()
+	defer f.r.mu.RUnlock()
+
+	refund := f.r.index.refund(refundID)
+	if refund == nil {
+		return nil, ErrRefundNotFound
+	}
+	return copyRefund(refund), nil
+}
+
+func (f memoryRefunds) ByPayment(paymentID string) ([]*types.Refund, error) {
+	f.r.mu.RLock()
+	defer f.r.mu.RUnlock()
+
+	return copyRefunds(f.r.index.paymentRefunds(paymentID)), nil
+}
+
+func (f memoryRefunds) All() ([]*types.Refund, error) {
+	f.r.mu.RLock()
+	defer f.r.mu.RUnlock()
+
+	return copyRefunds(f.r.refunds), nil
+}
+
+func (f memoryRefunds) Delete(refundID string) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+
+	refund := f.r.index.refund(refundID)
+	if refund == nil {
+		return ErrRefundNotFound
+	}
+	f.r.index.removeRefund(refund)
+	for i, ref := range f.r.refunds {
+		if ref == refund {
+			f.r.refunds = append(f.r.refunds[:i:i], f.r.refunds[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// copyAccount returns a copy of the account sharing no memory with it.
+func copyAccount(account *types.Account) *types.Account {
+	copied := *account
+	if account.Limits != nil {
+		limits := *account.Limits
+		if limits.Spending != nil {
+			limits.Spending = append([]types.SpendingLimit{}, limits.Spending...)
+		}
+		copied.Limits = &limits
+	}
+	return &copied
+}
+
+func copyAccounts(accounts []*types.Account) []*types.Account {
+	var copies []*types.Account
+	for _, account := range accounts {
+		copies = append(copies, copyAccount(account))
+	}
+	return copies
+}
+
+// copyPayment returns a copy of the payment sharing no memory with it.
+func copyPayment(payment *types.Payment) *types.Payment {
+	copied := *payment
+	if payment.Refunds != nil {
+		copied.Refunds = append([]types.Refund{}, payment.Refunds...)
+	}
+	if payment.Fees != nil {
+		copied.Fees = append([]types.Fee{}, payment.Fees...)
+	}
+	return &copied
+}
+
+func copyPayments(payments []*types.Payment) []*types.Payment {
+	var copies []*types.Payment
+	for _, payment := range payments {
+		copies = append(copies, copyPayment(payment))
+	}
+	return copies
+}
+
+func copyFavorite(favorite *types.Favorite) *types.Favorite {
+	copied := *favorite
+	return &copied
+}
+
+func copyFavorites(favorites []*types.Favorite) []*types.Favorite {
+	var copies []*types.Favorite
+	for _, favorite := range favorites {
+		copies = append(copies, copyFavorite(favorite))
+	}
+	return copies
+}
+
+func copyPosting(posting *types.Posting) *types.Posting {
+	copied := *posting
+	return &copied
+}
+
+func copyPostings(postings []*types.Posting) []*types.Posting {
+	var copies []*types.Posting
+	for _, posting := range postings {
+		copies = append(copies, copyPosting(posting))
+	}
+	return copies
+}
+
+func copyDeposit(deposit *types.Deposit) *types.Deposit {
+	copied := *deposit
+	return &copied
+}
+
+func copyDeposits(deposits []*types.Deposit) []*types.Deposit {
+	var copies []*types.Deposit
+	for _, deposit := range deposits {
+		copies = append(copies, copyDeposit(deposit))
+	}
+	return copies
+}
+
+func copyTransfer(transfer *types.Transfer) *types.Transfer {
+	copied := *transfer
+	return &copied
+}
+
+func copyTransfers(transfers []*types.Transfer) []*types.Transfer {
+	var copies []*types.Transfer
+	for _, transfer := range transfers {
+		copies = append(copies, copyTransfer(transfer))
+	}
+	return copies
+}
+
+func copyIdempotencyKey(key *types.IdempotencyKey) *types.IdempotencyKey {
+	copied := *key
+	return &copied
+}
+
+func copyIdempotencyKeys(keys []*types.IdempotencyKey) []*types.IdempotencyKey {
+	var copies []*types.IdempotencyKey
+	for _, key := range keys {
+		copies = append(copies, copyIdempotencyKey(key))
+	}
+	return copies
+}
+
+func copyRefund(refund *types.Refund) *types.Refund {
+	copied := *refund
+	return &copied
+}
+
+func copyRefunds(refunds []*types.Refund) []*types.Refund {
+	var copies []*types.Refund
+	for _, refund := range refunds {
+		copies = append(copies, copyRefund(refund))
+	}
+	return copies
+}
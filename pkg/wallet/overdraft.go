@@ -0,0 +1,222 @@
+package wallet
+
+import (
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// LedgerOverdraft is where the fees and interest charged to overdrawn
+// accounts go.
+const LedgerOverdraft int64 = -5
+
+// OverdraftCharges works out what accounts are charged for using their
+// overdraft, in the currency of the account. A zero amount charges nothing.
+type OverdraftCharges interface {
+	// Fee is charged for a payment or transfer of amount leaving the account
+	// overdrawn. The account is given as it was before.
+	Fee(account types.Account, amount types.Money) (types.Money, error)
+	// Interest is charged to an overdrawn account by ChargeOverdraftInterest.
+	Interest(account types.Account) (types.Money, error)
+}
+
+// WithOverdraftCharges makes the service charge overdrawn accounts by charges.
+// Without it using an overdraft is free.
+func WithOverdraftCharges(charges OverdraftCharges) Option {
+	return func(s *Service) {
+		s.overdraftCharges = charges
+	}
+}
+
+// OverdraftTerms are OverdraftCharges of a FlatFee for every payment or
+// transfer leaving an account overdrawn and of an InterestRate, the share of
+// the overdrawn amount charged by each ChargeOverdraftInterest, such as
+// "0.0005". Interest is rounded half away from zero.
+type OverdraftTerms struct {
+	FlatFee      types.Money
+	InterestRate types.Rate
+}
+
+func (t OverdraftTerms) Fee(account types.Account, amount types.Money) (types.Money, error) {
+	return t.FlatFee, nil
+}
+
+func (t OverdraftTerms) Interest(account types.Account) (types.Money, error) {
+	if t.InterestRate == "" || account.Balance >= 0 {
+		return 0, nil
+	}
+	return convert(-account.Balance, account.Currency, account.Currency, t.InterestRate)
+}
+
+// SetOverdraft lets the account pay and transfer down to a balance of
+// -limit. Zero, as accounts start with, allows no overdraft. An account
+// already overdrawn by more than a lowered limit keeps its balance but can
+// not pay until it is back within the limit.
+func (s *Service) SetOverdraft(accountID int64, limit types.Money) error {
+	if limit < 0 {
+		return ErrNegativeLimit
+	}
+
+	account, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	updated := *account
+	updated.Overdraft = limit
+	return s.commit(change{op: "overdraft", accounts: []*types.Account{&updated}})
+}
+
+// OverdrawnAccounts returns copies of the accounts with a negative balance,
+// in the order they were registered.
+func (s *Service) OverdrawnAccounts() ([]types.Account, error) {
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	accounts, err := s.repo().Accounts().All()
+	if err != nil {
+		return nil, err
+	}
+	overdrawn := []types.Account{}
+	for _, account := range accounts {
+		if account.Balance < 0 {
+			overdrawn = append(overdrawn, *account)
+		}
+	}
+	return overdrawn, nil
+}
+
+// ChargeOverdraftInterest charges every overdrawn account the interest of the
+// service's OverdraftCharges. It is meant to be called once for every period
+// the interest is counted over, a day for example. Interest is taken even
+// past the overdraft limit, and posted to LedgerOverdraft without reference.
+func (s *Service) ChargeOverdraftInterest() error {
+	if s.overdraftCharges == nil {
+		return nil
+	}
+
+	// the accounts are only read under their locks, which chargeInterest
+	// takes one by one
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		return err
+	}
+	accounts, err := s.repo().Accounts().All()
+	if err != nil {
+		unlock()
+		return err
+	}
+	ids := make([]int64, len(accounts))
+	for i, account := range accounts {
+		ids[i] = account.ID
+	}
+	unlock()
+
+	for _, id := range ids {
+		err = s.chargeInterest(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chargeInterest is ChargeOverdraftInterest for one account, which is left
+// alone unless it is overdrawn.
+func (s *Service) chargeInterest(accountID int64) error {
+	account, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if account.Balance >= 0 {
+		return nil
+	}
+	interest, err := s.overdraftCharges.Interest(*account)
+	if err != nil || interest <= 0 {
+		return err
+	}
+	updated := *account
+	updated.Balance, err = account.Balance.Sub(interest)
+	if err != nil {
+		return err
+	}
+	return s.commit(change{
+		op:       "interest",
+		accounts: []*types.Account{&updated},
+		postings: []*types.Posting{s.newPosting(accountID, LedgerOverdraft, interest, "")},
+	})
+}
+
+// available returns how much the account may pay or transfer: its balance
+// and what is left of its overdraft.
+func available(account *types.Account) (types.Money, error) {
+	return account.Balance.Add(account.Overdraft)
+}
+
+// overdraftFee returns the fee for a payment or transfer of amount from the
+// account, as it is before, if it leaves the account overdrawn. The fee
+// counts against the overdraft like the amount, so callers check both
+// against available. The caller holds the lock of the account.
+func (s *Service) overdraftFee(account *types.Account, amount types.Money) (types.Money, error) {
+	if s.overdraftCharges == nil {
+		return 0, nil
+	}
+	after, err := account.Balance.Sub(amount)
+	if err != nil || after >= 0 {
+		return 0, err
+	}
+
+	fee, err := s.overdraftCharges.Fee(*account, amount)
+	if err != nil || fee <= 0 {
+		return 0, err
+	}
+	return fee, nil
+}
+
+// chargeOverdraft takes fee, worked out by overdraftFee, from updated, the
+// account after the payment or transfer referred to by reference. It
+// returns the posting of the fee, if any.
+func (s *Service) chargeOverdraft(updated *types.Account, fee types.Money, reference string) ([]*types.Posting, error) {
+	if fee == 0 {
+		return nil, nil
+	}
+	var err error
+	updated.Balance, err = updated.Balance.Sub(fee)
+	if err != nil {
+		return nil, err
+	}
+	return []*types.Posting{s.newPosting(updated.ID, LedgerOverdraft, fee, reference)}, nil
+}
+
+// refundOverdraft gives back to updated, the account a rejected payment or
+// transfer referred to by reference was made from, the overdraft fee
+// chargeOverdraft took for it. It returns the posting giving it back, if
+// any. The caller holds the lock of the account.
+func (s *Service) refundOverdraft(updated *types.Account, reference string) ([]*types.Posting, error) {
+	postings, err := s.repo().Postings().ByAccount(updated.ID)
+	if err != nil {
+		return nil, err
+	}
+	var fee types.Money
+	for _, posting := range postings {
+		if posting.Reference != reference || posting.Debit != updated.ID || posting.Credit != LedgerOverdraft {
+			continue
+		}
+		fee, err = fee.Add(posting.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fee == 0 {
+		return nil, nil
+	}
+	updated.Balance, err = updated.Balance.Add(fee)
+	if err != nil {
+		return nil, err
+	}
+	return []*types.Posting{s.newPosting(LedgerOverdraft, updated.ID, fee, reference)}, nil
+}
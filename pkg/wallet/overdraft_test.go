@@ -0,0 +1,279 @@
+package wallet
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_Pay_overdraft(t *testing.T) {
+	s := &testService{Service: NewService(WithOverdraftCharges(OverdraftTerms{FlatFee: 10, InterestRate: "0.01"}))}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Pay(account.ID, 101, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay() without overdraft: error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+
+	err = s.SetOverdraft(account.ID, 500)
+	if err != nil {
+		t.Fatalf("SetOverdraft(): error = %v", err)
+	}
+	_, err = s.Pay(account.ID, 60, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 490, "auto")
+	if err != nil {
+		t.Fatalf("Pay() within overdraft: error = %v", err)
+	}
+	if s.balance(account) != -460 {
+		t.Errorf("Pay() within overdraft: balance = %v, want -460", s.balance(account))
+	}
+	_, err = s.Pay(account.ID, 41, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay() past overdraft: error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+
+	statement, err := s.ExportAccountStatement(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := statement[len(statement)-1]
+	if fee.Kind != types.TransactionFee || fee.ID != payment.ID || fee.Amount != -10 || fee.Balance != -460 {
+		t.Errorf("ExportAccountStatement() ends with %+v, want the fee of the payment", fee)
+	}
+
+	other, err := s.addAccountWithBalance("+992938151003", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overdrawn, err := s.OverdrawnAccounts()
+	if err != nil || !reflect.DeepEqual(overdrawn, []types.Account{*account}) {
+		t.Errorf("OverdrawnAccounts() = %v, %v, want %v", overdrawn, err, []types.Account{*account})
+	}
+
+	err = s.ChargeOverdraftInterest()
+	if err != nil {
+		t.Fatalf("ChargeOverdraftInterest(): error = %v", err)
+	}
+	if s.balance(account) != -465 || s.balance(other) != 100 {
+		t.Errorf("ChargeOverdraftInterest(): balances = %v, %v, want -465, 100", s.balance(account), s.balance(other))
+	}
+
+	err = s.Deposit(account.ID, 565)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overdrawn, err = s.OverdrawnAccounts()
+	if err != nil || len(overdrawn) != 0 {
+		t.Errorf("OverdrawnAccounts() = %v, %v, want none", overdrawn, err)
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+
+	err = s.SetOverdraft(account.ID, -1)
+	if err != ErrNegativeLimit {
+		t.Errorf("SetOverdraft(-1): error = %v, want %v", err, ErrNegativeLimit)
+	}
+}
+
+func TestService_Transfer_overdraft(t *testing.T) {
+	s := newTestService()
+	from, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992938151003", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetOverdraft(from.ID, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Transfer(from.ID, to.ID, 151)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Transfer() past overdraft: error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+	_, err = s.Transfer(from.ID, to.ID, 150)
+	if err != nil {
+		t.Fatalf("Transfer() within overdraft: error = %v", err)
+	}
+	// without OverdraftCharges using the overdraft is free
+	if s.balance(from) != -50 || s.balance(to) != 250 {
+		t.Errorf("Transfer(): balances = %v, %v, want -50, 250", s.balance(from), s.balance(to))
+	}
+	err = s.ChargeOverdraftInterest()
+	if err != nil || s.balance(from) != -50 {
+		t.Errorf("ChargeOverdraftInterest() = %v, balance %v, want nothing charged", err, s.balance(from))
+	}
+}
+
+func TestService_Reject_overdraft(t *testing.T) {
+	s := &testService{Service: NewService(WithOverdraftCharges(OverdraftTerms{FlatFee: 5}))}
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.addAccountWithBalance("+992938151003", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{account.ID, other.ID} {
+		err = s.SetOverdraft(id, 500)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the overdraft fee goes back with the payment or transfer it was for
+	payment, err := s.Pay(account.ID, 150, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payment.ID)
+	if err != nil || s.balance(account) != 100 {
+		t.Errorf("Reject() = %v, balance %v, want 100", err, s.balance(account))
+	}
+	transfer, err := s.Transfer(account.ID, other.ID, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RejectTransfer(transfer.ID)
+	if err != nil || s.balance(account) != 100 || s.balance(other) != 100 {
+		t.Errorf("RejectTransfer() = %v, balances %v, %v, want 100, 100", err, s.balance(account), s.balance(other))
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+	statement, err := s.ExportAccountStatement(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := statement[len(statement)-1]
+	if last.Kind != types.TransactionFee || last.ID != transfer.ID || last.Amount != 5 {
+		t.Errorf("ExportAccountStatement() ends with %+v, want the fee given back", last)
+	}
+
+	// rejecting a transfer does not take the recipient into its overdraft
+	transfer, err = s.Transfer(account.ID, other.ID, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(other.ID, 120, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RejectTransfer(transfer.ID)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("RejectTransfer() from an account short of the money: error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+	if s.balance(account) != 50 || s.balance(other) != 30 {
+		t.Errorf("balances = %v, %v, want 50, 30", s.balance(account), s.balance(other))
+	}
+}
+
+func TestService_overdraftFeeWithinLimit(t *testing.T) {
+	s := &testService{Service: NewService(WithOverdraftCharges(OverdraftTerms{FlatFee: 50}))}
+	payer, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := s.RegisterAccount("+992938151003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range []*types.Account{payer, sender} {
+		err = s.SetOverdraft(account.ID, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = s.Pay(payer.ID, 100, "auto")
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Pay() with the fee past overdraft: error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+	_, err = s.Transfer(sender.ID, payer.ID, 100)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("Transfer() with the fee past overdraft: error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+
+	_, err = s.Pay(payer.ID, 50, "auto")
+	if err != nil {
+		t.Errorf("Pay() with the fee within overdraft: error = %v", err)
+	}
+	_, err = s.Transfer(sender.ID, payer.ID, 50)
+	if err != nil {
+		t.Errorf("Transfer() with the fee within overdraft: error = %v", err)
+	}
+	for _, account := range []*types.Account{payer, sender} {
+		if s.balance(account) < -100 {
+			t.Errorf("account %v: balance = %v, below the overdraft of 100", account.ID, s.balance(account))
+		}
+	}
+	if s.balance(sender) != -100 {
+		t.Errorf("Transfer(): balance = %v, want -100", s.balance(sender))
+	}
+}
+
+func TestService_ChargeOverdraftInterest_concurrentPay(t *testing.T) {
+	s := &testService{Service: NewService(WithOverdraftCharges(OverdraftTerms{InterestRate: "0.01"}))}
+	accounts := []*types.Account{}
+	for i := 0; i < 20; i++ {
+		account, err := s.RegisterAccount(types.Phone(fmt.Sprintf("+9929381510%02d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.SetOverdraft(account.ID, 1_000_000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, account := range accounts {
+		wg.Add(1)
+		go func(accountID int64) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				_, err := s.Pay(accountID, 100, "auto")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(account.ID)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			err := s.ChargeOverdraftInterest()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	err := s.CheckLedger()
+	if err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+}
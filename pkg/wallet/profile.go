@@ -0,0 +1,178 @@
+package wallet
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+var ErrBadEmail = errors.New("malformed email address")
+var ErrBadLocale = errors.New("malformed locale")
+
+// ChangePhone moves the account to another phone, read like the one of
+// RegisterAccount. A phone of another account gives ErrPhoneNumberRegistred;
+// the account's own phone, however written, changes nothing.
+func (s *Service) ChangePhone(accountID int64, phone types.Phone) error {
+	phone, err := s.parsePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	account, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// registrations and other phone changes check phones under s.mu too
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = checkNotClosed(account)
+	if err != nil {
+		return err
+	}
+	if account.Phone == phone {
+		return nil
+	}
+	_, err = s.repo().Accounts().ByPhone(phone)
+	if err == nil {
+		return ErrPhoneNumberRegistred
+	}
+	if err != ErrAccountNotFound {
+		return err
+	}
+
+	updated := *account
+	updated.Phone = phone
+	return s.commit(change{op: "phone", accounts: []*types.Account{&updated}})
+}
+
+// UpdateProfile replaces the profile of the account. Spaces around the
+// fields are dropped and the locale is written in its usual case, e.g.
+// "ru_ru" as "ru-RU". A malformed email gives ErrBadEmail and a malformed
+// locale ErrBadLocale; empty fields are fine.
+func (s *Service) UpdateProfile(accountID int64, profile types.Profile) error {
+	profile, err := parseProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	account, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = checkNotClosed(account)
+	if err != nil {
+		return err
+	}
+
+	updated := *account
+	updated.Profile = profile
+	return s.commit(change{op: "profile", accounts: []*types.Account{&updated}})
+}
+
+// SearchAccounts returns the accounts whose phone, name, email or locale
+// contains text, ignoring case, in the order they were registered. A text
+// that reads as a phone number also finds the account with that phone
+// however it is written, and the empty text finds every account.
+func (s *Service) SearchAccounts(text string) ([]types.Account, error) {
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	accounts, err := s.repo().Accounts().All()
+	if err != nil {
+		return nil, err
+	}
+
+	phone, err := s.parsePhone(types.Phone(text))
+	if err != nil {
+		phone = ""
+	}
+	text = strings.ToLower(strings.TrimSpace(text))
+	found := []types.Account{}
+	for _, account := range accounts {
+		if account.Phone == phone || matchesAccount(account, text) {
+			found = append(found, *account)
+		}
+	}
+	return found, nil
+}
+
+// matchesAccount reports whether a field of the account contains text,
+// which is in lower case.
+func matchesAccount(account *types.Account, text string) bool {
+	for _, field := range []string{string(account.Phone), account.Name, account.Email, account.Locale} {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProfile checks the profile and returns it as UpdateProfile stores it.
+func parseProfile(profile types.Profile) (types.Profile, error) {
+	profile.Name = strings.TrimSpace(profile.Name)
+	profile.Email = strings.TrimSpace(profile.Email)
+	if profile.Email != "" {
+		address, err := mail.ParseAddress(profile.Email)
+		if err != nil || address.Name != "" || address.Address != profile.Email {
+			return types.Profile{}, ErrBadEmail
+		}
+	}
+	locale, err := parseLocale(strings.TrimSpace(profile.Locale))
+	if err != nil {
+		return types.Profile{}, err
+	}
+	profile.Locale = locale
+	return profile, nil
+}
+
+// parseLocale reads a language tag of a language of 2 or 3 letters, an
+// optional script of 4 letters and an optional region of 2 letters or 3
+// digits, separated by "-" or "_", e.g. "tg", "ru-RU" or "uz_Cyrl_UZ".
+func parseLocale(text string) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	parts := strings.FieldsFunc(text, func(r rune) bool { return r == '-' || r == '_' })
+	if len(parts) == 0 || len(parts) > 3 || strings.Count(text, "-")+strings.Count(text, "_") != len(parts)-1 {
+		return "", ErrBadLocale
+	}
+	if len(parts[0]) < 2 || len(parts[0]) > 3 || !onlyLetters(parts[0]) {
+		return "", ErrBadLocale
+	}
+	tag := []string{strings.ToLower(parts[0])}
+	rest := parts[1:]
+	if len(rest) > 0 && len(rest[0]) == 4 && onlyLetters(rest[0]) {
+		tag = append(tag, strings.ToUpper(rest[0][:1])+strings.ToLower(rest[0][1:]))
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		switch {
+		case len(rest) == 1 && len(rest[0]) == 2 && onlyLetters(rest[0]):
+			tag = append(tag, strings.ToUpper(rest[0]))
+		case len(rest) == 1 && len(rest[0]) == 3 && strings.Trim(rest[0], "0123456789") == "":
+			tag = append(tag, rest[0])
+		default:
+			return "", ErrBadLocale
+		}
+	}
+	return strings.Join(tag, "-"), nil
+}
+
+// onlyLetters reports whether text is made of ASCII letters only.
+func onlyLetters(text string) bool {
+	for _, r := range text {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_ChangePhone(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.RegisterAccount("+992938151003")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.ChangePhone(account.ID, "0938151003")
+	if err != ErrPhoneNumberRegistred {
+		t.Errorf("ChangePhone() to a taken phone: error = %v, want %v", err, ErrPhoneNumberRegistred)
+	}
+	err = s.ChangePhone(account.ID, "93 815 1007")
+	if err != nil {
+		t.Errorf("ChangePhone() to its own phone: error = %v", err)
+	}
+	err = s.ChangePhone(account.ID, "call me")
+	if err != ErrBadPhone {
+		t.Errorf("ChangePhone() to a malformed phone: error = %v, want %v", err, ErrBadPhone)
+	}
+
+	err = s.ChangePhone(account.ID, "0938151009")
+	if err != nil {
+		t.Fatalf("ChangePhone(): error = %v", err)
+	}
+	found, err := s.repo().Accounts().ByPhone("+992938151009")
+	if err != nil || found.ID != account.ID {
+		t.Errorf("ByPhone() of the new phone = %v, %v, want account %v", found, err, account.ID)
+	}
+	// the old phone is free again
+	_, err = s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Errorf("RegisterAccount() with the old phone: error = %v", err)
+	}
+
+	err = s.CloseAccount(other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ChangePhone(other.ID, "+992938151010")
+	if err != ErrAccountClosed {
+		t.Errorf("ChangePhone() of a closed account: error = %v, want %v", err, ErrAccountClosed)
+	}
+	err = s.ChangePhone(404, "+992938151010")
+	if err != ErrAccountNotFound {
+		t.Errorf("ChangePhone() of an unknown account: error = %v, want %v", err, ErrAccountNotFound)
+	}
+}
+
+func TestService_UpdateProfile(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := &testService{Service: NewService(WithClock(func() time.Time { return now }))}
+	account, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.Created.Equal(now) {
+		t.Errorf("RegisterAccount(): created = %v, want %v", account.Created, now)
+	}
+
+	profile := func() types.Profile {
+		found, err := s.FindAccountByID(account.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found.Profile
+	}
+
+	err = s.UpdateProfile(account.ID, types.Profile{Name: " Rustam Sharipov ", Email: "rustam@example.tj", Locale: "ru_ru"})
+	if err != nil {
+		t.Fatalf("UpdateProfile(): error = %v", err)
+	}
+	want := types.Profile{Name: "Rustam Sharipov", Email: "rustam@example.tj", Locale: "ru-RU"}
+	if profile() != want {
+		t.Errorf("UpdateProfile(): profile = %+v, want %+v", profile(), want)
+	}
+
+	for _, tt := range []struct {
+		profile types.Profile
+		err     error
+	}{
+		{types.Profile{Email: "rustam"}, ErrBadEmail},
+		{types.Profile{Email: "Rustam <rustam@example.tj>"}, ErrBadEmail},
+		{types.Profile{Locale: "russian"}, ErrBadLocale},
+		{types.Profile{Locale: "ru--RU"}, ErrBadLocale},
+		{types.Profile{Locale: "ru-RUS"}, ErrBadLocale},
+	} {
+		err = s.UpdateProfile(account.ID, tt.profile)
+		if err != tt.err {
+			t.Errorf("UpdateProfile(%+v): error = %v, want %v", tt.profile, err, tt.err)
+		}
+	}
+	if profile() != want {
+		t.Errorf("UpdateProfile() failing: profile = %+v, want %+v", profile(), want)
+	}
+
+	err = s.UpdateProfile(account.ID, types.Profile{Locale: "uz_cyrl_uz"})
+	if err != nil || profile() != (types.Profile{Locale: "uz-Cyrl-UZ"}) {
+		t.Errorf("UpdateProfile() = %+v, %v, want the locale uz-Cyrl-UZ", profile(), err)
+	}
+}
+
+func TestService_SearchAccounts(t *testing.T) {
+	s := newTestService()
+	profiles := map[types.Phone]types.Profile{
+		"+992938151007": {Name: "Rustam Sharipov", Email: "rustam@example.tj", Locale: "tg-TJ"},
+		"+992938151003": {Name: "Мадина Рахимова", Email: "madina@mail.ru", Locale: "ru-RU"},
+		"+992938151004": {},
+	}
+	ids := map[types.Phone]int64{}
+	for _, phone := range []types.Phone{"+992938151007", "+992938151003", "+992938151004"} {
+		account, err := s.RegisterAccount(phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.UpdateProfile(account.ID, profiles[phone])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[phone] = account.ID
+	}
+
+	for _, tt := range []struct {
+		text string
+		want []int64
+	}{
+		{"sharipov", []int64{ids["+992938151007"]}},
+		{"МАДИНА", []int64{ids["+992938151003"]}},
+		{"@", []int64{ids["+992938151007"], ids["+992938151003"]}},
+		{"ru-ru", []int64{ids["+992938151003"]}},
+		{"8151004", []int64{ids["+992938151004"]}},
+		{"093 815 1004", []int64{ids["+992938151004"]}},
+		{"", []int64{ids["+992938151007"], ids["+992938151003"], ids["+992938151004"]}},
+		{"nobody", []int64{}},
+	} {
+		accounts, err := s.SearchAccounts(tt.text)
+		if err != nil {
+			t.Fatalf("SearchAccounts(%q): error = %v", tt.text, err)
+		}
+		got := []int64{}
+		for _, account := range accounts {
+			got = append(got, account.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SearchAccounts(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package wallet
+
+import (
+	"errors"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrRefundTooLarge = errors.New("refund is more than is left of the payment")
+var ErrRefundAccountMismatch = errors.New("refund is for another account than its payment")
+
+// Refund gives part of a payment back to its account. A payment may be
+// refunded several times as long as the refunds add up to at most its
+// amount; a rejected payment has nothing left to refund. Each refund is kept
+// as a record of its own and gets a posting of its own in the ledger.
+func (s *Service) Refund(paymentID string, amount types.Money) (*types.Refund, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+
+	payment, target, unlock, err := s.lockPayment(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	left, err := s.refundable(payment)
+	if err != nil {
+		return nil, err
+	}
+	if amount > left {
+		return nil, ErrRefundTooLarge
+	}
+	err = checkNotClosed(target)
+	if err != nil {
+		return nil, err
+	}
+
+	refund := &types.Refund{
+		ID:        uuid.New().String(),
+		PaymentID: payment.ID,
+		AccountID: payment.AccountID,
+		Amount:    amount,
+		Created:   s.now(),
+	}
+	account := *target
+	account.Balance, err = target.Balance.Add(amount)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkBalanceLimit(target, account.Balance)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.commit(change{
+		op:       "refund",
+		accounts: []*types.Account{&account},
+		refunds:  []*types.Refund{refund},
+		postings: []*types.Posting{s.newPosting(LedgerPayments, account.ID, amount, refund.ID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// refundable returns how much of the payment is left to refund. The caller
+// holds the lock of its account.
+func (s *Service) refundable(payment *types.Payment) (types.Money, error) {
+	if payment.Status == types.PaymentStatusFail {
+		return 0, nil
+	}
+
+	refunds, err := s.repo().Refunds().ByPayment(payment.ID)
+	if err != nil {
+		return 0, err
+	}
+	left := payment.Amount
+	for _, refund := range refunds {
+		left, err = left.Sub(refund.Amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return left, nil
+}
+
+func (s *Service) FindRefundByID(refundID string) (*types.Refund, error) {
+	return s.repo().Refunds().ByID(refundID)
+}
+
+// PaymentRefunds returns the refunds of the payment, oldest first.
+func (s *Service) PaymentRefunds(paymentID string) ([]types.Refund, error) {
+	_, _, unlock, err := s.lockPayment(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.paymentRefunds(paymentID)
+}
+
+// paymentRefunds returns copies of the refunds of the payment. The caller
+// holds the lock of its account.
+func (s *Service) paymentRefunds(paymentID string) ([]types.Refund, error) {
+	refunds, err := s.repo().Refunds().ByPayment(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentRefunds := []types.Refund{}
+	for _, refund := range refunds {
+		paymentRefunds = append(paymentRefunds, *refund)
+	}
+	return paymentRefunds, nil
+}
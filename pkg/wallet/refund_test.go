@@ -0,0 +1,156 @@
+package wallet
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_Refund(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 50, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.Refund(payment.ID, 20)
+	if err != nil {
+		t.Fatalf("Refund(): error = %v", err)
+	}
+	if first.PaymentID != payment.ID || first.AccountID != account.ID || first.Amount != 20 {
+		t.Errorf("Refund() = %+v", first)
+	}
+	second, err := s.Refund(payment.ID, 30)
+	if err != nil {
+		t.Fatalf("Refund() of the rest: error = %v", err)
+	}
+	if s.balance(account) != 100 {
+		t.Errorf("Refund(): balance = %v, want 100", s.balance(account))
+	}
+	_, err = s.Refund(payment.ID, 1)
+	if err != ErrRefundTooLarge {
+		t.Errorf("Refund() past the amount: error = %v, want %v", err, ErrRefundTooLarge)
+	}
+	_, err = s.Refund(payment.ID, 0)
+	if err != ErrAmountMustBePositive {
+		t.Errorf("Refund(0): error = %v, want %v", err, ErrAmountMustBePositive)
+	}
+	_, err = s.Refund("unknown", 1)
+	if err != ErrPaymentNotFound {
+		t.Errorf("Refund(unknown): error = %v, want %v", err, ErrPaymentNotFound)
+	}
+
+	history, err := s.ExportAccountHistory(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || len(history[0].Refunds) != 2 ||
+		history[0].Refunds[0] != *first || history[0].Refunds[1] != *second {
+		t.Errorf("ExportAccountHistory() = %+v, want the payment with both refunds", history)
+	}
+	if payment.Refunds != nil {
+		t.Errorf("ExportAccountHistory(): stored payment has refunds %v", payment.Refunds)
+	}
+
+	statement, err := s.ExportAccountStatement(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := statement[len(statement)-1]
+	if last.Kind != types.TransactionRefund || last.ID != second.ID || last.Amount != 30 {
+		t.Errorf("ExportAccountStatement(): last = %+v, want the second refund", last)
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+}
+
+func TestService_Reject_afterRefund(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 50, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Refund(payment.ID, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Reject(payment.ID)
+	if err != nil {
+		t.Fatalf("Reject(): error = %v", err)
+	}
+	if s.balance(account) != 100 {
+		t.Errorf("Reject(): balance = %v, want 100", s.balance(account))
+	}
+	_, err = s.Refund(payment.ID, 1)
+	if err != ErrRefundTooLarge {
+		t.Errorf("Refund() of a rejected payment: error = %v, want %v", err, ErrRefundTooLarge)
+	}
+
+	// refunded in full, Reject only changes the status
+	payment, err = s.Pay(account.ID, 50, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Refund(payment.ID, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payment.ID)
+	if err == nil {
+		payment, err = s.FindPaymentByID(payment.ID)
+	}
+	if err != nil || s.balance(account) != 100 || payment.Status != types.PaymentStatusFail {
+		t.Errorf("Reject() refunded payment: balance = %v, status = %v, error = %v", s.balance(account), payment.Status, err)
+	}
+
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+}
+
+func TestService_Import_refundsOfStoredPayment(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 30, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund, err := s.Refund(payment.ID, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := func(id string, amount int) string {
+		return fmt.Sprintf("%s;%s;%d;%d;2021-03-08T10:00:00Z\n", id, payment.ID, account.ID, amount)
+	}
+	dir := writeTestFiles(t, map[string]string{
+		"refunds.dump": "#wallet-dump;2;refunds\n" + line("r1", 11),
+	})
+	err = s.Import(dir)
+	assertBadLines(t, err, []badLine{{"refunds.dump", 2, ErrRefundTooLarge}})
+
+	// a refund of the file replaces the stored one with its ID
+	dir = writeTestFiles(t, map[string]string{
+		"refunds.dump": "#wallet-dump;2;refunds\n" + line(refund.ID, 5) + line("r1", 25),
+	})
+	err = s.Import(dir)
+	if err != nil {
+		t.Errorf("Import(): error = %v", err)
+	}
+}
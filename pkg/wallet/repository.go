@@ -0,0 +1,146 @@
+package wallet
+
+import (
+	"time"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+// Repository is the storage behind Service. MemoryRepository keeps everything
+// in memory and FileRepository also writes it to dump files; any other store
+// can be plugged in with NewService(WithRepository(...)).
+//
+// Implementations must be safe for concurrent use. Service serializes changes
+// to one account (its balance and its payments) itself, so a repository only
+// has to keep its own data consistent. Records handed out must not change
+// when a record with the same ID is saved later: other accounts may read them
+// while it happens.
+type Repository interface {
+	Accounts() AccountRepository
+	Payments() PaymentRepository
+	Favorites() FavoriteRepository
+	Postings() PostingRepository
+	Deposits() DepositRepository
+	Transfers() TransferRepository
+	Keys() KeyRepository
+	Refunds() RefundRepository
+}
+
+// AccountRepository stores accounts.
+type AccountRepository interface {
+	// NextID reserves an ID for a new account.
+	NextID() (int64, error)
+	// Save inserts the account or replaces the one with the same ID. After an
+	// account is saved NextID never returns its ID or a lower one.
+	Save(account *types.Account) error
+	// ByID returns ErrAccountNotFound if there is no such account.
+	ByID(accountID int64) (*types.Account, error)
+	// ByPhone returns ErrAccountNotFound if there is no such account.
+	ByPhone(phone types.Phone) (*types.Account, error)
+	// All returns the accounts in the order they were saved.
+	All() ([]*types.Account, error)
+	// Delete returns ErrAccountNotFound if there is no such account.
+	Delete(accountID int64) error
+}
+
+// PaymentRepository stores payments.
+type PaymentRepository interface {
+	// Save inserts the payment or replaces the one with the same ID.
+	Save(payment *types.Payment) error
+	// ByID returns ErrPaymentNotFound if there is no such payment.
+	ByID(paymentID string) (*types.Payment, error)
+	// ByAccount returns the payments of the account in the order they were
+	// created, and the ones created at the same time in the order they were
+	// saved. Imports may save older payments after newer ones.
+	ByAccount(accountID int64) ([]*types.Payment, error)
+	// ByAccountSince returns the payments of the account created at since or
+	// later, in the order of ByAccount.
+	ByAccountSince(accountID int64, since time.Time) ([]*types.Payment, error)
+	// All returns the payments in the order they were saved.
+	All() ([]*types.Payment, error)
+	// Delete returns ErrPaymentNotFound if there is no such payment.
+	Delete(paymentID string) error
+}
+
+// FavoriteRepository stores favorites.
+type FavoriteRepository interface {
+	// Save inserts the favorite or replaces the one with the same ID.
+	Save(favorite *types.Favorite) error
+	// ByID returns ErrFavoriteNotFound if there is no such favorite.
+	ByID(favoriteID string) (*types.Favorite, error)
+	// All returns the favorites in the order they were saved.
+	All() ([]*types.Favorite, error)
+	// Delete returns ErrFavoriteNotFound if there is no such favorite.
+	Delete(favoriteID string) error
+}
+
+// PostingRepository stores the ledger.
+type PostingRepository interface {
+	// Save inserts the posting or replaces the one with the same ID.
+	Save(posting *types.Posting) error
+	// ByID returns ErrPostingNotFound if there is no such posting.
+	ByID(postingID string) (*types.Posting, error)
+	// ByAccount returns the postings debiting or crediting the ledger account
+	// in the order they were saved.
+	ByAccount(accountID int64) ([]*types.Posting, error)
+	// All returns the postings in the order they were saved.
+	All() ([]*types.Posting, error)
+	// Delete returns ErrPostingNotFound if there is no such posting.
+	Delete(postingID string) error
+}
+
+// DepositRepository stores deposits.
+type DepositRepository interface {
+	// Save inserts the deposit or replaces the one with the same ID.
+	Save(deposit *types.Deposit) error
+	// ByID returns ErrDepositNotFound if there is no such deposit.
+	ByID(depositID string) (*types.Deposit, error)
+	// ByAccount returns the deposits of the account in the order they were saved.
+	ByAccount(accountID int64) ([]*types.Deposit, error)
+	// All returns the deposits in the order they were saved.
+	All() ([]*types.Deposit, error)
+	// Delete returns ErrDepositNotFound if there is no such deposit.
+	Delete(depositID string) error
+}
+
+// TransferRepository stores transfers.
+type TransferRepository interface {
+	// Save inserts the transfer or replaces the one with the same ID.
+	Save(transfer *types.Transfer) error
+	// ByID returns ErrTransferNotFound if there is no such transfer.
+	ByID(transferID string) (*types.Transfer, error)
+	// ByAccount returns the transfers from or to the account in the order
+	// they were saved.
+	ByAccount(accountID int64) ([]*types.Transfer, error)
+	// All returns the transfers in the order they were saved.
+	All() ([]*types.Transfer, error)
+	// Delete returns ErrTransferNotFound if there is no such transfer.
+	Delete(transferID string) error
+}
+
+// KeyRepository stores idempotency keys, which are unique per account.
+type KeyRepository interface {
+	// Save inserts the key or replaces the one of the same account with the
+	// same Key.
+	Save(key *types.IdempotencyKey) error
+	// ByKey returns ErrKeyNotFound if the account has no such key.
+	ByKey(accountID int64, key string) (*types.IdempotencyKey, error)
+	// All returns the keys in the order they were saved.
+	All() ([]*types.IdempotencyKey, error)
+	// Delete returns ErrKeyNotFound if the account has no such key.
+	Delete(accountID int64, key string) error
+}
+
+// RefundRepository stores refunds.
+type RefundRepository interface {
+	// Save inserts the refund or replaces the one with the same ID.
+	Save(refund *types.Refund) error
+	// ByID returns ErrRefundNotFound if there is no such refund.
+	ByID(refundID string) (*types.Refund, error)
+	// ByPayment returns the refunds of the payment in the order they were saved.
+	ByPayment(paymentID string) ([]*types.Refund, error)
+	// All returns the refunds in the order they were saved.
+	All() ([]*types.Refund, error)
+	// Delete returns ErrRefundNotFound if there is no such refund.
+	Delete(refundID string) error
+}
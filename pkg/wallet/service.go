@@ -1,16 +1,19 @@
 package wallet
 
 import (
-	"fmt"
-	"io/ioutil"
-	"strconv"
 	"errors"
+	"fmt"
 	"github.com/Eydzhpee08/wallet/pkg/types"
 	"github.com/google/uuid"
+	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Error string
@@ -28,37 +31,667 @@ var ErrNotEnoughBalance = errors.New("not enough balance")
 var ErrPaymentNotFound = errors.New("payment not found")
 var ErrFavoriteNotFound = errors.New("favorite not found")
 var ErrFileNotFound = errors.New("File Not found")
+var ErrNoJournal = errors.New("service has no journal")
+var ErrPostingNotFound = errors.New("posting not found")
+var ErrDepositNotFound = errors.New("deposit not found")
+var ErrTransferNotFound = errors.New("transfer not found")
+var ErrKeyNotFound = errors.New("idempotency key not found")
+var ErrRefundNotFound = errors.New("refund not found")
+var ErrCurrencyMismatch = errors.New("currencies do not match")
+
+// ErrOverflow is returned when an amount of money, a balance or a sum would
+// not fit in types.Money.
+var ErrOverflow = types.ErrOverflow
+
+// ErrBadPhone is returned for phone numbers types.ParsePhone can not read.
+var ErrBadPhone = types.ErrBadPhone
+
+// DefaultCountryCode is the country code of national phone numbers unless
+// WithCountryCode sets another one.
+const DefaultCountryCode = "992"
+
+// ErrIllegalTransition is matched by every *TransitionError.
+var ErrIllegalTransition = errors.New("illegal payment status transition")
+
+// TransitionError is returned when a payment can not go from its status to
+// the one asked for, e.g. by rejecting a payment twice.
+type TransitionError struct {
+	PaymentID string
+	From      types.PaymentStatus
+	To        types.PaymentStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment %s: can not go from %s to %s", e.PaymentID, e.From, e.To)
+}
 
-//Service -
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// Service - wallet service, safe for concurrent use. The zero Service keeps
+// its data in a MemoryRepository; use NewService to plug in another one.
+//
+// The balance of an account and the statuses of its payments are guarded by
+// the account's own lock (see lockAccount), so payments on different accounts
+// do not serialize. mu is taken for writing by operations that change anything
+// else (registration, imports) and for reading by those that need the rest to
+// stay put. When both are needed, account locks are taken first and mu second.
+//
+// Accounts and payments returned by the service are the stored records: read
+// them only while no operation on the same account runs concurrently.
 type Service struct {
-	nextAccountID int64
-	accounts      []*types.Account
-	payments      []*types.Payment
-	favorites     []*types.Favorite
+	mu         sync.RWMutex
+	repository Repository
+	repoOnce   sync.Once
+
+	locksMu sync.Mutex
+	locks   map[int64]*sync.Mutex
+
+	journal    *Journal
+	journalDir string
+
+	clock func() time.Time
+	// limits are the ones of the accounts without limits of their own
+	limits types.Limits
+	// countryCode is the one of national phone numbers, if not the default
+	countryCode string
+	// idempotencyWindow is how long idempotency keys are kept in mind
+	idempotencyWindow time.Duration
+	// rates converts payments and transfers across currencies
+	rates RateProvider
+	// overdraftCharges works out what overdrawn accounts are charged
+	overdraftCharges OverdraftCharges
+	// fees works out the fees of payments, which go to feeAccount
+	fees       FeeSchedule
+	feeAccount int64
+}
+
+// Option configures a Service created by NewService.
+type Option func(s *Service)
+
+// WithRepository makes the service keep its data in repository.
+func WithRepository(repository Repository) Option {
+	return func(s *Service) {
+		s.repository = repository
+	}
+}
+
+// WithClock makes the service take the time from clock instead of time.Now.
+func WithClock(clock func() time.Time) Option {
+	return func(s *Service) {
+		s.clock = clock
+	}
+}
+
+// WithCountryCode makes the service read phone numbers without a country
+// code as numbers in the country with code, such as "7", instead of
+// DefaultCountryCode.
+func WithCountryCode(code string) Option {
+	return func(s *Service) {
+		s.countryCode = code
+	}
+}
+
+// WithIdempotencyWindow makes idempotency keys expire window after they were
+// first used, instead of DefaultIdempotencyWindow.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(s *Service) {
+		s.idempotencyWindow = window
+	}
+}
+
+// NewService creates a service configured by options.
+func NewService(options ...Option) *Service {
+	s := &Service{}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// repo returns the repository, creating a MemoryRepository for a zero Service.
+func (s *Service) repo() Repository {
+	s.repoOnce.Do(func() {
+		if s.repository == nil {
+			s.repository = NewMemoryRepository()
+		}
+	})
+	return s.repository
+}
+
+// now returns the time to stamp records with, in UTC and without the
+// monotonic reading, so that it survives a round trip through the dumps.
+func (s *Service) now() time.Time {
+	now := time.Now
+	if s.clock != nil {
+		now = s.clock
+	}
+	return now().UTC().Round(0)
+}
+
+// save writes the records of the change to the repository.
+func (s *Service) save(c change) error {
+	for _, account := range c.accounts {
+		err := s.repo().Accounts().Save(account)
+		if err != nil {
+			return err
+		}
+	}
+	for _, payment := range c.payments {
+		err := s.repo().Payments().Save(payment)
+		if err != nil {
+			return err
+		}
+	}
+	for _, favorite := range c.favorites {
+		err := s.repo().Favorites().Save(favorite)
+		if err != nil {
+			return err
+		}
+	}
+	for _, deposit := range c.deposits {
+		err := s.repo().Deposits().Save(deposit)
+		if err != nil {
+			return err
+		}
+	}
+	for _, transfer := range c.transfers {
+		err := s.repo().Transfers().Save(transfer)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range c.keys {
+		err := s.repo().Keys().Save(key)
+		if err != nil {
+			return err
+		}
+	}
+	for _, refund := range c.refunds {
+		err := s.repo().Refunds().Save(refund)
+		if err != nil {
+			return err
+		}
+	}
+	for _, posting := range c.postings {
+		err := s.repo().Postings().Save(posting)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit journals the change, if the service has a journal, and saves it. The
+// caller holds the locks of the records in the change.
+func (s *Service) commit(c change) error {
+	if s.journal != nil {
+		err := s.journal.append(c)
+		if err != nil {
+			return err
+		}
+	}
+	return s.save(c)
 }
 
-//RegisterAccount создаем тут ак
+// commitAll saves the change as a whole: if saving a record fails, the records
+// saved before it are put back as they were. The change is journaled once it
+// is saved, and undone as well if that fails. Callers hold lockAll(true).
+func (s *Service) commitAll(c change) error {
+	undo, err := s.backup(c)
+	if err != nil {
+		return err
+	}
+
+	err = s.save(c)
+	if err == nil && s.journal != nil {
+		err = s.journal.append(c)
+	}
+	if err != nil {
+		rollbackErr := s.rollback(undo)
+		if rollbackErr != nil {
+			log.Print(rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// undo holds what is needed to put the records of a change back.
+type undo struct {
+	accounts       []types.Account
+	payments       []types.Payment
+	favorites      []types.Favorite
+	postings       []types.Posting
+	deposits       []types.Deposit
+	transfers      []types.Transfer
+	keys           []types.IdempotencyKey
+	refunds        []types.Refund
+	newAccountIDs  []int64
+	newPaymentIDs  []string
+	newFavoriteIDs []string
+	newPostingIDs  []string
+	newDepositIDs  []string
+	newTransferIDs []string
+	newKeyIDs      []keyID
+	newRefundIDs   []string
+}
+
+// backup copies the stored versions of the records the change is about to
+// overwrite and notes the ones it adds.
+func (s *Service) backup(c change) (undo, error) {
+	u := undo{}
+	for _, account := range c.accounts {
+		old, err := s.repo().Accounts().ByID(account.ID)
+		switch err {
+		case nil:
+			u.accounts = append(u.accounts, *old)
+		case ErrAccountNotFound:
+			u.newAccountIDs = append(u.newAccountIDs, account.ID)
+		default:
+			return undo{}, err
+		}
+	}
+	for _, payment := range c.payments {
+		old, err := s.repo().Payments().ByID(payment.ID)
+		switch err {
+		case nil:
+			u.payments = append(u.payments, *old)
+		case ErrPaymentNotFound:
+			u.newPaymentIDs = append(u.newPaymentIDs, payment.ID)
+		default:
+			return undo{}, err
+		}
+	}
+	for _, favorite := range c.favorites {
+		old, err := s.repo().Favorites().ByID(favorite.ID)
+		switch err {
+		case nil:
+			u.favorites = append(u.favorites, *old)
+		case ErrFavoriteNotFound:
+			u.newFavoriteIDs = append(u.newFavoriteIDs, favorite.ID)
+		default:
+			return undo{}, err
+		}
+	}
+	for _, posting := range c.postings {
+		old, err := s.repo().Postings().ByID(posting.ID)
+		switch err {
+		case nil:
+			u.postings = append(u.postings, *old)
+		case ErrPostingNotFound:
+			u.newPostingIDs = append(u.newPostingIDs, posting.ID)
+		default:
+			return undo{}, err
+		}
+	}
+	for _, deposit := range c.deposits {
+		old, err := s.repo().Deposits().ByID(deposit.ID)
+		switch err {
+		case nil:
+			u.deposits = append(u.deposits, *old)
+		case ErrDepositNotFound:
+			u.newDepositIDs = append(u.newDepositIDs, deposit.ID)
+		default:
+			return undo{}, err
+		}
+	}
+	for _, transfer := range c.transfers {
+		old, err := s.repo().Transfers().ByID(transfer.ID)
+		switch err {
+		case nil:
+			u.transfers = append(u.transfers, *old)
+		case ErrTransferNotFound:
+			u.newTransferIDs = append(u.newTransferIDs, transfer.ID)
+		default:
+			return undo{}, err
+		}
+	}
+	for _, key := range c.keys {
+		old, err := s.repo().Keys().ByKey(key.AccountID, key.Key)
+		switch err {
+		case nil:
+			u.keys = append(u.keys, *old)
+		case ErrKeyNotFound:
+			u.newKeyIDs = append(u.newKeyIDs, keyID{key.AccountID, key.Key})
+		default:
+			return undo{}, err
+		}
+	}
+	for _, refund := range c.refunds {
+		old, err := s.repo().Refunds().ByID(refund.ID)
+		switch err {
+		case nil:
+			u.refunds = append(u.refunds, *old)
+		case ErrRefundNotFound:
+			u.newRefundIDs = append(u.newRefundIDs, refund.ID)
+		default:
+			return undo{}, err
+		}
+	}
+	return u, nil
+}
+
+// rollback puts back the records saved by backup and deletes the added ones.
+func (s *Service) rollback(u undo) error {
+	for _, id := range u.newPostingIDs {
+		err := s.repo().Postings().Delete(id)
+		if err != nil && err != ErrPostingNotFound {
+			return err
+		}
+	}
+	for _, id := range u.newRefundIDs {
+		err := s.repo().Refunds().Delete(id)
+		if err != nil && err != ErrRefundNotFound {
+			return err
+		}
+	}
+	for _, id := range u.newKeyIDs {
+		err := s.repo().Keys().Delete(id.accountID, id.key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+	}
+	for _, id := range u.newTransferIDs {
+		err := s.repo().Transfers().Delete(id)
+		if err != nil && err != ErrTransferNotFound {
+			return err
+		}
+	}
+	for _, id := range u.newDepositIDs {
+		err := s.repo().Deposits().Delete(id)
+		if err != nil && err != ErrDepositNotFound {
+			return err
+		}
+	}
+	for _, id := range u.newFavoriteIDs {
+		err := s.repo().Favorites().Delete(id)
+		if err != nil && err != ErrFavoriteNotFound {
+			return err
+		}
+	}
+	for _, id := range u.newPaymentIDs {
+		err := s.repo().Payments().Delete(id)
+		if err != nil && err != ErrPaymentNotFound {
+			return err
+		}
+	}
+	for _, id := range u.newAccountIDs {
+		err := s.repo().Accounts().Delete(id)
+		if err != nil && err != ErrAccountNotFound {
+			return err
+		}
+	}
+
+	c := change{}
+	for i := range u.accounts {
+		c.accounts = append(c.accounts, &u.accounts[i])
+	}
+	for i := range u.payments {
+		c.payments = append(c.payments, &u.payments[i])
+	}
+	for i := range u.favorites {
+		c.favorites = append(c.favorites, &u.favorites[i])
+	}
+	for i := range u.postings {
+		c.postings = append(c.postings, &u.postings[i])
+	}
+	for i := range u.deposits {
+		c.deposits = append(c.deposits, &u.deposits[i])
+	}
+	for i := range u.transfers {
+		c.transfers = append(c.transfers, &u.transfers[i])
+	}
+	for i := range u.keys {
+		c.keys = append(c.keys, &u.keys[i])
+	}
+	for i := range u.refunds {
+		c.refunds = append(c.refunds, &u.refunds[i])
+	}
+	return s.save(c)
+}
+
+// RegisterAccount создаем тут ак
+//
+// The phone is kept in E.164 form, read by types.ParsePhone with the country
+// code of the service, and may belong to one account only however it is
+// written. A malformed one gives ErrBadPhone.
 func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
+	return s.RegisterAccountIn(phone, types.DefaultCurrency)
+}
+
+// RegisterAccountIn is RegisterAccount for an account in currency, which must
+// be one of the predefined ones. RegisterAccount opens accounts in
+// types.DefaultCurrency.
+func (s *Service) RegisterAccountIn(phone types.Phone, currency types.Currency) (*types.Account, error) {
+	if !currency.Known() {
+		return nil, ErrUnknownCurrency
+	}
+	phone, err := s.parsePhone(phone)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, account := range s.accounts {
-		if account.Phone == phone {
-			return nil, ErrPhoneNumberRegistred
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.registerAccountLocked(phone, currency)
+}
+
+// parsePhone returns phone in E.164 form, national numbers being in the
+// country of the service.
+func (s *Service) parsePhone(phone types.Phone) (types.Phone, error) {
+	return types.ParsePhone(string(phone), s.phoneCountryCode())
+}
+
+// phoneCountryCode returns the country code of national phone numbers.
+func (s *Service) phoneCountryCode() string {
+	if s.countryCode == "" {
+		return DefaultCountryCode
+	}
+	return s.countryCode
+}
+
+// loadAccounts puts the phones of accounts loaded from a snapshot or the
+// journal in E.164 form, as files written before phones were normalized
+// may hold them otherwise, and checks that no other stored account has them.
+func (s *Service) loadAccounts(accounts []*types.Account) error {
+	err := normalizePhones(accounts, s.phoneCountryCode())
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		owner, err := s.repo().Accounts().ByPhone(account.Phone)
+		if err != nil && err != ErrAccountNotFound {
+			return err
+		}
+		if err == nil && owner.ID != account.ID {
+			return fmt.Errorf("account %v: %w: %v", account.ID, ErrPhoneNumberRegistred, account.Phone)
 		}
 	}
+	return nil
+}
+
+// normalizePhones puts the phones of loaded accounts in E.164 form, national
+// numbers being in the country with countryCode. Two accounts with the same
+// phone give ErrPhoneNumberRegistred; a record repeated with the same ID
+// does not.
+func normalizePhones(accounts []*types.Account, countryCode string) error {
+	owners := map[types.Phone]int64{}
+	for _, account := range accounts {
+		phone, err := types.ParsePhone(string(account.Phone), countryCode)
+		if err != nil {
+			return fmt.Errorf("account %v: %w %q", account.ID, err, account.Phone)
+		}
+		owner, taken := owners[phone]
+		if taken && owner != account.ID {
+			return fmt.Errorf("account %v: %w: %v", account.ID, ErrPhoneNumberRegistred, phone)
+		}
+		owners[phone] = account.ID
+		account.Phone = phone
+	}
+	return nil
+}
+
+// registerAccountLocked is RegisterAccount for callers already holding s.mu
+// and a phone parsed by parsePhone.
+func (s *Service) registerAccountLocked(phone types.Phone, currency types.Currency) (*types.Account, error) {
+	accounts := s.repo().Accounts()
+
+	_, err := accounts.ByPhone(phone)
+	if err == nil {
+		return nil, ErrPhoneNumberRegistred
+	}
+	if err != ErrAccountNotFound {
+		return nil, err
+	}
 
-	s.nextAccountID++
+	id, err := accounts.NextID()
+	if err != nil {
+		return nil, err
+	}
 	account := &types.Account{
-		ID:      s.nextAccountID,
-		Phone:   phone,
-		Balance: 0,
+		ID:       id,
+		Phone:    phone,
+		Balance:  0,
+		Currency: currency,
+		Status:   types.AccountStatusActive,
+		Created:  s.now(),
 	}
 
-	s.accounts = append(s.accounts, account)
+	err = s.commit(change{op: "register", accounts: []*types.Account{account}})
+	if err != nil {
+		return nil, err
+	}
 
 	return account, nil
 
 }
 
+// accountLock returns the lock of the account, creating it on first use.
+func (s *Service) accountLock(accountID int64) *sync.Mutex {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	if s.locks == nil {
+		s.locks = make(map[int64]*sync.Mutex)
+	}
+	lock, ok := s.locks[accountID]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[accountID] = lock
+	}
+	return lock
+}
+
+// lockAccount finds the account and locks it. The returned func releases the lock.
+func (s *Service) lockAccount(accountID int64) (*types.Account, func(), error) {
+	accounts := s.repo().Accounts()
+
+	_, err := accounts.ByID(accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lock := s.accountLock(accountID)
+	lock.Lock()
+
+	// read again under the lock, a repository may hand out copies
+	account, err := accounts.ByID(accountID)
+	if err != nil {
+		lock.Unlock()
+		return nil, nil, err
+	}
+	return account, lock.Unlock, nil
+}
+
+// lockAccounts is lockAccount for several accounts, locked in ID order so
+// that two callers locking the same accounts do not deadlock. The accounts are
+// returned in the order of ids.
+func (s *Service) lockAccounts(ids ...int64) ([]*types.Account, func(), error) {
+	sorted := append([]int64(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	locked := map[int64]*types.Account{}
+	unlocks := []func(){}
+	unlock := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, id := range sorted {
+		if locked[id] != nil {
+			continue
+		}
+		account, unlockAccount, err := s.lockAccount(id)
+		if err != nil {
+			unlock()
+			return nil, nil, err
+		}
+		locked[id] = account
+		unlocks = append(unlocks, unlockAccount)
+	}
+
+	accounts := make([]*types.Account, len(ids))
+	for i, id := range ids {
+		accounts[i] = locked[id]
+	}
+	return accounts, unlock, nil
+}
+
+// lockAll locks every account in ID order and then takes s.mu (for writing if
+// exclusive is set), giving a consistent view of the whole wallet. The
+// returned func releases everything.
+func (s *Service) lockAll(exclusive bool) (func(), error) {
+	for {
+		accounts, err := s.repo().Accounts().All()
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]int64, 0, len(accounts))
+		for _, account := range accounts {
+			ids = append(ids, account.ID)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		locks := make([]*sync.Mutex, 0, len(ids))
+		for _, id := range ids {
+			locks = append(locks, s.accountLock(id))
+		}
+
+		for _, lock := range locks {
+			lock.Lock()
+		}
+		if exclusive {
+			s.mu.Lock()
+		} else {
+			s.mu.RLock()
+		}
+
+		unlock := func() {
+			if exclusive {
+				s.mu.Unlock()
+			} else {
+				s.mu.RUnlock()
+			}
+			for i := len(locks) - 1; i >= 0; i-- {
+				locks[i].Unlock()
+			}
+		}
+
+		// an account registered in between is not locked yet, so try again
+		current, err := s.repo().Accounts().All()
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		if len(current) == len(locks) {
+			return unlock, nil
+		}
+		unlock()
+	}
+}
+
 // так, он находит по ID
 // у нас есть слайст структур
 // нам нужен отдельная структура, чтобы туда запихнуть данные
@@ -70,136 +703,391 @@ func (s *Service) RegisterAccount(phone types.Phone) (*types.Account, error) {
 //
 
 func (s *Service) Deposit(accountID int64, amount types.Money) error {
-	if amount <= 0 {
-		return ErrAmountMustBePositive
+	_, err := s.DepositFrom(accountID, amount, "", "")
+	return err
+}
 
+// DepositFrom is Deposit noting where the money came from: source names it
+// (a card, a terminal and so on) and reference is the ID it was given there.
+// Both may be empty. It returns the recorded deposit.
+func (s *Service) DepositFrom(accountID int64, amount types.Money, source string, reference string) (*types.Deposit, error) {
+	return s.deposit(accountID, amount, source, reference, request{})
+}
+
+// deposit is DepositFrom for a request that may carry an idempotency key.
+func (s *Service) deposit(accountID int64, amount types.Money, source string, reference string, r request) (*types.Deposit, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
 	}
 
-	var account *types.Account
+	account, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
+	depositID, err := s.recall(accountID, r)
+	if err != nil {
+		return nil, err
+	}
+	if depositID != "" {
+		return s.FindDepositByID(depositID)
+	}
+	err = checkActive(account)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-		}
+	deposit := &types.Deposit{
+		ID:        uuid.New().String(),
+		AccountID: accountID,
+		Amount:    amount,
+		Source:    source,
+		Reference: reference,
+		Created:   s.now(),
+	}
 
+	updated := *account
+	updated.Balance, err = account.Balance.Add(amount)
+	if err != nil {
+		return nil, err
 	}
-	if account == nil {
-		return ErrAccountNotFound
+	err = s.checkBalanceLimit(account, updated.Balance)
+	if err != nil {
+		return nil, err
 	}
-	account.Balance += amount
 
-	return nil
+	err = s.commit(change{
+		op:       "deposit",
+		accounts: []*types.Account{&updated},
+		deposits: []*types.Deposit{deposit},
+		postings: []*types.Posting{s.newPosting(LedgerDeposits, accountID, amount, deposit.ID)},
+		keys:     s.remember(accountID, r, deposit.ID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deposit, nil
 }
 
 func (s *Service) Pay(accountID int64, amount types.Money, category types.PaymentCategory) (*types.Payment, error) {
+	return s.pay(accountID, amount, "", category, request{})
+}
+
+// PayIn is Pay with the currency of amount given. An amount in another
+// currency than the one of the account is converted at the rate of the
+// service's RateProvider, and the payment keeps what was paid and the rate.
+// Without a RateProvider it gives ErrCurrencyMismatch.
+func (s *Service) PayIn(accountID int64, amount types.Money, currency types.Currency, category types.PaymentCategory) (*types.Payment, error) {
+	return s.pay(accountID, amount, currency, category, request{})
+}
+
+// pay is PayIn for a request that may carry an idempotency key. An empty
+// currency is the one of the account.
+func (s *Service) pay(accountID int64, amount types.Money, currency types.Currency, category types.PaymentCategory, r request) (*types.Payment, error) {
 	if amount <= 0 {
 		return nil, ErrAmountMustBePositive
 	}
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-			break
+
+	account, feeAccount, unlock, err := s.lockPayer(accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	paymentID, err := s.recall(accountID, r)
+	if err != nil {
+		return nil, err
+	}
+	if paymentID != "" {
+		return s.FindPaymentByID(paymentID)
+	}
+	err = checkActive(account)
+	if err != nil {
+		return nil, err
+	}
+
+	original, originalCurrency, rate := types.Money(0), types.Currency(""), types.Rate("")
+	if currency != "" && currency != account.Currency {
+		rate, err = s.rate(currency, account.Currency)
+		if err != nil {
+			return nil, err
+		}
+		original, originalCurrency = amount, currency
+		amount, err = convert(original, originalCurrency, account.Currency, rate)
+		if err != nil {
+			return nil, err
+		}
+		if amount <= 0 {
+			return nil, ErrAmountMustBePositive
 		}
+	}
 
+	err = s.checkSpendingLimits(account, amount, category)
+	if err != nil {
+		return nil, err
 	}
-	
-	if account == nil {
-		return nil, ErrAccountNotFound
+
+	fees, feesTotal, err := s.chargeFees(account, feeAccount, amount, category)
+	if err != nil {
+		return nil, err
+	}
+	total, err := amount.Add(feesTotal)
+	if err != nil {
+		return nil, err
 	}
 
-	if account.Balance < amount {
+	overdraftFee, err := s.overdraftFee(account, total)
+	if err != nil {
+		return nil, err
+	}
+	need, err := total.Add(overdraftFee)
+	if err != nil {
+		return nil, err
+	}
+	funds, err := available(account)
+	if err != nil {
+		return nil, err
+	}
+	if funds < need {
 		return nil, ErrNotEnoughBalance
 
 	}
-	account.Balance -= amount
 
-	paymentID := uuid.New().String()
+	paymentID = uuid.New().String()
+	now := s.now()
 	payment := &types.Payment{
 		ID:        paymentID,
-		AccountID: accountID, 
-		Amount:    amount,    
+		AccountID: accountID,
+		Amount:    amount,
 		Category:  category,
 		Status:    types.PaymentStatusInProgress,
+		Created:   now,
+		Updated:   now,
+		Currency:  account.Currency,
+
+		OriginalAmount:   original,
+		OriginalCurrency: originalCurrency,
+		Rate:             rate,
+
+		Fees: fees,
+	}
+
+	updated := *account
+	updated.Balance, err = account.Balance.Sub(total)
+	if err != nil {
+		return nil, err
+	}
+	accounts := []*types.Account{&updated}
+	postings := []*types.Posting{s.newPosting(accountID, LedgerPayments, amount, paymentID)}
+	if feesTotal > 0 {
+		payment.FeeAccount = s.feeAccountID()
+		postings = append(postings, s.newPosting(accountID, payment.FeeAccount, feesTotal, paymentID))
+		if feeAccount != nil {
+			credited := *feeAccount
+			credited.Balance, err = feeAccount.Balance.Add(feesTotal)
+			if err != nil {
+				return nil, err
+			}
+			err = s.checkBalanceLimit(feeAccount, credited.Balance)
+			if err != nil {
+				return nil, err
+			}
+			accounts = append(accounts, &credited)
+		}
+	}
+	charges, err := s.chargeOverdraft(&updated, overdraftFee, paymentID)
+	if err != nil {
+		return nil, err
 	}
 
-	s.payments = append(s.payments, payment)
+	err = s.commit(change{
+		op:       "pay",
+		accounts: accounts,
+		payments: []*types.Payment{payment},
+		postings: append(postings, charges...),
+		keys:     s.remember(accountID, r, paymentID),
+	})
+	if err != nil {
+		return nil, err
+	}
 	return payment, nil
 
 }
 
 func (s *Service) FindAccountByID(accountID int64) (*types.Account, error) {
-	var account *types.Account
-	for _, acc := range s.accounts {
-		if acc.ID == accountID {
-			account = acc
-		}
+	return s.repo().Accounts().ByID(accountID)
+}
+
+func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
+	return s.repo().Payments().ByID(paymentID)
+}
+
+func (s *Service) FindDepositByID(depositID string) (*types.Deposit, error) {
+	return s.repo().Deposits().ByID(depositID)
+}
+
+// lockPayment finds the payment and locks its account, like lockAccount, and
+// the account its fees went to if that is an account of the wallet. The
+// payment is read again under the lock, as its status may have changed.
+func (s *Service) lockPayment(paymentID string) (*types.Payment, *types.Account, func(), error) {
+	found, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	if account == nil {
-		return nil, ErrAccountNotFound
+
+	ids := []int64{found.AccountID}
+	if found.FeeAccount > 0 && found.FeeAccount != found.AccountID {
+		ids = append(ids, found.FeeAccount)
 	}
-	return account, nil
+	accounts, unlock, err := s.lockAccounts(ids...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	account := accounts[0]
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		unlock()
+		return nil, nil, nil, err
+	}
+	return payment, account, unlock, nil
+}
 
+// transition returns a copy of the payment in status next, or a
+// *TransitionError if the payment can not go there.
+func (s *Service) transition(payment *types.Payment, next types.PaymentStatus) (*types.Payment, error) {
+	if !payment.Status.CanBecome(next) {
+		return nil, &TransitionError{PaymentID: payment.ID, From: payment.Status, To: next}
+	}
+	updated := *payment
+	updated.Status = next
+	updated.Updated = s.now()
+	return &updated, nil
 }
 
-func (s *Service) FindPaymentByID(paymentID string) (*types.Payment, error) {
-	var payment *types.Payment
-	for _, pay := range s.payments {
-		if pay.ID == paymentID {
-			payment = pay
-			break
-		}
+// Complete marks a payment in progress as done. The money has left the
+// account already, so only the status changes.
+func (s *Service) Complete(paymentID string) error {
+	stored, _, unlock, err := s.lockPayment(paymentID)
+	if err != nil {
+		return err
 	}
-	if payment == nil {
-		return nil, ErrPaymentNotFound
+	defer unlock()
+
+	payment, err := s.transition(stored, types.PaymentStatusOk)
+	if err != nil {
+		return err
 	}
-	return payment, nil
+	return s.commit(change{op: "complete", payments: []*types.Payment{payment}})
 }
 
-
+// Reject fails a payment in progress and gives the money back, less what its
+// refunds gave back already, along with its fees and the overdraft fee it
+// was charged. A payment made in another
+// currency is given back at the rate it was made at, so the account gets back
+// what it paid whatever the rate is now. A payment already completed or
+// rejected gives a *TransitionError.
 func (s *Service) Reject(paymentID string) error {
-	var targetPayment *types.Payment
+	stored, targetAccount, unlock, err := s.lockPayment(paymentID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	
-	for _, payment := range s.payments {
-		if payment.ID == paymentID {
-			targetPayment = payment
-			break
+	left, err := s.refundable(stored)
+	if err != nil {
+		return err
+	}
+	payment, err := s.transition(stored, types.PaymentStatusFail)
+	if err != nil {
+		return err
+	}
+
+	// only what the refunds of the payment have not given back yet
+	c := change{op: "reject", payments: []*types.Payment{payment}}
+	account := *targetAccount
+	if left > 0 {
+		account.Balance, err = account.Balance.Add(left)
+		if err != nil {
+			return err
+		}
+		c.postings = append(c.postings, s.newPosting(LedgerPayments, account.ID, left, payment.ID))
+	}
+	fees, err := feesTotal(payment)
+	if err != nil {
+		return err
+	}
+	if fees > 0 {
+		account.Balance, err = account.Balance.Add(fees)
+		if err != nil {
+			return err
+		}
+		c.postings = append(c.postings, s.newPosting(payment.FeeAccount, account.ID, fees, payment.ID))
+		if payment.FeeAccount > 0 {
+			feeAccount, err := s.repo().Accounts().ByID(payment.FeeAccount)
+			if err != nil {
+				return err
+			}
+			err = checkNotClosed(feeAccount)
+			if err != nil {
+				return err
+			}
+			debited := *feeAccount
+			debited.Balance, err = feeAccount.Balance.Sub(fees)
+			if err != nil {
+				return err
+			}
+			c.accounts = append(c.accounts, &debited)
 		}
-
 	}
-	if targetPayment == nil {
-		return ErrPaymentNotFound
+	charges, err := s.refundOverdraft(&account, payment.ID)
+	if err != nil {
+		return err
 	}
-	var targetAccount *types.Account
-
-	for _, account := range s.accounts {
-		
-		if account.ID == targetPayment.AccountID {
-			targetAccount = account
-			break
+	c.postings = append(c.postings, charges...)
+	if len(c.postings) > 0 {
+		err = checkNotClosed(targetAccount)
+		if err != nil {
+			return err
 		}
+		err = s.checkBalanceLimit(targetAccount, account.Balance)
+		if err != nil {
+			return err
+		}
+		c.accounts = append([]*types.Account{&account}, c.accounts...)
 	}
-	if targetAccount == nil {
-		return ErrAccountNotFound
-	}
-	targetPayment.Status = types.PaymentStatusFail
-	targetAccount.Balance += targetPayment.Amount
-
-	return nil
-
+	return s.commit(c)
 }
 
+// findPaymentCopy returns a copy of the payment, safe to read without locks.
+func (s *Service) findPaymentCopy(paymentID string) (types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
+	payment, err := s.FindPaymentByID(paymentID)
+	if err != nil {
+		return types.Payment{}, err
+	}
+	return *payment, nil
+}
 
 func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
-	pay, err := s.FindPaymentByID(paymentID)
+	return s.repeat(paymentID, request{})
+}
+
+// repeat is Repeat for a request that may carry an idempotency key.
+func (s *Service) repeat(paymentID string, r request) (*types.Payment, error) {
+	pay, err := s.findPaymentCopy(paymentID)
 	if err != nil {
 		return nil, err
 	}
 
-	payment, err := s.Pay(pay.AccountID, pay.Amount, pay.Category)
+	// a payment made in another currency is paid again in it, at today's rate
+	amount, currency := pay.Amount, pay.Currency
+	if pay.OriginalCurrency != "" {
+		amount, currency = pay.OriginalAmount, pay.OriginalCurrency
+	}
+	payment, err := s.pay(pay.AccountID, amount, currency, pay.Category, r)
 	if err != nil {
 		return nil, err
 	}
@@ -207,12 +1095,9 @@ func (s *Service) Repeat(paymentID string) (*types.Payment, error) {
 	return payment, nil
 }
 
-
-
-
-// он создает FavoritePayment 
+// он создает FavoritePayment
 func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorite, error) {
-	payment, err := s.FindPaymentByID(paymentID)
+	payment, err := s.findPaymentCopy(paymentID)
 
 	if err != nil {
 		return nil, err
@@ -227,30 +1112,31 @@ func (s *Service) FavoritePayment(paymentID string, name string) (*types.Favorit
 		Category:  payment.Category,
 	}
 
-	s.favorites = append(s.favorites, newFavorite)
+	s.mu.RLock()
+	err = s.commit(change{op: "favorite", favorites: []*types.Favorite{newFavorite}})
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
 	return newFavorite, nil
 }
 
-
 func (s *Service) FindFavoriteByID(favoriteID string) (*types.Favorite, error) {
-	for _, favorite := range s.favorites {
-		if favorite.ID == favoriteID {
-			return favorite, nil
-		}
-	}
-	return nil, ErrFavoriteNotFound
+	return s.repo().Favorites().ByID(favoriteID)
 }
 
-
-
-//PayFromFavorite для совершения платежа в Избранное
+// PayFromFavorite для совершения платежа в Избранное
 func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
+	s.mu.RLock()
 	favorite, err := s.FindFavoriteByID(favoriteID)
 	if err != nil {
+		s.mu.RUnlock()
 		return nil, err
 	}
+	accountID, amount, category := favorite.AccountID, favorite.Amount, favorite.Category
+	s.mu.RUnlock()
 
-	payment, err := s.Pay(favorite.AccountID, favorite.Amount, favorite.Category)
+	payment, err := s.Pay(accountID, amount, category)
 	if err != nil {
 		return nil, err
 	}
@@ -258,169 +1144,263 @@ func (s *Service) PayFromFavorite(favoriteID string) (*types.Payment, error) {
 	return payment, nil
 }
 
-//ExportToFile - для импорта данных
-func (s *Service) ExportToFile(path string) error  {
-	file, err := os.Create(path)
+// ExportToFile - для импорта данных
+//
+// The accounts are written whole, as the accounts.dump of Export, so that
+// ImportFromFile gives them back as they were. The file is replaced
+// atomically.
+func (s *Service) ExportToFile(path string) error {
+	unlock, err := s.lockAll(false)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	defer func() {
-		err := file.Close()
-		if err != nil {
-			log.Print(err)
-		}
+	defer unlock()
 
-		
-	}()
-	str := ""
-	for _, account := range s.accounts {
-		str += strconv.Itoa(int(account.ID)) + ";"
-		str += string(account.Phone) + ";"
-		str += strconv.Itoa(int(account.Balance)) + "|"
+	accounts, err := s.repo().Accounts().All()
+	if err != nil {
+		log.Print(err)
+		return err
 	}
 
-	_, err = file.Write([]byte(str))
+	err = writeFileAtomic(path, encodeAccounts(accounts))
 	if err != nil {
-		log.Println(err)
+		log.Print(err)
 		return err
 	}
 
 	return nil
 }
 
-
+// ImportFromFile merges the accounts written by ExportToFile into the
+// service: an account with a known ID is replaced, any other is added with
+// its ID, and later registrations get IDs after it. A phone may belong to one
+// account only. Nothing changes if any record is bad; bad records are
+// reported in an *ImportError.
+//
+// Files written before ExportToFile wrote whole accounts hold
+// "id;phone;balance" records separated by "|", numbered from 1 in the report
+// as the file has no lines. Such a record only changes the phone and the
+// balance of a known account and gives ErrCurrencyMismatch if it names
+// another currency than the account's.
 func (s *Service) ImportFromFile(path string) error {
-
-
 	byteData, err := ioutil.ReadFile(path)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	
-	// обязательно ли преобразовать слайс байтов преобразовывать в стринг?
-	data := string(byteData)
-	fmt.Println(data)
-
-
-	splitSlice := strings.Split(data, "|")
-	// здесь он должен показать  весь список, тажкже убрать |
-	fmt.Println(splitSlice)
-	// тут он покажет 2 номер
-	fmt.Println(splitSlice[1])
-	// тут 1
-	fmt.Println(splitSlice[0])
-	//splitSlice = strings.Split(data, ";")
-	for _, split := range splitSlice {
-		if split != "" {
-			datas := strings.Split(split, ";")
-
-			id, err := strconv.Atoi(datas[0])
-			if err != nil {
-				log.Println(err)
-				return err
-			}
-			fmt.Println(datas[0])
-			balance, err := strconv.Atoi(datas[2])
-			fmt.Println(datas[2])
-			if err != nil {
-				log.Println(err)
-				return err
-			}
 
-			newAccount := &types.Account{
-				ID:      int64(id),
-				Phone:   types.Phone(datas[1]),
-				Balance: types.Money(balance),
-			}
+	unlock, err := s.lockAll(true)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer unlock()
 
-			s.accounts = append(s.accounts, newAccount)
-		}
+	c := change{op: "import"}
+	report := &ImportError{}
+	data := string(byteData)
+	if strings.HasPrefix(data, dumpMagic) {
+		o := newOrigin(path, report)
+		err = s.actionByAccounts(decodeAccounts(o, data), o, MergeOverwrite, &c)
+	} else {
+		err = s.importLegacyAccounts(path, data, report, &c)
+	}
+	if err != nil {
+		return err
 	}
 
-	return nil
+	err = report.err()
+	if err != nil {
+		return err
+	}
+	err = s.balanceLedger(&c)
+	if err != nil {
+		return err
+	}
+	return s.commitAll(c)
 }
 
-// у нас же данные есть ведь? - например RegisterAc - который в памяти хранится
-// если она пустая то дай ошибку
-// нужна отдельная функция для создания файлов, чтобы 3 раза не писать одно и тоже
+// importLegacyAccounts adds the accounts of a file in the old format of
+// ExportToFile to the change made by ImportFromFile.
+func (s *Service) importLegacyAccounts(path string, data string, report *ImportError, c *change) error {
+	// телефоны и ID, уже занятые записями этого файла
+	phones := map[types.Phone]int64{}
+	ids := map[int64]bool{}
+
+	record := 0
+	for _, split := range strings.Split(data, "|") {
+		split = strings.TrimSpace(split)
+		if split == "" {
+			continue
+		}
+		record++
 
-func (s *Service) Export(dir string) error {
-		// внутри него данные
-		// будет тру
-	if s.accounts != nil{
-		acc := ""
-		for _, account := range s.accounts{
-			acc += strconv.Itoa(int(account.ID)) + ";"
-			acc +=string(account.Phone) + ";"
-			acc += strconv.Itoa(int(account.Balance)) +";"
-			acc += string('\n')
-		}
-		err := WriteToFile(dir +"/accounts.dump", acc)
+		fields := strings.Split(split, ";")
+		account, err := accountFromFields(fields)
+		if err == nil {
+			account.Phone, err = s.parsePhone(account.Phone)
+		}
 		if err != nil {
-			log.Print(err)
-			return err
+			report.add(path, record, err)
+			continue
 		}
-	}
-	if  s.payments != nil{
-		pay := ""
-
-		for _, payment := range s.payments {
+		if ids[account.ID] {
+			report.add(path, record, fmt.Errorf("%w: %v", ErrDuplicateRecord, account.ID))
+			continue
+		}
+		ids[account.ID] = true
 
-			pay += 	payment.ID + ";"
-			pay +=  strconv.Itoa(int(payment.AccountID)) + ";"
-			pay +=  strconv.Itoa(int(payment.Amount)) + ";"
-			pay +=  string(payment.Category) + ";"
-			pay += string(payment.Status) + ";"
-			pay += "\n"
-			}
-		err := WriteToFile(dir + "/payments.dump", pay)
-		if err != nil {
-			log.Print(err)
+		stored, err := s.repo().Accounts().ByID(account.ID)
+		if err != nil && err != ErrAccountNotFound {
 			return err
 		}
-
-	}
-	if s.favorites != nil{
-		fav := ""
-		for _, favorite := range s.favorites{
-			fav += favorite.ID + ";"
-			fav += strconv.Itoa(int(favorite.AccountID)) + ";"
-			fav += favorite.Name + ";"
-			fav += strconv.Itoa(int(favorite.Amount)) + ";"
-			fav += string(favorite.Category) + ";"
-			fav += "\n"
+		if err == nil {
+			if field(fields, 3) != "" && account.Currency != stored.Currency {
+				report.add(path, record, fmt.Errorf("%w: %v", ErrCurrencyMismatch, account.Currency))
+				continue
+			}
+			updated := *stored
+			updated.Phone = account.Phone
+			updated.Balance = account.Balance
+			account = &updated
 		}
-		err := WriteToFile(dir + "/favorites.dump", fav)
-		if err != nil {
-			log.Print(err)
+
+		owner, err := s.repo().Accounts().ByPhone(account.Phone)
+		if err != nil && err != ErrAccountNotFound {
 			return err
 		}
+		_, taken := phones[account.Phone]
+		if taken || (err == nil && owner.ID != account.ID) {
+			report.add(path, record, fmt.Errorf("%w: %v", ErrPhoneNumberRegistred, account.Phone))
+			continue
+		}
+		phones[account.Phone] = account.ID
 
+		c.accounts = append(c.accounts, account)
 	}
+	return nil
+}
 
+// у нас же данные есть ведь? - например RegisterAc - который в памяти хранится
+// если она пустая то дай ошибку
+// нужна отдельная функция для создания файлов, чтобы 3 раза не писать одно и тоже
 
+func (s *Service) Export(dir string) error {
+	return s.ExportFormat(dir, FormatDump)
+}
 
+// ExportFormat writes all the records of the service into dir in format.
+func (s *Service) ExportFormat(dir string, format Format) error {
+	codec, err := codecOf(format)
+	if err != nil {
+		return err
+	}
 
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer unlock()
 
+	files, err := s.dumpFiles(codec)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
 
+	// все три файла пишутся вместе, см. writeDumps
+	err = writeDumps(dir, codec, files)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
 
-	return  nil
+	return nil
 
 }
 
+// dumpFiles encodes everything in the repository into the files of codec c.
+func (s *Service) dumpFiles(c codec) (map[string]string, error) {
+	accounts, err := s.repo().Accounts().All()
+	if err != nil {
+		return nil, err
+	}
+	payments, err := s.repo().Payments().All()
+	if err != nil {
+		return nil, err
+	}
+	favorites, err := s.repo().Favorites().All()
+	if err != nil {
+		return nil, err
+	}
+	postings, err := s.repo().Postings().All()
+	if err != nil {
+		return nil, err
+	}
+	deposits, err := s.repo().Deposits().All()
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := s.repo().Transfers().All()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.liveKeys()
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := s.repo().Refunds().All()
+	if err != nil {
+		return nil, err
+	}
 
+	names := c.names()
+	files := map[string]string{}
+	files[names[0]], err = c.encodeAccounts(accounts)
+	if err != nil {
+		return nil, err
+	}
+	files[names[1]], err = c.encodePayments(payments)
+	if err != nil {
+		return nil, err
+	}
+	files[names[2]], err = c.encodeFavorites(favorites)
+	if err != nil {
+		return nil, err
+	}
+	files[names[3]], err = c.encodePostings(postings)
+	if err != nil {
+		return nil, err
+	}
+	files[names[4]], err = c.encodeDeposits(deposits)
+	if err != nil {
+		return nil, err
+	}
+	files[names[5]], err = c.encodeTransfers(transfers)
+	if err != nil {
+		return nil, err
+	}
+	files[names[6]], err = c.encodeKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	files[names[7]], err = c.encodeRefunds(refunds)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
 
-
-func WriteToFile(path string, data string)error  {
+func WriteToFile(path string, data string) error {
 	file, err := os.Create(path)
 	if err != nil {
 		log.Print(err)
 		return err
 	}
 	defer func() {
-		err =  file.Close()
+		err = file.Close()
 		if err != nil {
 			log.Print(err)
 			return
@@ -431,232 +1411,680 @@ func WriteToFile(path string, data string)error  {
 	_, err = file.WriteString(data)
 	if err != nil {
 		log.Print(err)
-		return  err
+		return err
 	}
 	return nil
 }
+
+// MergeStrategy tells Import what to do with an imported record whose ID is
+// already taken by a different stored record. Records equal to the stored
+// ones are always left as they are.
+type MergeStrategy int
+
+const (
+	// MergeFail reports the record as bad, so nothing is imported.
+	MergeFail MergeStrategy = iota
+	// MergeOverwrite replaces the stored record with the imported one.
+	MergeOverwrite
+	// MergeKeep keeps the stored record and skips the imported one.
+	MergeKeep
+)
+
+// ErrRecordConflict is reported by Import with MergeFail for an imported
+// record whose ID is taken by a different stored record.
+var ErrRecordConflict = errors.New("record differs from the stored one with the same id")
+
+// merge tells whether an imported record is to be saved. exists is whether
+// its ID is stored already, equal whether the stored record is the same.
+func (m MergeStrategy) merge(exists bool, equal bool) (bool, error) {
+	switch {
+	case !exists:
+		return true, nil
+	case equal:
+		return false, nil
+	case m == MergeOverwrite:
+		return true, nil
+	case m == MergeKeep:
+		return false, nil
+	}
+	return false, ErrRecordConflict
+}
+
+// Import merges the dump files in dir into the service, keeping the IDs of
+// the records and overwriting stored records with the same IDs. All files are
+// read and checked first, and nothing changes unless all of them are fine.
+// Bad records are reported together in an *ImportError.
 func (s *Service) Import(dir string) error {
-	err := s.actionByAccounts(dir + "/accounts.dump")
+	return s.ImportMerge(dir, FormatDump, MergeOverwrite)
+}
+
+// ImportFormat is Import for files written in format.
+func (s *Service) ImportFormat(dir string, format Format) error {
+	return s.ImportMerge(dir, format, MergeOverwrite)
+}
+
+// ImportMerge is Import for files written in format, resolving ID conflicts
+// with merge. A phone that belongs to another stored account is a conflict
+// whatever the strategy.
+func (s *Service) ImportMerge(dir string, format Format, merge MergeStrategy) error {
+	codec, err := codecOf(format)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lockAll(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := readDumps(dir, codec)
+	if err != nil {
+		return err
+	}
+	names := codec.names()
+
+	c := change{op: "import"}
+	report := &ImportError{}
+
+	o := newOrigin(names[0], report)
+	accounts := codec.parseAccounts(o, files[names[0]])
+	err = s.actionByAccounts(accounts, o, merge, &c)
+	if err != nil {
+		return err
+	}
+
+	o = newOrigin(names[1], report)
+	payments := codec.parsePayments(o, files[names[1]])
+	err = s.actionByPayments(payments, o, merge, &c)
+	if err != nil {
+		return err
+	}
+
+	o = newOrigin(names[2], report)
+	favorites := codec.parseFavorites(o, files[names[2]])
+	err = s.actionByFavorites(favorites, o, merge, &c)
+	if err != nil {
+		return err
+	}
+
+	o = newOrigin(names[3], report)
+	postings := codec.parsePostings(o, files[names[3]])
+	err = s.actionByPostings(postings, o, merge, &c)
+	if err != nil {
+		return err
+	}
+
+	o = newOrigin(names[4], report)
+	deposits := codec.parseDeposits(o, files[names[4]])
+	err = s.actionByDeposits(deposits, o, merge, &c)
+	if err != nil {
+		return err
+	}
+
+	o = newOrigin(names[5], report)
+	transfers := codec.parseTransfers(o, files[names[5]])
+	err = s.actionByTransfers(transfers, o, merge, &c)
+	if err != nil {
+		return err
+	}
+
+	o = newOrigin(names[6], report)
+	keys := codec.parseKeys(o, files[names[6]])
+	err = s.actionByKeys(keys, o, merge, &c)
+	if err != nil {
+		return err
+	}
+
+	o = newOrigin(names[7], report)
+	refunds := codec.parseRefunds(o, files[names[7]])
+	err = s.actionByRefunds(refunds, o, merge, &c)
 	if err != nil {
-		log.Println("err from actionByAccount")
 		return err
 	}
 
-	err = s.actionByPayments(dir + "/payments.dump")
+	err = report.err()
+	if err != nil {
+		return err
+	}
+	err = s.balanceLedger(&c)
 	if err != nil {
-		log.Println("err from actionByPayments")
 		return err
 	}
+	return s.commitAll(c)
+}
+
+// actionByAccounts and the other actionBy funcs check the records read from
+// one file, whatever its format, and add the ones to be saved to the change
+// made by Import. Bad records go to the report of o; the returned error is for
+// the repository failing. They do not write to the repository, which is only
+// written once every file is checked.
+func (s *Service) actionByAccounts(accounts []*types.Account, o *origin, merge MergeStrategy, c *change) error {
+	phones := map[types.Phone]bool{}
+	seen := map[int64]bool{}
+	for i, account := range accounts {
+		if account.Phone == "" {
+			o.failRecord(i, fmt.Errorf("phone: %w", ErrEmptyField))
+			continue
+		}
+		phone, err := s.parsePhone(account.Phone)
+		if err != nil {
+			o.failRecord(i, fmt.Errorf("%w %q", err, account.Phone))
+			continue
+		}
+		account.Phone = phone
+		if !account.Currency.Known() {
+			o.failRecord(i, fmt.Errorf("%w %q", ErrUnknownCurrency, account.Currency))
+			continue
+		}
+		if !account.Status.Known() {
+			o.failRecord(i, fmt.Errorf("%w %q", ErrUnknownAccountStatus, account.Status))
+			continue
+		}
+		err = checkLimits(account.Limits)
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		if account.Overdraft < 0 {
+			o.failRecord(i, fmt.Errorf("overdraft: %w", ErrNegativeLimit))
+			continue
+		}
+		account.Profile, err = parseProfile(account.Profile)
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		if seen[account.ID] {
+			o.failRecord(i, fmt.Errorf("%w: %v", ErrDuplicateRecord, account.ID))
+			continue
+		}
+		seen[account.ID] = true
+
+		stored, err := s.repo().Accounts().ByID(account.ID)
+		if err != nil && err != ErrAccountNotFound {
+			return err
+		}
+		save, conflict := merge.merge(err == nil, err == nil && reflect.DeepEqual(stored, account))
+		if conflict != nil {
+			o.failRecord(i, fmt.Errorf("account %v: %w", account.ID, conflict))
+			continue
+		}
+		if !save {
+			continue
+		}
+
+		owner, err := s.repo().Accounts().ByPhone(account.Phone)
+		if err != nil && err != ErrAccountNotFound {
+			return err
+		}
+		if phones[account.Phone] || (err == nil && owner.ID != account.ID) {
+			o.failRecord(i, fmt.Errorf("%w: %v", ErrPhoneNumberRegistred, account.Phone))
+			continue
+		}
+		phones[account.Phone] = true
+
+		c.accounts = append(c.accounts, account)
+	}
+
+	return nil
+}
+
+func (s *Service) actionByPayments(payments []*types.Payment, o *origin, merge MergeStrategy, c *change) error {
+	imported := importedAccounts(c)
+	seen := map[string]bool{}
+	for i, payment := range payments {
+		err := checkPayment(payment)
+		if err == nil && seen[payment.ID] {
+			err = fmt.Errorf("%w: %v", ErrDuplicateRecord, payment.ID)
+		}
+		if err == nil {
+			known, repoErr := s.accountKnown(payment.AccountID, imported)
+			if repoErr != nil {
+				return repoErr
+			}
+			if !known {
+				err = fmt.Errorf("account %v: %w", payment.AccountID, ErrAccountNotFound)
+			}
+		}
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		seen[payment.ID] = true
 
-	err = s.actionByFavorites(dir + "/favorites.dump")
-	if err != nil {
-		log.Println("err from actionByFavorites")
-		return err
+		stored, err := s.repo().Payments().ByID(payment.ID)
+		if err != nil && err != ErrPaymentNotFound {
+			return err
+		}
+		save, conflict := merge.merge(err == nil, err == nil && reflect.DeepEqual(stored, payment))
+		if conflict != nil {
+			o.failRecord(i, fmt.Errorf("payment %v: %w", payment.ID, conflict))
+			continue
+		}
+		if save {
+			c.payments = append(c.payments, payment)
+		}
 	}
-
 	return nil
 }
 
-func (s *Service) actionByAccounts(path string) error {
-	byteData, err := ioutil.ReadFile(path)
-	if err == nil {
-		datas := string(byteData)
-		splits := strings.Split(datas, "\n")
-
-		for _, split := range splits {
-			if len(split) == 0 {
-				break
+func (s *Service) actionByFavorites(favorites []*types.Favorite, o *origin, merge MergeStrategy, c *change) error {
+	imported := importedAccounts(c)
+	seen := map[string]bool{}
+	for i, favorite := range favorites {
+		err := checkFavorite(favorite)
+		if err == nil && seen[favorite.ID] {
+			err = fmt.Errorf("%w: %v", ErrDuplicateRecord, favorite.ID)
+		}
+		if err == nil {
+			known, repoErr := s.accountKnown(favorite.AccountID, imported)
+			if repoErr != nil {
+				return repoErr
 			}
-
-			data := strings.Split(split, ";")
-
-			id, err := strconv.Atoi(data[0])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
+			if !known {
+				err = fmt.Errorf("account %v: %w", favorite.AccountID, ErrAccountNotFound)
 			}
+		}
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		seen[favorite.ID] = true
 
-			phone := types.Phone(data[1])
+		stored, err := s.repo().Favorites().ByID(favorite.ID)
+		if err != nil && err != ErrFavoriteNotFound {
+			return err
+		}
+		save, conflict := merge.merge(err == nil, err == nil && *stored == *favorite)
+		if conflict != nil {
+			o.failRecord(i, fmt.Errorf("favorite %v: %w", favorite.ID, conflict))
+			continue
+		}
+		if save {
+			c.favorites = append(c.favorites, favorite)
+		}
+	}
+	return nil
+}
 
-			balance, err := strconv.Atoi(data[2])
+func (s *Service) actionByPostings(postings []*types.Posting, o *origin, merge MergeStrategy, c *change) error {
+	imported := importedAccounts(c)
+	seen := map[string]bool{}
+	for i, posting := range postings {
+		err := checkPosting(posting)
+		if err == nil && seen[posting.ID] {
+			err = fmt.Errorf("%w: %v", ErrDuplicateRecord, posting.ID)
+		}
+		for _, accountID := range []int64{posting.Debit, posting.Credit} {
 			if err != nil {
-				log.Println("can't parse str to int")
-				return err
+				break
 			}
-			// вот это зачем?
-			account, err := s.FindAccountByID(int64(id))
-			if err != nil {
-				acc, err := s.RegisterAccount(phone)
-				if err != nil {
-					log.Println("err from register account")
-					return err
+			if accountID <= 0 {
+				if !systemLedgerAccount(accountID) {
+					err = fmt.Errorf("%w: %v", ErrUnknownLedgerAccount, accountID)
 				}
-
-				acc.Balance = types.Money(balance)
-			} else {
-				account.Phone = phone
-				account.Balance = types.Money(balance)
+				continue
+			}
+			known, repoErr := s.accountKnown(accountID, imported)
+			if repoErr != nil {
+				return repoErr
+			}
+			if !known {
+				err = fmt.Errorf("account %v: %w", accountID, ErrAccountNotFound)
 			}
 		}
-	} else {
-		log.Println(ErrFileNotFound.Error())
-	}
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		seen[posting.ID] = true
 
+		stored, err := s.repo().Postings().ByID(posting.ID)
+		if err != nil && err != ErrPostingNotFound {
+			return err
+		}
+		save, conflict := merge.merge(err == nil, err == nil && *stored == *posting)
+		if conflict != nil {
+			o.failRecord(i, fmt.Errorf("posting %v: %w", posting.ID, conflict))
+			continue
+		}
+		if save {
+			c.postings = append(c.postings, posting)
+		}
+	}
 	return nil
 }
 
-func (s *Service) actionByPayments(path string) error {
-	byteData, err := ioutil.ReadFile(path)
-	if err == nil {
-		datas := string(byteData)
-		splits := strings.Split(datas, "\n")
-
-		for _, split := range splits {
-			if len(split) == 0 {
-				break
+func (s *Service) actionByDeposits(deposits []*types.Deposit, o *origin, merge MergeStrategy, c *change) error {
+	imported := importedAccounts(c)
+	seen := map[string]bool{}
+	for i, deposit := range deposits {
+		err := checkDeposit(deposit)
+		if err == nil && seen[deposit.ID] {
+			err = fmt.Errorf("%w: %v", ErrDuplicateRecord, deposit.ID)
+		}
+		if err == nil {
+			known, repoErr := s.accountKnown(deposit.AccountID, imported)
+			if repoErr != nil {
+				return repoErr
 			}
+			if !known {
+				err = fmt.Errorf("account %v: %w", deposit.AccountID, ErrAccountNotFound)
+			}
+		}
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		seen[deposit.ID] = true
 
-			data := strings.Split(split, ";")
-			id := data[0]
+		stored, err := s.repo().Deposits().ByID(deposit.ID)
+		if err != nil && err != ErrDepositNotFound {
+			return err
+		}
+		save, conflict := merge.merge(err == nil, err == nil && *stored == *deposit)
+		if conflict != nil {
+			o.failRecord(i, fmt.Errorf("deposit %v: %w", deposit.ID, conflict))
+			continue
+		}
+		if save {
+			c.deposits = append(c.deposits, deposit)
+		}
+	}
+	return nil
+}
 
-			accountID, err := strconv.Atoi(data[1])
+func (s *Service) actionByTransfers(transfers []*types.Transfer, o *origin, merge MergeStrategy, c *change) error {
+	imported := importedAccounts(c)
+	seen := map[string]bool{}
+	for i, transfer := range transfers {
+		err := checkTransfer(transfer)
+		if err == nil && seen[transfer.ID] {
+			err = fmt.Errorf("%w: %v", ErrDuplicateRecord, transfer.ID)
+		}
+		for _, accountID := range []int64{transfer.FromAccountID, transfer.ToAccountID} {
 			if err != nil {
-				log.Println("can't parse str to int")
-				return err
+				break
 			}
-
-			amount, err := strconv.Atoi(data[2])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
+			known, repoErr := s.accountKnown(accountID, imported)
+			if repoErr != nil {
+				return repoErr
 			}
-
-			category := types.PaymentCategory(data[3])
-
-			status := types.PaymentStatus(data[4])
-
-			payment, err := s.FindPaymentByID(id)
-			if err != nil {
-				newPayment := &types.Payment{
-					ID:        id,
-					AccountID: int64(accountID),
-					Amount:    types.Money(amount),
-					Category:  types.PaymentCategory(category),
-					Status:    types.PaymentStatus(status),
-				}
-
-				s.payments = append(s.payments, newPayment)
-			} else {
-				payment.AccountID = int64(accountID)
-				payment.Amount = types.Money(amount)
-				payment.Category = category
-				payment.Status = status
+			if !known {
+				err = fmt.Errorf("account %v: %w", accountID, ErrAccountNotFound)
 			}
 		}
-	} else {
-		log.Println(ErrFileNotFound.Error())
-	}
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		seen[transfer.ID] = true
 
+		stored, err := s.repo().Transfers().ByID(transfer.ID)
+		if err != nil && err != ErrTransferNotFound {
+			return err
+		}
+		save, conflict := merge.merge(err == nil, err == nil && *stored == *transfer)
+		if conflict != nil {
+			o.failRecord(i, fmt.Errorf("transfer %v: %w", transfer.ID, conflict))
+			continue
+		}
+		if save {
+			c.transfers = append(c.transfers, transfer)
+		}
+	}
 	return nil
 }
 
-func (s *Service) actionByFavorites(path string) error {
-	byteData, err := ioutil.ReadFile(path)
-	if err == nil {
-		datas := string(byteData)
-		splits := strings.Split(datas, "\n")
-
-		for _, split := range splits {
-			if len(split) == 0 {
-				break
+func (s *Service) actionByKeys(keys []*types.IdempotencyKey, o *origin, merge MergeStrategy, c *change) error {
+	imported := importedAccounts(c)
+	seen := map[keyID]bool{}
+	for i, key := range keys {
+		id := keyID{key.AccountID, key.Key}
+		err := checkKey(key)
+		if err == nil && seen[id] {
+			err = fmt.Errorf("%w: %v", ErrDuplicateRecord, key.Key)
+		}
+		if err == nil {
+			known, repoErr := s.accountKnown(key.AccountID, imported)
+			if repoErr != nil {
+				return repoErr
 			}
-
-			data := strings.Split(split, ";")
-			id := data[0]
-
-			accountID, err := strconv.Atoi(data[1])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
+			if !known {
+				err = fmt.Errorf("account %v: %w", key.AccountID, ErrAccountNotFound)
 			}
+		}
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		seen[id] = true
 
-			name := data[2]
+		stored, err := s.repo().Keys().ByKey(key.AccountID, key.Key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		save, conflict := merge.merge(err == nil, err == nil && *stored == *key)
+		if conflict != nil {
+			o.failRecord(i, fmt.Errorf("key %v: %w", key.Key, conflict))
+			continue
+		}
+		if save {
+			c.keys = append(c.keys, key)
+		}
+	}
+	return nil
+}
 
-			amount, err := strconv.Atoi(data[3])
-			if err != nil {
-				log.Println("can't parse str to int")
-				return err
+func (s *Service) actionByRefunds(refunds []*types.Refund, o *origin, merge MergeStrategy, c *change) error {
+	imported := importedAccounts(c)
+	payments := map[string]*types.Payment{}
+	for _, payment := range c.payments {
+		payments[payment.ID] = payment
+	}
+	// refunds of the file replace the stored ones with the same IDs, so only
+	// the other stored ones count towards the totals of their payments
+	inFile := map[string]bool{}
+	for _, refund := range refunds {
+		inFile[refund.ID] = true
+	}
+	totals := map[string]types.Money{}
+	counted := map[string]bool{}
+
+	seen := map[string]bool{}
+	for i, refund := range refunds {
+		err := checkRefund(refund)
+		if err == nil && seen[refund.ID] {
+			err = fmt.Errorf("%w: %v", ErrDuplicateRecord, refund.ID)
+		}
+		if err == nil {
+			known, repoErr := s.accountKnown(refund.AccountID, imported)
+			if repoErr != nil {
+				return repoErr
 			}
-
-			category := types.PaymentCategory(data[4])
-
-			favorite, err := s.FindFavoriteByID(id)
-			if err != nil {
-				newFavorite := &types.Favorite{
-					ID:        id,
-					AccountID: int64(accountID),
-					Name:      name,
-					Amount:    types.Money(amount),
-					Category:  types.PaymentCategory(category),
+			if !known {
+				err = fmt.Errorf("account %v: %w", refund.AccountID, ErrAccountNotFound)
+			}
+		}
+		var payment *types.Payment
+		if err == nil {
+			var repoErr error
+			payment, repoErr = s.importedPayment(refund.PaymentID, payments)
+			if repoErr != nil {
+				return repoErr
+			}
+			switch {
+			case payment == nil:
+				err = fmt.Errorf("payment %v: %w", refund.PaymentID, ErrPaymentNotFound)
+			case payment.AccountID != refund.AccountID:
+				err = fmt.Errorf("payment %v: %w", refund.PaymentID, ErrRefundAccountMismatch)
+			}
+		}
+		if err == nil && !counted[payment.ID] {
+			stored, repoErr := s.repo().Refunds().ByPayment(payment.ID)
+			if repoErr != nil {
+				return repoErr
+			}
+			for _, other := range stored {
+				if inFile[other.ID] {
+					continue
+				}
+				totals[payment.ID], err = totals[payment.ID].Add(other.Amount)
+				if err != nil {
+					break
 				}
-
-				s.favorites = append(s.favorites, newFavorite)
-			} else {
-				favorite.AccountID = int64(accountID)
-				favorite.Name = name
-				favorite.Amount = types.Money(amount)
-				favorite.Category = category
 			}
+			counted[payment.ID] = true
 		}
-	} else {
-		log.Println(ErrFileNotFound.Error())
-	}
+		var total types.Money
+		if err == nil {
+			total, err = totals[payment.ID].Add(refund.Amount)
+		}
+		if err == nil && total > payment.Amount {
+			err = fmt.Errorf("payment %v: %w", payment.ID, ErrRefundTooLarge)
+		}
+		if err != nil {
+			o.failRecord(i, err)
+			continue
+		}
+		seen[refund.ID] = true
 
+		stored, err := s.repo().Refunds().ByID(refund.ID)
+		if err != nil && err != ErrRefundNotFound {
+			return err
+		}
+		save, conflict := merge.merge(err == nil, err == nil && *stored == *refund)
+		if conflict != nil {
+			o.failRecord(i, fmt.Errorf("refund %v: %w", refund.ID, conflict))
+			continue
+		}
+		if save {
+			totals[payment.ID] = total
+			c.refunds = append(c.refunds, refund)
+		}
+	}
 	return nil
 }
 
+// importedPayment returns the payment with paymentID from the payments of the
+// change, or else from the repository. It is nil if there is no such payment.
+func (s *Service) importedPayment(paymentID string, imported map[string]*types.Payment) (*types.Payment, error) {
+	if payment := imported[paymentID]; payment != nil {
+		return payment, nil
+	}
+	payment, err := s.repo().Payments().ByID(paymentID)
+	if err == ErrPaymentNotFound {
+		return nil, nil
+	}
+	return payment, err
+}
+
+// importedAccounts returns the IDs of the accounts in the change.
+func importedAccounts(c *change) map[int64]bool {
+	ids := map[int64]bool{}
+	for _, account := range c.accounts {
+		ids[account.ID] = true
+	}
+	return ids
+}
+
+// accountKnown reports whether an imported record may refer to accountID:
+// the account is stored already or is imported with it.
+func (s *Service) accountKnown(accountID int64, imported map[int64]bool) (bool, error) {
+	if imported[accountID] {
+		return true, nil
+	}
+	_, err := s.repo().Accounts().ByID(accountID)
+	if err == ErrAccountNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
 
 func (s *Service) ExportAccountHistory(accountID int64) ([]types.Payment, error) {
-	_, err := s.FindAccountByID(accountID)
+	_, unlock, err := s.lockAccount(accountID)
 	if err != nil {
 		return nil, ErrAccountNotFound
 	}
+	defer unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payments, err := s.repo().Payments().ByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
 	accountPayments := []types.Payment{}
 
-	for _, payment := range s.payments {
-		if payment.AccountID == accountID {
-			accountPayments = append(accountPayments, types.Payment{
-				ID:        payment.ID,
-				AccountID: payment.AccountID,
-				Amount:    payment.Amount,
-				Category:  payment.Category,
-				Status:    payment.Status,
-			})
+	for _, payment := range payments {
+		accountPayment := *payment
+		refunds, err := s.paymentRefunds(payment.ID)
+		if err != nil {
+			return nil, err
 		}
+		if len(refunds) != 0 {
+			accountPayment.Refunds = refunds
+		}
+		accountPayments = append(accountPayments, accountPayment)
 	}
 
 	return accountPayments, nil
 }
 
+// ExportAccountDeposits returns the deposits of the account, oldest first.
+func (s *Service) ExportAccountDeposits(accountID int64) ([]types.Deposit, error) {
+	_, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	defer unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deposits, err := s.repo().Deposits().ByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	accountDeposits := []types.Deposit{}
+	for _, deposit := range deposits {
+		accountDeposits = append(accountDeposits, *deposit)
+	}
+	return accountDeposits, nil
+}
+
 func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records int) error {
+	return s.HistoryToFilesFormat(payments, dir, records, FormatDump)
+}
+
+// HistoryToFilesFormat is HistoryToFiles writing files in format, e.g.
+// payments1.csv, payments2.csv and so on.
+func (s *Service) HistoryToFilesFormat(payments []types.Payment, dir string, records int, format Format) error {
+	codec, err := codecOf(format)
+	if err != nil {
+		return err
+	}
+
 	if len(payments) == 0 {
 		return nil
 	}
 	if len(payments) <= records {
-		exportPayments(payments, dir+"/payments.dump")
+		exportPayments(payments, dir+"/payments."+codec.ext, codec)
 		return nil
 	}
 	var iterator int = 1
 	count := 0
 	for i := 1; i <= len(payments); i++ {
 		strIterator := strconv.Itoa(iterator)
-		fileName := dir + "/payments" + strIterator + ".dump"
+		fileName := dir + "/payments" + strIterator + "." + codec.ext
 		if i == len(payments) && i-count != records {
-			exportPayments(payments[count:i], fileName)
+			exportPayments(payments[count:i], fileName, codec)
 		}
 
 		if i-count == records {
-			exportPayments(payments[count:i], fileName)
+			exportPayments(payments[count:i], fileName, codec)
 			count += records
 			iterator++
 		}
@@ -664,19 +2092,18 @@ func (s *Service) HistoryToFiles(payments []types.Payment, dir string, records i
 	return nil
 }
 
-func exportPayments(payments []types.Payment, path string) error {
-	pay := ""
-
-	for _, payment := range payments {
+func exportPayments(payments []types.Payment, path string, c codec) error {
+	pays := make([]*types.Payment, len(payments))
+	for i := range payments {
+		pays[i] = &payments[i]
+	}
 
-		pay += payment.ID + ";"
-		pay += strconv.Itoa(int(payment.AccountID)) + ";"
-		pay += strconv.Itoa(int(payment.Amount)) + ";"
-		pay += string(payment.Category) + ";"
-		pay += string(payment.Status) + ";"
-		pay += "\n"
+	data, err := c.encodePayments(pays)
+	if err != nil {
+		log.Print(err)
+		return err
 	}
-	err := WriteToFile(path, pay)
+	err = WriteToFile(path, data)
 	if err != nil {
 		log.Print(err)
 		return err
@@ -684,11 +2111,54 @@ func exportPayments(payments []types.Payment, path string) error {
 	return nil
 }
 
-
+// SumPayments is TotalPayments for callers that only want the sum. Errors
+// are logged and give 0.
 func (s *Service) SumPayments(goroutines int) types.Money {
+	sum, err := s.TotalPayments(goroutines)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	return sum
+}
+
+// TotalPayments sums the amounts of all payments in types.DefaultCurrency,
+// splitting them between goroutines. Payments in other currencies are
+// converted at the rates of the service, so without a RateProvider they give
+// ErrCurrencyMismatch. It gives ErrOverflow if the sum does not fit in
+// types.Money.
+func (s *Service) TotalPayments(goroutines int) (types.Money, error) {
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	payments, err := s.repo().Payments().All()
+	if err != nil {
+		return 0, err
+	}
+	rates, err := s.reportRates(payments)
+	if err != nil {
+		return 0, err
+	}
+
 	wg := sync.WaitGroup{}
 	mu := sync.Mutex{}
 	var summ types.Money = 0
+	var summErr error
+	add := func(sum types.Money, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			sum, err = summ.Add(sum)
+		}
+		if err != nil {
+			summErr = err
+			return
+		}
+		summ = sum
+	}
 	// мы дали 6, значит 1 часть не будет работать, там же сказано, если 1 или 0 то дополнительных
 	// результатов не требуеются
 	//
@@ -696,40 +2166,68 @@ func (s *Service) SumPayments(goroutines int) types.Money {
 		wg.Add(1)
 		go func(payments []*types.Payment) {
 			defer wg.Done()
-			for _, payment := range payments {
-				summ += payment.Amount
-			}
-		}(s.payments)
+			add(sumAmounts(payments, rates))
+		}(payments)
 	} else {
 		from := 0
-		count := len(s.payments) / goroutines
+		count := len(payments) / goroutines
 		for i := 1; i <= goroutines; i++ {
 			wg.Add(1)
-			last := len(s.payments) - i*count
+			last := len(payments) - i*count
 			if i == goroutines {
 				last = 0
 			}
-			to := len(s.payments) - last
+			to := len(payments) - last
 			go func(payments []*types.Payment) {
 				defer wg.Done()
-				s := types.Money(0)
-				for _, payment := range payments {
-					s += payment.Amount
-				}
-				mu.Lock()
-				defer mu.Unlock()
-				summ += s
-			}(s.payments[from:to])
+				add(sumAmounts(payments, rates))
+			}(payments[from:to])
 			from += count
 		}
 	}
 
 	wg.Wait()
 
-	return summ
+	if summErr != nil {
+		return 0, summErr
+	}
+	return summ, nil
+}
+
+// sumAmounts sums the amounts of payments in types.DefaultCurrency at rates,
+// ErrOverflow if they do not fit.
+func sumAmounts(payments []*types.Payment, rates map[types.Currency]types.Rate) (types.Money, error) {
+	var sum types.Money
+	for _, payment := range payments {
+		amount, err := reportAmount(payment, rates)
+		if err != nil {
+			return 0, err
+		}
+		sum, err = sum.Add(amount)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return sum, nil
 }
 
 func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payment, error) {
+	// payments of every account are read, so every account is locked
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	_, err = s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+
+	payments, err := s.repo().Payments().All()
+	if err != nil {
+		return nil, err
+	}
+
 	filteredPayments := []types.Payment{}
 	wg := sync.WaitGroup{}
 	mu := sync.Mutex{}
@@ -739,44 +2237,32 @@ func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payme
 			defer wg.Done()
 			for _, payment := range payments {
 				if payment.AccountID == accountID {
-					filteredPayments = append(filteredPayments, types.Payment{
-						ID:        payment.ID,
-						AccountID: payment.AccountID,
-						Amount:    payment.Amount,
-						Category:  payment.Category,
-						Status:    payment.Status,
-					})
+					filteredPayments = append(filteredPayments, *payment)
 				}
 			}
-		}(s.payments)
+		}(payments)
 	} else {
 		from := 0
-		count := len(s.payments) / goroutines
+		count := len(payments) / goroutines
 		for i := 1; i <= goroutines; i++ {
 			wg.Add(1)
-			last := len(s.payments) - i*count
+			last := len(payments) - i*count
 			if i == goroutines {
 				last = 0
 			}
-			to := len(s.payments) - last
+			to := len(payments) - last
 			go func(payments []*types.Payment) {
 				defer wg.Done()
 				separetePayments := []types.Payment{}
 				for _, payment := range payments {
 					if payment.AccountID == accountID {
-						separetePayments = append(separetePayments, types.Payment{
-							ID:        payment.ID,
-							AccountID: payment.AccountID,
-							Amount:    payment.Amount,
-							Category:  payment.Category,
-							Status:    payment.Status,
-						})
+						separetePayments = append(separetePayments, *payment)
 					}
 				}
 				mu.Lock()
 				defer mu.Unlock()
 				filteredPayments = append(filteredPayments, separetePayments...)
-			}(s.payments[from:to])
+			}(payments[from:to])
 			from += count
 		}
 	}
@@ -790,33 +2276,109 @@ func (s *Service) FilterPayments(accountID int64, goroutines int) ([]types.Payme
 	return filteredPayments, nil
 }
 
+// FilterDeposits is FilterPayments for deposits: it looks through all the
+// deposits in goroutines parts and returns the ones of the account. An
+// account without deposits has an empty list.
+func (s *Service) FilterDeposits(accountID int64, goroutines int) ([]types.Deposit, error) {
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	_, err = s.FindAccountByID(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+
+	deposits, err := s.repo().Deposits().All()
+	if err != nil {
+		return nil, err
+	}
+
+	if goroutines < 1 {
+		goroutines = 1
+	}
+	parts := make([][]types.Deposit, goroutines)
+	count := len(deposits) / goroutines
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		from, to := i*count, (i+1)*count
+		if i == goroutines-1 {
+			to = len(deposits)
+		}
+		wg.Add(1)
+		go func(part *[]types.Deposit, deposits []*types.Deposit) {
+			defer wg.Done()
+			for _, deposit := range deposits {
+				if deposit.AccountID == accountID {
+					*part = append(*part, *deposit)
+				}
+			}
+		}(&parts[i], deposits[from:to])
+	}
+	wg.Wait()
+
+	// parts are joined in order, so deposits stay oldest first
+	filteredDeposits := []types.Deposit{}
+	for _, part := range parts {
+		filteredDeposits = append(filteredDeposits, part...)
+	}
+	return filteredDeposits, nil
+}
+
+// SumPaymentsWithProgress sums the amounts of all payments in parts of up
+// to a million payments, sending the sum of every part on the returned
+// channel. Sums are in types.DefaultCurrency, like the one of TotalPayments.
+// A part whose sum does not fit in types.Money sends ErrOverflow, and
+// payments that can not be converted send a single error.
 func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 	size := 100_0000
 
-	amountOfMoney := make([]types.Money, 0)
-	for _, pay := range s.payments {
-		amountOfMoney = append(amountOfMoney, pay.Amount)
+	var payments []*types.Payment
+	unlock, err := s.lockAll(false)
+	if err == nil {
+		payments, err = s.repo().Payments().All()
+		unlock()
+	}
+	if err != nil {
+		log.Print(err)
+	}
+	amountOfMoney, err := s.reportAmounts(payments)
+	if err != nil {
+		ch := make(chan types.Progress, 1)
+		ch <- types.Progress{Err: err}
+		close(ch)
+		return ch
 	}
 
 	wg := sync.WaitGroup{}
-	goroutines := (len(amountOfMoney) + 1) / size
+	goroutines := (len(amountOfMoney) + size - 1) / size
 	ch := make(chan types.Progress)
 	if goroutines <= 0 {
 		goroutines = 1
 	}
 	for i := 0; i < goroutines; i++ {
+		from, to := i*size, (i+1)*size
+		if to > len(amountOfMoney) {
+			to = len(amountOfMoney)
+		}
 		wg.Add(1)
 		go func(ch chan<- types.Progress, amountOfMoney []types.Money, part int) {
-			sum := 0
 			defer wg.Done()
+			sum := types.Money(0)
 			for _, val := range amountOfMoney {
-				sum += int(val)
-
+				var err error
+				sum, err = sum.Add(val)
+				if err != nil {
+					ch <- types.Progress{Part: part, Err: err}
+					return
+				}
 			}
 			ch <- types.Progress{
-				Result: types.Money(sum),
+				Part:   part,
+				Result: sum,
 			}
-		}(ch, amountOfMoney, i)
+		}(ch, amountOfMoney[from:to], i)
 	}
 
 	go func() {
@@ -825,4 +2387,4 @@ func (s *Service) SumPaymentsWithProgress() <-chan types.Progress {
 	}()
 
 	return ch
-}
\ No newline at end of file
+}
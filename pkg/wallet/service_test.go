@@ -1,12 +1,20 @@
 package wallet
 
-
 import (
+	"errors"
 	"fmt"
 	"github.com/Eydzhpee08/wallet/pkg/types"
 	"github.com/google/uuid"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testService struct {
@@ -54,6 +62,16 @@ func (s *testService) addAccountWithBalance(phone types.Phone, balance types.Mon
 	return account, nil
 }
 
+// balance returns the balance the account has now: the records the service
+// hands out are copies and keep the balance they were read with.
+func (s *testService) balance(account *types.Account) types.Money {
+	found, err := s.FindAccountByID(account.ID)
+	if err != nil {
+		return 0
+	}
+	return found.Balance
+}
+
 func (s *testService) addAcoount(data testAccount) (*types.Account, []*types.Payment, error) {
 	account, err := s.RegisterAccount(data.phone)
 	if err != nil {
@@ -274,10 +292,18 @@ func TestService_Export_success(t *testing.T) {
 		t.Errorf("PayFromFavorite() can't for an favorite(%v), error = %v", paymentFavorite, err)
 	}
 
-	err = s.Export("data")
+	dir := t.TempDir()
+	err = s.Export(dir)
 	if err != nil {
 		t.Errorf("Export() Error can't export error = %v", err)
 	}
+
+	restored := newTestService()
+	err = restored.Import(dir)
+	if err != nil {
+		t.Fatalf("Import() of the export: error = %v", err)
+	}
+	assertSameState(t, restored.Service, s.Service)
 }
 
 func TestService_Import_success(t *testing.T) {
@@ -329,7 +355,7 @@ func TestService_HistoryToFiles_success(t *testing.T) {
 		return
 	}
 
-	err = s.HistoryToFiles(payments, "data", 2)
+	err = s.HistoryToFiles(payments, t.TempDir(), 2)
 	if err != nil {
 		t.Errorf("HistoryToFiles() Error can't export to file, error = %v", err)
 		return
@@ -383,7 +409,7 @@ func BenchmarkSumPayments(b *testing.B) {
 				category: "auto",
 			},
 			{
-				amount: 100,
+				amount:   100,
 				category: "auto",
 			},
 		},
@@ -405,7 +431,6 @@ func BenchmarkSumPayments(b *testing.B) {
 	}
 }
 
-
 func TestService_SumPaymentsWithProgress(t *testing.T) {
 	s := newTestService()
 	for i := 0; i < 200_000; i++ {
@@ -413,9 +438,1066 @@ func TestService_SumPaymentsWithProgress(t *testing.T) {
 			ID:     uuid.New().String(),
 			Amount: types.Money(100),
 		}
-		s.payments = append(s.payments, payment)
+		s.repo().Payments().Save(payment)
 	}
 
 	s.SumPaymentsWithProgress()
-	
-}
\ No newline at end of file
+
+}
+
+func TestService_overflow(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, math.MaxInt64-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Deposit(account.ID, 11)
+	if err != ErrOverflow {
+		t.Errorf("Deposit(): error = %v, want %v", err, ErrOverflow)
+	}
+	if s.balance(account) != math.MaxInt64-10 {
+		t.Errorf("Deposit(): balance = %v, want it unchanged", s.balance(account))
+	}
+
+	for _, amount := range []types.Money{math.MaxInt64 / 2, math.MaxInt64 / 2, 5} {
+		s.repo().Payments().Save(&types.Payment{
+			ID:     uuid.New().String(),
+			Amount: amount,
+		})
+	}
+	_, err = s.TotalPayments(1)
+	if err != ErrOverflow {
+		t.Errorf("TotalPayments(): error = %v, want %v", err, ErrOverflow)
+	}
+	if sum := s.SumPayments(2); sum != 0 {
+		t.Errorf("SumPayments() = %v, want 0", sum)
+	}
+}
+
+func TestService_concurrentPayDepositReject(t *testing.T) {
+	s := newTestService()
+
+	const (
+		accounts   = 8
+		goroutines = 32
+		operations = 200
+		balance    = types.Money(1000_00)
+	)
+
+	ids := make([]int64, accounts)
+	deposited := make([]int64, accounts)
+	paid := make([]int64, accounts)
+	refunded := make([]int64, accounts)
+	for i := range ids {
+		account, err := s.addAccountWithBalance(types.Phone(fmt.Sprintf("+99293815%04d", i)), balance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = account.ID
+	}
+
+	wg := sync.WaitGroup{}
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			type ownPayment struct {
+				account int
+				payment *types.Payment
+			}
+			own := []ownPayment{}
+			for op := 0; op < operations; op++ {
+				i := rnd.Intn(accounts)
+				amount := types.Money(rnd.Intn(100_00) + 1)
+				switch rnd.Intn(3) {
+				case 0:
+					if err := s.Deposit(ids[i], amount); err != nil {
+						t.Errorf("Deposit(): error = %v", err)
+						return
+					}
+					atomic.AddInt64(&deposited[i], int64(amount))
+				case 1:
+					payment, err := s.Pay(ids[i], amount, types.PaymentCategoryFood)
+					if err == ErrNotEnoughBalance {
+						continue
+					}
+					if err != nil {
+						t.Errorf("Pay(): error = %v", err)
+						return
+					}
+					atomic.AddInt64(&paid[i], int64(amount))
+					own = append(own, ownPayment{account: i, payment: payment})
+				case 2:
+					if len(own) == 0 {
+						continue
+					}
+					last := own[len(own)-1]
+					own = own[:len(own)-1]
+					if err := s.Reject(last.payment.ID); err != nil {
+						t.Errorf("Reject(): error = %v", err)
+						return
+					}
+					atomic.AddInt64(&refunded[last.account], int64(last.payment.Amount))
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	for i, id := range ids {
+		account, err := s.FindAccountByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := balance + types.Money(deposited[i]-paid[i]+refunded[i])
+		if account.Balance != want {
+			t.Errorf("account %v: balance = %v, want %v", id, account.Balance, want)
+		}
+		if account.Balance < 0 {
+			t.Errorf("account %v: negative balance %v", id, account.Balance)
+		}
+	}
+}
+
+func TestService_concurrentPay_noOverdraft(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountID := account.ID
+
+	var succeeded int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < 300; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Pay(accountID, 1, types.PaymentCategoryIT)
+			if err == nil {
+				atomic.AddInt64(&succeeded, 1)
+				return
+			}
+			if err != ErrNotEnoughBalance {
+				t.Errorf("Pay(): error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 100 {
+		t.Errorf("Pay(): %v payments succeeded, want 100", succeeded)
+	}
+	if s.balance(account) != 0 {
+		t.Errorf("Pay(): balance = %v, want 0", s.balance(account))
+	}
+	if got := s.SumPayments(4); got != 100 {
+		t.Errorf("SumPayments() = %v, want 100", got)
+	}
+}
+
+func TestService_concurrentRegisterAccount_samePhone(t *testing.T) {
+	s := newTestService()
+
+	var registered int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.RegisterAccount(defaultTestAccount.phone)
+			if err == nil {
+				atomic.AddInt64(&registered, 1)
+				return
+			}
+			if err != ErrPhoneNumberRegistred {
+				t.Errorf("RegisterAccount(): error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if registered != 1 {
+		t.Errorf("RegisterAccount(): %v accounts registered, want 1", registered)
+	}
+}
+
+func TestService_concurrentExport(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 1000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountID := account.ID
+	dir := t.TempDir()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			payment, err := s.Pay(accountID, 10, types.PaymentCategoryFun)
+			if err != nil {
+				t.Errorf("Pay(): error = %v", err)
+				return
+			}
+			if err := s.Reject(payment.ID); err != nil {
+				t.Errorf("Reject(): error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := s.Export(dir); err != nil {
+				t.Errorf("Export(): error = %v", err)
+			}
+			if _, err := s.ExportAccountHistory(accountID); err != nil {
+				t.Errorf("ExportAccountHistory(): error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if s.balance(account) != 1000_00 {
+		t.Errorf("balance = %v, want %v", s.balance(account), types.Money(1000_00))
+	}
+}
+
+func TestService_concurrentReadsOfAllAccounts(t *testing.T) {
+	s := newTestService()
+
+	paying, err := s.addAccountWithBalance(defaultTestAccount.phone, 1000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := s.addAccountWithBalance("+992938151003", 1000_00)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(read.ID, 10, types.PaymentCategoryFun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments := []*types.Payment{}
+	for i := 0; i < 40; i++ {
+		payment, err := s.Pay(paying.ID, 10, types.PaymentCategoryFun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payments = append(payments, payment)
+	}
+
+	// Complete and Reject change payments of one account while the others
+	// read the payments of all of them
+	wg := sync.WaitGroup{}
+	for i, payment := range payments {
+		wg.Add(2)
+		go func(paymentID string, complete bool) {
+			defer wg.Done()
+			op := s.Reject
+			if complete {
+				op = s.Complete
+			}
+			if err := op(paymentID); err != nil {
+				t.Errorf("changing payment %v: error = %v", paymentID, err)
+			}
+		}(payment.ID, i%2 == 0)
+		go func() {
+			defer wg.Done()
+			filtered, err := s.FilterPayments(read.ID, 4)
+			if err != nil || len(filtered) != 1 {
+				t.Errorf("FilterPayments() = %v, %v, want the payment of the account", filtered, err)
+			}
+			if total, err := s.TotalPayments(4); err != nil || total != 410 {
+				t.Errorf("TotalPayments() = %v, %v, want 410", total, err)
+			}
+			for progress := range s.SumPaymentsWithProgress() {
+				if progress.Err != nil || progress.Result != 410 {
+					t.Errorf("SumPaymentsWithProgress() sent %+v, want 410", progress)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestService_Export_Import_roundTrip(t *testing.T) {
+	s := newTestService()
+
+	_, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.FavoritePayment(payments[0].ID, "ogastus")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): error = %v", err)
+	}
+
+	restored := newTestService()
+	err = restored.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): error = %v", err)
+	}
+	assertSameState(t, restored.Service, s.Service)
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("Export(): temporary files left: %v", matches)
+	}
+}
+
+func TestService_Import_corruptFileChangesNothing(t *testing.T) {
+	s := newTestService()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"accounts.dump": "1;+992938151007;100;\n",
+		"payments.dump": "fd573df8-9ba1-44f4-8fa1-4af3b3690656;1;ten;auto;INPROGRESS;\n",
+	}
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := s.Import(dir)
+	if err == nil {
+		t.Fatal("Import(): must return error for a corrupt payments.dump")
+	}
+
+	accounts, _ := s.repo().Accounts().All()
+	if len(accounts) != 0 {
+		t.Errorf("Import(): accounts = %v, want none", accounts)
+	}
+}
+
+func TestService_Import_manifestMismatch(t *testing.T) {
+	s := newTestService()
+
+	_, _, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	err = s.Export(dir)
+	if err != nil {
+		t.Fatalf("Export(): error = %v", err)
+	}
+	// as if payments.dump were changed after the export
+	err = ioutil.WriteFile(filepath.Join(dir, "payments.dump"), []byte(""), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = newTestService().Import(dir)
+	if err != ErrManifestMismatch {
+		t.Errorf("Import(): error = %v, want %v", err, ErrManifestMismatch)
+	}
+}
+
+// crashExport does what writeDumps does for the state of s until a crash
+// after renaming renamed files into place; -1 crashes before the pending
+// manifest is written.
+func crashExport(t *testing.T, s *Service, dir string, renamed int) {
+	t.Helper()
+
+	files, err := s.dumpFiles(dumpCodec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := ""
+	temps := map[string]string{}
+	for _, name := range dumpCodec.names() {
+		tmp, err := writeTempFile(filepath.Join(dir, name), files[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		temps[name] = tmp
+		pending += joinFields([]string{name, checksum(files[name]), filepath.Base(tmp)}) + "\n"
+	}
+	if renamed < 0 {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, pendingManifest(dumpCodec)), []byte(pending), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range dumpCodec.names()[:renamed] {
+		err = os.Rename(temps[name], filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestService_Export_crash(t *testing.T) {
+	old := newTestService()
+	_, err := old.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService()
+	_, _, err = s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for renamed := -1; renamed <= len(dumpCodec.names()); renamed++ {
+		for _, first := range []bool{false, true} {
+			dir := t.TempDir()
+			want := s
+			if !first {
+				err = old.Export(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			crashExport(t, s.Service, dir, renamed)
+			if renamed < 0 {
+				want = old
+				if first {
+					want = newTestService()
+				}
+			}
+
+			restored := newTestService()
+			err = restored.Import(dir)
+			if err != nil {
+				t.Fatalf("Import() after a crash with %v files renamed, first %v: error = %v", renamed, first, err)
+			}
+			assertSameState(t, restored.Service, want.Service)
+
+			// the next export finishes the one cut short and replaces it
+			err = old.Export(dir)
+			if err != nil {
+				t.Fatalf("Export() after a crash: error = %v", err)
+			}
+			restored = newTestService()
+			err = restored.Import(dir)
+			if err != nil {
+				t.Fatalf("Import() after Export(): error = %v", err)
+			}
+			assertSameState(t, restored.Service, old.Service)
+			_, err = os.Stat(filepath.Join(dir, pendingManifest(dumpCodec)))
+			if !os.IsNotExist(err) {
+				t.Errorf("Export(): pending manifest left behind, error = %v", err)
+			}
+		}
+	}
+}
+
+// failingRepository fails to save payments.
+type failingRepository struct {
+	*MemoryRepository
+}
+
+var errSaveFailed = errors.New("save failed")
+
+func (r failingRepository) Payments() PaymentRepository {
+	return failingPayments{r.MemoryRepository.Payments()}
+}
+
+type failingPayments struct {
+	PaymentRepository
+}
+
+func (failingPayments) Save(payment *types.Payment) error {
+	return errSaveFailed
+}
+
+func TestService_Import_rollback(t *testing.T) {
+	memory := NewMemoryRepository()
+	s := NewService(WithRepository(failingRepository{memory}))
+
+	account, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"accounts.dump": fmt.Sprintf("%v;+992938151007;100;\n2;+992938151003;200;\n", account.ID),
+		"payments.dump": "fd573df8-9ba1-44f4-8fa1-4af3b3690656;1;10;auto;INPROGRESS;\n",
+	}
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.Import(dir)
+	if err != errSaveFailed {
+		t.Fatalf("Import(): error = %v, want %v", err, errSaveFailed)
+	}
+
+	accounts, _ := memory.Accounts().All()
+	if len(accounts) != 1 {
+		t.Fatalf("Import(): accounts = %v, want only the registered one", accounts)
+	}
+	if accounts[0].Balance != 0 {
+		t.Errorf("Import(): balance = %v, want 0", accounts[0].Balance)
+	}
+	if _, err := memory.Accounts().ByPhone("+992938151003"); err != ErrAccountNotFound {
+		t.Errorf("Import(): imported phone left in the index, error = %v", err)
+	}
+}
+
+func TestService_ExportToFile_ImportFromFile_roundTrip(t *testing.T) {
+	s := newTestService()
+
+	for _, phone := range []types.Phone{"+992938151007", "+992938151003"} {
+		_, err := s.addAccountWithBalance(phone, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.UpdateProfile(1, types.Profile{Name: "Rustam|Sharipov; \"Rusik\"", Email: "rustam@example.tj", Locale: "tg-TJ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	err = s.ExportToFile(path)
+	if err != nil {
+		t.Fatalf("ExportToFile(): error = %v", err)
+	}
+
+	// the file has no postings, so only the accounts are the same
+	want, _ := s.repo().Accounts().All()
+	restored := newTestService()
+	err = restored.ImportFromFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): error = %v", err)
+	}
+	got, _ := restored.repo().Accounts().All()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportFromFile(): accounts = %v, want %v", got, want)
+	}
+	if len(got) > 0 && (got[0].Name != `Rustam|Sharipov; "Rusik"` || got[0].Created.IsZero()) {
+		t.Errorf("ImportFromFile(): name = %q, created = %v, want the profile and creation time", got[0].Name, got[0].Created)
+	}
+
+	// a second import merges by ID instead of adding the accounts again
+	err = restored.ImportFromFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): error = %v", err)
+	}
+	got, _ = restored.repo().Accounts().All()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ImportFromFile(): accounts = %v, want %v", got, want)
+	}
+	if err := restored.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+
+	account, err := restored.RegisterAccount("+992938151001")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): error = %v", err)
+	}
+	if account.ID != 3 {
+		t.Errorf("RegisterAccount(): id = %v, want 3", account.ID)
+	}
+}
+
+func TestService_ImportFromFile_singleRecord(t *testing.T) {
+	s := newTestService()
+
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	err := ioutil.WriteFile(path, []byte("7;+992938151007;100|\n"), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.ImportFromFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): error = %v", err)
+	}
+	account, err := s.FindAccountByID(7)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): error = %v", err)
+	}
+	if account.Balance != 100 {
+		t.Errorf("FindAccountByID(): balance = %v, want 100", account.Balance)
+	}
+
+	account, err = s.RegisterAccount("+992938151003")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): error = %v", err)
+	}
+	if account.ID != 8 {
+		t.Errorf("RegisterAccount(): id = %v, want 8", account.ID)
+	}
+}
+
+func TestService_ImportFromFile_duplicatePhone(t *testing.T) {
+	s := newTestService()
+
+	registered, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	data := "5;+992938151003;10|6;+992938151003;20|7;" + string(defaultTestAccount.phone) + ";30|short"
+	err = ioutil.WriteFile(path, []byte(data), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.ImportFromFile(path)
+	assertBadLines(t, err, []badLine{
+		{path, 2, ErrPhoneNumberRegistred},
+		{path, 3, ErrPhoneNumberRegistred},
+		{path, 4, ErrDumpRecord},
+	})
+
+	accounts, _ := s.repo().Accounts().All()
+	if len(accounts) != 1 || accounts[0].ID != registered.ID {
+		t.Errorf("ImportFromFile(): accounts = %v, want only the registered one", accounts)
+	}
+}
+
+func TestService_ImportFromFile_keepsAccountState(t *testing.T) {
+	s := newTestService()
+	account, err := s.RegisterAccountIn(defaultTestAccount.phone, types.CurrencyUSD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Deposit(account.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetLimits(account.ID, &types.Limits{MaxPayment: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetOverdraft(account.ID, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.UpdateProfile(account.ID, types.Profile{Name: "Rustam; Sharipov|", Email: "rustam@example.tj", Locale: "tg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.BlockAccount(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := *account
+
+	path := filepath.Join(t.TempDir(), "accounts.txt")
+	err = s.ExportToFile(path)
+	if err != nil {
+		t.Fatalf("ExportToFile(): error = %v", err)
+	}
+	restored := newTestService()
+	err = restored.ImportFromFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile(): error = %v", err)
+	}
+	got, err := restored.FindAccountByID(account.ID)
+	if err != nil || !reflect.DeepEqual(*got, want) {
+		t.Errorf("ImportFromFile(): account = %+v, %v, want %+v", got, err, want)
+	}
+
+	// a file in the old format only changes the phone and the balance
+	err = ioutil.WriteFile(path, []byte("1;+992938151009;70|"), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ImportFromFile(path)
+	if err != nil {
+		t.Fatalf("ImportFromFile() of the old format: error = %v", err)
+	}
+	want.Phone, want.Balance = "+992938151009", 70
+	account, err = s.FindAccountByID(account.ID)
+	if err != nil || !reflect.DeepEqual(*account, want) {
+		t.Errorf("ImportFromFile() of the old format: account = %+v, want %+v", *account, want)
+	}
+
+	err = ioutil.WriteFile(path, []byte("1;+992938151009;70;TJS|"), 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ImportFromFile(path)
+	assertBadLines(t, err, []badLine{{path, 1, ErrCurrencyMismatch}})
+}
+
+func TestService_RegisterAccount_normalizesPhone(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.RegisterAccount("992 92 958 20 03")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): error = %v", err)
+	}
+	if account.Phone != "+992929582003" {
+		t.Errorf("RegisterAccount(): phone = %q, want %q", account.Phone, "+992929582003")
+	}
+	for _, phone := range []types.Phone{"+992929582003", "0929582003", "(92) 958-20-03"} {
+		_, err = s.RegisterAccount(phone)
+		if err != ErrPhoneNumberRegistred {
+			t.Errorf("RegisterAccount(%q): error = %v, want %v", phone, err, ErrPhoneNumberRegistred)
+		}
+	}
+	for _, phone := range []types.Phone{"", "+992-CALL-ME", "12", "+0929582003"} {
+		_, err = s.RegisterAccount(phone)
+		if err != ErrBadPhone {
+			t.Errorf("RegisterAccount(%q): error = %v, want %v", phone, err, ErrBadPhone)
+		}
+	}
+
+	russian := &testService{Service: NewService(WithCountryCode("7"))}
+	account, err = russian.RegisterAccount("8 912 345-67-89")
+	if err != nil || account.Phone != "+789123456789" {
+		t.Errorf("RegisterAccount() with country code 7 = %v, %v", account, err)
+	}
+}
+
+func TestService_Import_normalizesPhone(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeTestFiles(t, map[string]string{
+		"accounts.dump": "#wallet-dump;2;accounts\n" +
+			"2;992938151007;100\n" +
+			"3;0938151003;100\n" +
+			"4;+992 93 815 1003;100\n" +
+			"5;not a phone;100\n",
+	})
+	err = s.Import(dir)
+	assertBadLines(t, err, []badLine{
+		{"accounts.dump", 2, ErrPhoneNumberRegistred},
+		{"accounts.dump", 4, ErrPhoneNumberRegistred},
+		{"accounts.dump", 5, ErrBadPhone},
+	})
+
+	dir = writeTestFiles(t, map[string]string{
+		"accounts.dump": "#wallet-dump;2;accounts\n3;0938151003;100\n",
+	})
+	err = s.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): error = %v", err)
+	}
+	account, err := s.FindAccountByID(3)
+	if err != nil || account.Phone != "+992938151003" {
+		t.Errorf("Import(): account = %v, %v, want the phone +992938151003", account, err)
+	}
+}
+
+func TestService_Import_preservesIDs(t *testing.T) {
+	s := newTestService()
+	_, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := writeTestFiles(t, map[string]string{
+		"accounts.dump": "5;+992938151003;100;\n",
+		"payments.dump": "fd573df8-9ba1-44f4-8fa1-4af3b3690656;5;10;auto;OK;\n",
+	})
+	err = s.Import(dir)
+	if err != nil {
+		t.Fatalf("Import(): error = %v", err)
+	}
+
+	payment, err := s.FindPaymentByID("fd573df8-9ba1-44f4-8fa1-4af3b3690656")
+	if err != nil {
+		t.Fatalf("FindPaymentByID(): error = %v", err)
+	}
+	account, err := s.FindAccountByID(payment.AccountID)
+	if err != nil {
+		t.Fatalf("FindAccountByID(): error = %v", err)
+	}
+	if account.Phone != "+992938151003" {
+		t.Errorf("Import(): payment belongs to %v, want +992938151003", account.Phone)
+	}
+
+	account, err = s.RegisterAccount("+992938151001")
+	if err != nil {
+		t.Fatalf("RegisterAccount(): error = %v", err)
+	}
+	if account.ID != 6 {
+		t.Errorf("RegisterAccount(): id = %v, want 6", account.ID)
+	}
+}
+
+func TestService_ImportMerge_strategies(t *testing.T) {
+	tests := []struct {
+		merge   MergeStrategy
+		err     error
+		balance types.Money
+	}{
+		{MergeFail, ErrRecordConflict, 0},
+		{MergeOverwrite, nil, 100},
+		{MergeKeep, nil, 0},
+	}
+
+	dir := writeTestFiles(t, map[string]string{
+		"accounts.dump": "1;" + string(defaultTestAccount.phone) + ";100;\n2;+992938151003;0;\n",
+	})
+	for _, tt := range tests {
+		s := newTestService()
+		account, err := s.RegisterAccount(defaultTestAccount.phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = s.ImportMerge(dir, FormatDump, tt.merge)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ImportMerge(%v): error = %v, want %v", tt.merge, err, tt.err)
+		}
+		if s.balance(account) != tt.balance {
+			t.Errorf("ImportMerge(%v): balance = %v, want %v", tt.merge, s.balance(account), tt.balance)
+		}
+	}
+}
+
+func TestService_ImportMerge_phoneConflict(t *testing.T) {
+	dir := writeTestFiles(t, map[string]string{
+		"accounts.dump": "2;" + string(defaultTestAccount.phone) + ";100;\n",
+	})
+	for _, merge := range []MergeStrategy{MergeFail, MergeOverwrite, MergeKeep} {
+		s := newTestService()
+		_, err := s.RegisterAccount(defaultTestAccount.phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = s.ImportMerge(dir, FormatDump, merge)
+		if !errors.Is(err, ErrPhoneNumberRegistred) {
+			t.Errorf("ImportMerge(%v): error = %v, want %v", merge, err, ErrPhoneNumberRegistred)
+		}
+	}
+}
+
+func TestService_DepositFrom(t *testing.T) {
+	now := time.Date(2021, 3, 8, 10, 0, 0, 0, time.UTC)
+	s := &testService{Service: NewService(WithClock(func() time.Time { return now }))}
+
+	account, err := s.RegisterAccount(defaultTestAccount.phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposit, err := s.DepositFrom(account.ID, 100, "terminal", "T-17")
+	if err != nil {
+		t.Fatalf("DepositFrom(): error = %v", err)
+	}
+	want := types.Deposit{
+		ID:        deposit.ID,
+		AccountID: account.ID,
+		Amount:    100,
+		Source:    "terminal",
+		Reference: "T-17",
+		Created:   now,
+	}
+	if deposit.ID == "" || *deposit != want {
+		t.Errorf("DepositFrom() = %+v, want %+v", deposit, want)
+	}
+	if s.balance(account) != 100 {
+		t.Errorf("DepositFrom(): balance = %v, want 100", s.balance(account))
+	}
+
+	err = s.Deposit(account.ID, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deposits, err := s.ExportAccountDeposits(account.ID)
+	if err != nil {
+		t.Fatalf("ExportAccountDeposits(): error = %v", err)
+	}
+	if len(deposits) != 2 || deposits[0] != want || deposits[1].Amount != 50 || deposits[1].Source != "" {
+		t.Errorf("ExportAccountDeposits() = %+v, want the deposits of 100 and 50", deposits)
+	}
+
+	_, err = s.DepositFrom(account.ID, 0, "terminal", "")
+	if err != ErrAmountMustBePositive {
+		t.Errorf("DepositFrom(0): error = %v, want %v", err, ErrAmountMustBePositive)
+	}
+	_, err = s.DepositFrom(account.ID+1, 100, "", "")
+	if err != ErrAccountNotFound {
+		t.Errorf("DepositFrom(unknown account): error = %v, want %v", err, ErrAccountNotFound)
+	}
+}
+
+func TestService_FilterDeposits(t *testing.T) {
+	s := newTestService()
+
+	first, err := s.addAccountWithBalance(defaultTestAccount.phone, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.addAccountWithBalance("+992938151003", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		err = s.Deposit(first.ID, types.Money(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, goroutines := range []int{0, 1, 3, 10} {
+		deposits, err := s.FilterDeposits(first.ID, goroutines)
+		if err != nil {
+			t.Fatalf("FilterDeposits(%v): error = %v", goroutines, err)
+		}
+		amounts := []types.Money{}
+		for _, deposit := range deposits {
+			amounts = append(amounts, deposit.Amount)
+		}
+		if !reflect.DeepEqual(amounts, []types.Money{10, 1, 2, 3, 4, 5}) {
+			t.Errorf("FilterDeposits(%v): amounts = %v, want 10, 1, 2, 3, 4, 5", goroutines, amounts)
+		}
+	}
+
+	deposits, err := s.FilterDeposits(second.ID, 2)
+	if err != nil || len(deposits) != 1 || deposits[0].Amount != 20 {
+		t.Errorf("FilterDeposits() = %+v, %v, want one deposit of 20", deposits, err)
+	}
+	_, err = s.FilterDeposits(second.ID+1, 2)
+	if err != ErrAccountNotFound {
+		t.Errorf("FilterDeposits(unknown account): error = %v, want %v", err, ErrAccountNotFound)
+	}
+}
+
+func TestService_Complete(t *testing.T) {
+	created := time.Date(2021, 3, 8, 10, 0, 0, 0, time.UTC)
+	now := created
+	s := &testService{Service: NewService(WithClock(func() time.Time { return now }))}
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := payments[0]
+	if !payment.Created.Equal(created) || !payment.Updated.Equal(created) {
+		t.Errorf("Pay(): times = %v, %v, want %v", payment.Created, payment.Updated, created)
+	}
+
+	now = created.Add(time.Hour)
+	err = s.Complete(payment.ID)
+	if err != nil {
+		t.Fatalf("Complete(): error = %v", err)
+	}
+	payment, err = s.FindPaymentByID(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != types.PaymentStatusOk {
+		t.Errorf("Complete(): status = %v, want %v", payment.Status, types.PaymentStatusOk)
+	}
+	if !payment.Created.Equal(created) || !payment.Updated.Equal(now) {
+		t.Errorf("Complete(): times = %v, %v, want %v, %v", payment.Created, payment.Updated, created, now)
+	}
+	balance := s.balance(account)
+
+	for name, op := range map[string]func(string) error{"Complete": s.Complete, "Reject": s.Reject} {
+		err = op(payment.ID)
+		transition := &TransitionError{}
+		if !errors.As(err, &transition) || !errors.Is(err, ErrIllegalTransition) {
+			t.Fatalf("%v() of a completed payment: error = %v, want *TransitionError", name, err)
+		}
+		if transition.From != types.PaymentStatusOk || transition.PaymentID != payment.ID {
+			t.Errorf("%v(): error = %+v", name, transition)
+		}
+	}
+	if s.balance(account) != balance {
+		t.Errorf("Reject() of a completed payment: balance = %v, want %v", s.balance(account), balance)
+	}
+
+	err = s.Complete(uuid.New().String())
+	if err != ErrPaymentNotFound {
+		t.Errorf("Complete(unknown): error = %v, want %v", err, ErrPaymentNotFound)
+	}
+}
+
+func TestService_Reject_twice(t *testing.T) {
+	s := newTestService()
+
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Reject(payments[0].ID)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Reject() twice: error = %v, want %v", err, ErrIllegalTransition)
+	}
+	err = s.Complete(payments[0].ID)
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Errorf("Complete() of a rejected payment: error = %v, want %v", err, ErrIllegalTransition)
+	}
+	if s.balance(account) != defaultTestAccount.balance {
+		t.Errorf("Reject() twice: balance = %v, want %v", s.balance(account), defaultTestAccount.balance)
+	}
+}
+
+func TestService_PayIn(t *testing.T) {
+	s := newTestService()
+
+	account, err := s.RegisterAccountIn(defaultTestAccount.phone, types.CurrencyUSD)
+	if err != nil {
+		t.Fatalf("RegisterAccountIn(): error = %v", err)
+	}
+	if account.Currency != types.CurrencyUSD {
+		t.Errorf("RegisterAccountIn(): currency = %v, want %v", account.Currency, types.CurrencyUSD)
+	}
+	err = s.Deposit(account.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment, err := s.PayIn(account.ID, 10, types.CurrencyUSD, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatalf("PayIn(): error = %v", err)
+	}
+	if payment.Currency != types.CurrencyUSD {
+		t.Errorf("PayIn(): currency = %v, want %v", payment.Currency, types.CurrencyUSD)
+	}
+	_, err = s.PayIn(account.ID, 10, types.CurrencyTJS, types.PaymentCategoryFood)
+	if err != ErrCurrencyMismatch {
+		t.Errorf("PayIn(TJS): error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	repeated, err := s.Repeat(payment.ID)
+	if err != nil || repeated.Currency != types.CurrencyUSD {
+		t.Errorf("Repeat(): payment = %v, error = %v, want one in USD", repeated, err)
+	}
+	if s.balance(account) != 80 {
+		t.Errorf("PayIn(): balance = %v, want 80", s.balance(account))
+	}
+
+	_, err = s.RegisterAccountIn("+992938151003", "XXX")
+	if err != ErrUnknownCurrency {
+		t.Errorf("RegisterAccountIn(XXX): error = %v, want %v", err, ErrUnknownCurrency)
+	}
+	registered, err := s.RegisterAccount("+992938151003")
+	if err != nil || registered.Currency != types.DefaultCurrency {
+		t.Errorf("RegisterAccount(): account = %v, error = %v, want one in %v", registered, err, types.DefaultCurrency)
+	}
+}
@@ -0,0 +1,107 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+var ErrAccountFrozen = errors.New("account is frozen")
+var ErrAccountBlocked = errors.New("account is blocked")
+var ErrAccountClosed = errors.New("account is closed")
+var ErrBalanceNotZero = errors.New("account balance is not zero")
+
+// ErrIllegalAccountTransition is matched by every *AccountTransitionError.
+var ErrIllegalAccountTransition = errors.New("illegal account status transition")
+
+// AccountTransitionError is returned when an account can not go from its
+// status to the one asked for, e.g. by activating a closed account.
+type AccountTransitionError struct {
+	AccountID int64
+	From      types.AccountStatus
+	To        types.AccountStatus
+}
+
+func (e *AccountTransitionError) Error() string {
+	return fmt.Sprintf("account %d: can not go from %s to %s", e.AccountID, e.From, e.To)
+}
+
+func (e *AccountTransitionError) Is(target error) bool {
+	return target == ErrIllegalAccountTransition
+}
+
+// FreezeAccount stops the account from paying, being paid into and taking
+// part in transfers until ActivateAccount, at the request of its owner.
+func (s *Service) FreezeAccount(accountID int64) error {
+	return s.setStatus(accountID, types.AccountStatusFrozen)
+}
+
+// BlockAccount is FreezeAccount for holds put by the wallet, on a
+// compromised account for example.
+func (s *Service) BlockAccount(accountID int64) error {
+	return s.setStatus(accountID, types.AccountStatusBlocked)
+}
+
+// ActivateAccount lifts a freeze or a block.
+func (s *Service) ActivateAccount(accountID int64) error {
+	return s.setStatus(accountID, types.AccountStatusActive)
+}
+
+// CloseAccount closes the account for good. Its balance must be zero, which
+// gives ErrBalanceNotZero otherwise.
+func (s *Service) CloseAccount(accountID int64) error {
+	return s.setStatus(accountID, types.AccountStatusClosed)
+}
+
+// setStatus moves the account to status next.
+func (s *Service) setStatus(accountID int64, next types.AccountStatus) error {
+	account, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !accountStatus(account).CanBecome(next) {
+		return &AccountTransitionError{AccountID: accountID, From: accountStatus(account), To: next}
+	}
+	if next == types.AccountStatusClosed && account.Balance != 0 {
+		return ErrBalanceNotZero
+	}
+
+	updated := *account
+	updated.Status = next
+	return s.commit(change{op: "status", accounts: []*types.Account{&updated}})
+}
+
+// accountStatus returns the status of the account. Accounts made without
+// one are active.
+func accountStatus(account *types.Account) types.AccountStatus {
+	if account.Status == "" {
+		return types.AccountStatusActive
+	}
+	return account.Status
+}
+
+// checkActive returns the error for money moving in or out of an account
+// that is not active.
+func checkActive(account *types.Account) error {
+	switch accountStatus(account) {
+	case types.AccountStatusFrozen:
+		return ErrAccountFrozen
+	case types.AccountStatusBlocked:
+		return ErrAccountBlocked
+	case types.AccountStatusClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+// checkNotClosed returns ErrAccountClosed for a closed account, which money
+// given back or charged by the wallet must not reach either.
+func checkNotClosed(account *types.Account) error {
+	if accountStatus(account) == types.AccountStatusClosed {
+		return ErrAccountClosed
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_FreezeAccount_BlockAccount(t *testing.T) {
+	s := newTestService()
+	account, payments, err := s.addAcoount(defaultTestAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.addAccountWithBalance("+992938151003", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Status != types.AccountStatusActive {
+		t.Errorf("RegisterAccount(): status = %v, want %v", account.Status, types.AccountStatusActive)
+	}
+
+	for _, tt := range []struct {
+		change func(accountID int64) error
+		err    error
+	}{
+		{s.FreezeAccount, ErrAccountFrozen},
+		{s.BlockAccount, ErrAccountBlocked},
+	} {
+		err = tt.change(account.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.Pay(account.ID, 10, "auto")
+		if err != tt.err {
+			t.Errorf("Pay(): error = %v, want %v", err, tt.err)
+		}
+		err = s.Deposit(account.ID, 10)
+		if err != tt.err {
+			t.Errorf("Deposit(): error = %v, want %v", err, tt.err)
+		}
+		_, err = s.Transfer(account.ID, other.ID, 10)
+		if err != tt.err {
+			t.Errorf("Transfer() from: error = %v, want %v", err, tt.err)
+		}
+		_, err = s.Transfer(other.ID, account.ID, 10)
+		if err != tt.err {
+			t.Errorf("Transfer() to: error = %v, want %v", err, tt.err)
+		}
+		_, err = s.Repeat(payments[0].ID)
+		if err != tt.err {
+			t.Errorf("Repeat(): error = %v, want %v", err, tt.err)
+		}
+	}
+
+	// money given back still reaches a blocked account
+	err = s.Reject(payments[0].ID)
+	if err != nil {
+		t.Errorf("Reject(): error = %v", err)
+	}
+
+	err = s.ActivateAccount(account.ID)
+	if err != nil {
+		t.Fatalf("ActivateAccount(): error = %v", err)
+	}
+	_, err = s.Pay(account.ID, 10, "auto")
+	if err != nil {
+		t.Errorf("Pay() after ActivateAccount(): error = %v", err)
+	}
+	err = s.ActivateAccount(account.ID)
+	if !errors.Is(err, ErrIllegalAccountTransition) {
+		t.Errorf("ActivateAccount() twice: error = %v, want %v", err, ErrIllegalAccountTransition)
+	}
+}
+
+func TestService_CloseAccount(t *testing.T) {
+	s := newTestService()
+	account, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err := s.Pay(account.ID, 40, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.CloseAccount(account.ID)
+	if err != ErrBalanceNotZero {
+		t.Errorf("CloseAccount(): error = %v, want %v", err, ErrBalanceNotZero)
+	}
+	_, err = s.Pay(account.ID, 60, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.CloseAccount(account.ID)
+	if err != nil {
+		t.Fatalf("CloseAccount(): error = %v", err)
+	}
+
+	err = s.Deposit(account.ID, 10)
+	if err != ErrAccountClosed {
+		t.Errorf("Deposit(): error = %v, want %v", err, ErrAccountClosed)
+	}
+	err = s.Reject(payment.ID)
+	if err != ErrAccountClosed {
+		t.Errorf("Reject(): error = %v, want %v", err, ErrAccountClosed)
+	}
+	_, err = s.Refund(payment.ID, 10)
+	if err != ErrAccountClosed {
+		t.Errorf("Refund(): error = %v, want %v", err, ErrAccountClosed)
+	}
+	err = s.ActivateAccount(account.ID)
+	var transition *AccountTransitionError
+	if !errors.As(err, &transition) || transition.From != types.AccountStatusClosed {
+		t.Errorf("ActivateAccount(): error = %v, want an *AccountTransitionError from CLOSED", err)
+	}
+	if s.balance(account) != 0 {
+		t.Errorf("balance = %v, want 0", s.balance(account))
+	}
+}
@@ -0,0 +1,218 @@
+package wallet
+
+import (
+	"errors"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+	"github.com/google/uuid"
+)
+
+var ErrSameAccount = errors.New("can not transfer to the same account")
+var ErrTransferRejected = errors.New("transfer is already rejected")
+
+// Transfer moves amount, in the currency of the sender, from one account to
+// another. Both balances change together, and the ledger gets one posting
+// debiting the sender and crediting the recipient, referring to the returned
+// transfer. Between accounts in different currencies the amount is converted
+// at the rate of the service's RateProvider and goes through LedgerExchange;
+// without a RateProvider such a transfer gives ErrCurrencyMismatch.
+func (s *Service) Transfer(fromAccountID int64, toAccountID int64, amount types.Money) (*types.Transfer, error) {
+	if amount <= 0 {
+		return nil, ErrAmountMustBePositive
+	}
+	if fromAccountID == toAccountID {
+		return nil, ErrSameAccount
+	}
+
+	accounts, unlock, err := s.lockAccounts(fromAccountID, toAccountID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	for _, account := range accounts {
+		err = checkActive(account)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	overdraftFee, err := s.overdraftFee(accounts[0], amount)
+	if err != nil {
+		return nil, err
+	}
+	need, err := amount.Add(overdraftFee)
+	if err != nil {
+		return nil, err
+	}
+	funds, err := available(accounts[0])
+	if err != nil {
+		return nil, err
+	}
+	if funds < need {
+		return nil, ErrNotEnoughBalance
+	}
+
+	transfer := &types.Transfer{
+		ID:            uuid.New().String(),
+		FromAccountID: fromAccountID,
+		ToAccountID:   toAccountID,
+		Amount:        amount,
+		Status:        types.PaymentStatusOk,
+		Created:       s.now(),
+		ToAmount:      amount,
+	}
+	if accounts[0].Currency != accounts[1].Currency {
+		transfer.Rate, err = s.rate(accounts[0].Currency, accounts[1].Currency)
+		if err != nil {
+			return nil, err
+		}
+		transfer.ToAmount, err = convert(amount, accounts[0].Currency, accounts[1].Currency, transfer.Rate)
+		if err != nil {
+			return nil, err
+		}
+		if transfer.ToAmount <= 0 {
+			return nil, ErrAmountMustBePositive
+		}
+	}
+
+	from := *accounts[0]
+	from.Balance, err = accounts[0].Balance.Sub(transfer.Amount)
+	if err != nil {
+		return nil, err
+	}
+	to := *accounts[1]
+	to.Balance, err = accounts[1].Balance.Add(transfer.ToAmount)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkBalanceLimit(accounts[1], to.Balance)
+	if err != nil {
+		return nil, err
+	}
+	fees, err := s.chargeOverdraft(&from, overdraftFee, transfer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.commit(change{
+		op:        "transfer",
+		accounts:  []*types.Account{&from, &to},
+		transfers: []*types.Transfer{transfer},
+		postings:  append(s.transferPostings(from.ID, to.ID, transfer), fees...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+func (s *Service) FindTransferByID(transferID string) (*types.Transfer, error) {
+	return s.repo().Transfers().ByID(transferID)
+}
+
+// RejectTransfer reverses a transfer, like Reject does for payments: the
+// amount goes back from the recipient to the sender, along with the
+// overdraft fee the sender was charged, and the transfer is marked as
+// failed. The recipient must still have the money in its balance, without
+// going into its overdraft. A transfer across currencies is reversed at the
+// rate it was made at.
+func (s *Service) RejectTransfer(transferID string) error {
+	found, err := s.FindTransferByID(transferID)
+	if err != nil {
+		return err
+	}
+
+	accounts, unlock, err := s.lockAccounts(found.FromAccountID, found.ToAccountID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the transfer is guarded by the locks of its accounts, read it again
+	stored, err := s.FindTransferByID(transferID)
+	if err != nil {
+		return err
+	}
+	if stored.Status == types.PaymentStatusFail {
+		return ErrTransferRejected
+	}
+	for _, account := range accounts {
+		err = checkNotClosed(account)
+		if err != nil {
+			return err
+		}
+	}
+	if accounts[1].Balance < stored.ToAmount {
+		return ErrNotEnoughBalance
+	}
+
+	transfer := *stored
+	transfer.Status = types.PaymentStatusFail
+	from := *accounts[0]
+	from.Balance, err = accounts[0].Balance.Add(transfer.Amount)
+	if err != nil {
+		return err
+	}
+	charges, err := s.refundOverdraft(&from, transfer.ID)
+	if err != nil {
+		return err
+	}
+	err = s.checkBalanceLimit(accounts[0], from.Balance)
+	if err != nil {
+		return err
+	}
+	to := *accounts[1]
+	to.Balance, err = accounts[1].Balance.Sub(transfer.ToAmount)
+	if err != nil {
+		return err
+	}
+
+	return s.commit(change{
+		op:        "reject transfer",
+		accounts:  []*types.Account{&from, &to},
+		transfers: []*types.Transfer{&transfer},
+		postings:  append(s.transferPostings(to.ID, from.ID, &transfer), charges...),
+	})
+}
+
+// transferPostings returns the postings moving the money of the transfer from
+// debit to credit, which are its accounts one way or the other. Money
+// changing currency goes through LedgerExchange.
+func (s *Service) transferPostings(debit int64, credit int64, transfer *types.Transfer) []*types.Posting {
+	if transfer.Rate == "" {
+		return []*types.Posting{s.newPosting(debit, credit, transfer.Amount, transfer.ID)}
+	}
+
+	debited, credited := transfer.Amount, transfer.ToAmount
+	if debit == transfer.ToAccountID {
+		debited, credited = credited, debited
+	}
+	return []*types.Posting{
+		s.newPosting(debit, LedgerExchange, debited, transfer.ID),
+		s.newPosting(LedgerExchange, credit, credited, transfer.ID),
+	}
+}
+
+// ExportAccountTransfers returns the transfers from and to the account,
+// oldest first.
+func (s *Service) ExportAccountTransfers(accountID int64) ([]types.Transfer, error) {
+	_, unlock, err := s.lockAccount(accountID)
+	if err != nil {
+		return nil, ErrAccountNotFound
+	}
+	defer unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transfers, err := s.repo().Transfers().ByAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	accountTransfers := []types.Transfer{}
+	for _, transfer := range transfers {
+		accountTransfers = append(accountTransfers, *transfer)
+	}
+	return accountTransfers, nil
+}
@@ -0,0 +1,205 @@
+package wallet
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+func TestService_Transfer(t *testing.T) {
+	s := newTestService()
+
+	from, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992938151003", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer, err := s.Transfer(from.ID, to.ID, 30)
+	if err != nil {
+		t.Fatalf("Transfer(): error = %v", err)
+	}
+	if transfer.FromAccountID != from.ID || transfer.ToAccountID != to.ID ||
+		transfer.Amount != 30 || transfer.Status != types.PaymentStatusOk {
+		t.Errorf("Transfer() = %+v", transfer)
+	}
+	if s.balance(from) != 70 || s.balance(to) != 40 {
+		t.Errorf("Transfer(): balances = %v, %v, want 70, 40", s.balance(from), s.balance(to))
+	}
+
+	for _, account := range []*types.Account{from, to} {
+		statement, err := s.ExportAccountStatement(account.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := statement[len(statement)-1]
+		if last.Kind != types.TransactionTransfer || last.ID != transfer.ID || last.Balance != s.balance(account) {
+			t.Errorf("ExportAccountStatement(%v): last = %+v, want the transfer", account.ID, last)
+		}
+		transfers, err := s.ExportAccountTransfers(account.ID)
+		if err != nil || len(transfers) != 1 || transfers[0] != *transfer {
+			t.Errorf("ExportAccountTransfers(%v) = %+v, %v, want the transfer", account.ID, transfers, err)
+		}
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+}
+
+func TestService_Transfer_fail(t *testing.T) {
+	s := newTestService()
+
+	from, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992938151003", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to int64
+		amount   types.Money
+		err      error
+	}{
+		{"not enough balance", from.ID, to.ID, 101, ErrNotEnoughBalance},
+		{"unknown sender", 9, to.ID, 10, ErrAccountNotFound},
+		{"unknown recipient", from.ID, 9, 10, ErrAccountNotFound},
+		{"same account", from.ID, from.ID, 10, ErrSameAccount},
+		{"zero amount", from.ID, to.ID, 0, ErrAmountMustBePositive},
+	}
+	for _, tt := range tests {
+		_, err := s.Transfer(tt.from, tt.to, tt.amount)
+		if err != tt.err {
+			t.Errorf("Transfer(%v): error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if s.balance(from) != 100 || s.balance(to) != 10 {
+		t.Errorf("Transfer(): balances = %v, %v, want them unchanged", s.balance(from), s.balance(to))
+	}
+}
+
+func TestService_RejectTransfer(t *testing.T) {
+	s := newTestService()
+
+	from, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.addAccountWithBalance("+992938151003", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := s.Transfer(from.ID, to.ID, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.RejectTransfer(transfer.ID)
+	if err != nil {
+		t.Fatalf("RejectTransfer(): error = %v", err)
+	}
+	if s.balance(from) != 100 || s.balance(to) != 10 {
+		t.Errorf("RejectTransfer(): balances = %v, %v, want 100, 10", s.balance(from), s.balance(to))
+	}
+	transfer, err = s.FindTransferByID(transfer.ID)
+	if err != nil || transfer.Status != types.PaymentStatusFail {
+		t.Errorf("RejectTransfer(): status = %v, want %v", transfer.Status, types.PaymentStatusFail)
+	}
+
+	err = s.RejectTransfer(transfer.ID)
+	if err != ErrTransferRejected {
+		t.Errorf("RejectTransfer() twice: error = %v, want %v", err, ErrTransferRejected)
+	}
+	err = s.RejectTransfer("unknown")
+	if err != ErrTransferNotFound {
+		t.Errorf("RejectTransfer(unknown): error = %v, want %v", err, ErrTransferNotFound)
+	}
+
+	// the recipient has spent the money already
+	transfer, err = s.Transfer(from.ID, to.ID, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Pay(to.ID, 35, types.PaymentCategoryFood)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.RejectTransfer(transfer.ID)
+	if err != ErrNotEnoughBalance {
+		t.Errorf("RejectTransfer(spent): error = %v, want %v", err, ErrNotEnoughBalance)
+	}
+
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+}
+
+func TestService_concurrentTransfer(t *testing.T) {
+	s := newTestService()
+
+	first, err := s.addAccountWithBalance(defaultTestAccount.phone, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.addAccountWithBalance("+992938151003", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// transfers both ways at once must neither deadlock nor lose money
+	firstID, secondID := first.ID, second.ID
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.Transfer(firstID, secondID, 7)
+		}()
+		go func() {
+			defer wg.Done()
+			s.Transfer(secondID, firstID, 5)
+		}()
+	}
+	wg.Wait()
+
+	unlock, err := s.lockAll(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := s.balance(first) + s.balance(second)
+	unlock()
+	if total != 2000 {
+		t.Errorf("Transfer(): balances add up to %v, want 2000", total)
+	}
+	if err := s.CheckLedger(); err != nil {
+		t.Errorf("CheckLedger(): error = %v", err)
+	}
+}
+
+func TestService_Transfer_currencyMismatch(t *testing.T) {
+	s := newTestService()
+
+	from, err := s.addAccountWithBalance(defaultTestAccount.phone, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := s.RegisterAccountIn("+992938151003", types.CurrencyUSD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Transfer(from.ID, to.ID, 30)
+	if err != ErrCurrencyMismatch {
+		t.Errorf("Transfer(TJS to USD): error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if s.balance(from) != 100 || s.balance(to) != 0 {
+		t.Errorf("Transfer(): balances = %v, %v, want them unchanged", s.balance(from), s.balance(to))
+	}
+}
@@ -0,0 +1,304 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Eydzhpee08/wallet/pkg/types"
+)
+
+var ErrUnknownCategory = errors.New("unknown payment category")
+var ErrUnknownStatus = errors.New("unknown payment status")
+var ErrUnknownAccountStatus = errors.New("unknown account status")
+var ErrDuplicateRecord = errors.New("record id is repeated in the file")
+var ErrEmptyField = errors.New("required field is empty")
+var ErrUnknownOperation = errors.New("unknown operation")
+var ErrUnknownCurrency = errors.New("unknown currency")
+var ErrUnknownPeriod = errors.New("unknown limit period")
+var ErrNegativeLimit = errors.New("limit is negative")
+
+// RecordError is a bad record of an imported file.
+type RecordError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// ImportError lists every bad record found by Import, in file order. Nothing
+// is imported if there is any.
+type ImportError struct {
+	Records []*RecordError
+}
+
+func (e *ImportError) Error() string {
+	lines := make([]string, len(e.Records))
+	for i, record := range e.Records {
+		lines[i] = record.Error()
+	}
+	return fmt.Sprintf("%d bad records: %s", len(e.Records), strings.Join(lines, "; "))
+}
+
+// Is reports whether any record failed with target, so errors.Is works on
+// the whole report.
+func (e *ImportError) Is(target error) bool {
+	for _, record := range e.Records {
+		if errors.Is(record.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// add records a bad line of file.
+func (e *ImportError) add(file string, line int, err error) {
+	e.Records = append(e.Records, &RecordError{File: file, Line: line, Err: err})
+}
+
+// err returns the report as an error, nil if nothing is wrong. Records are
+// put in line order, as a file is checked in more than one pass.
+func (e *ImportError) err() error {
+	if len(e.Records) == 0 {
+		return nil
+	}
+
+	files := map[string]int{}
+	for _, record := range e.Records {
+		if _, ok := files[record.File]; !ok {
+			files[record.File] = len(files)
+		}
+	}
+	sort.SliceStable(e.Records, func(i, j int) bool {
+		a, b := e.Records[i], e.Records[j]
+		if a.File != b.File {
+			return files[a.File] < files[b.File]
+		}
+		return a.Line < b.Line
+	})
+	return e
+}
+
+// origin keeps where the records parsed from one file come from, so that
+// checks made after parsing can point at the right line.
+type origin struct {
+	file   string
+	report *ImportError
+	// lines holds the line of every parsed record, in order
+	lines []int
+}
+
+func newOrigin(file string, report *ImportError) *origin {
+	return &origin{file: file, report: report}
+}
+
+// parsed notes that a record was parsed from line.
+func (o *origin) parsed(line int) {
+	o.lines = append(o.lines, line)
+}
+
+// fail reports a bad line.
+func (o *origin) fail(line int, err error) {
+	o.report.add(o.file, line, err)
+}
+
+// failRecord reports the i-th parsed record.
+func (o *origin) failRecord(i int, err error) {
+	o.fail(o.lines[i], err)
+}
+
+// knownCategory reports whether category is one of the predefined ones, which
+// spending limits may be set on. Case is ignored, as payments have long been
+// made with "auto" and the like.
+func knownCategory(category types.PaymentCategory) bool {
+	for _, known := range []types.PaymentCategory{
+		types.PaymentCategoryAuto,
+		types.PaymentCategoryFun,
+		types.PaymentCategoryIT,
+		types.PaymentCategoryFood,
+	} {
+		if strings.EqualFold(string(category), string(known)) {
+			return true
+		}
+	}
+	return false
+}
+
+func knownStatus(status types.PaymentStatus) bool {
+	switch status {
+	case types.PaymentStatusOk, types.PaymentStatusFail, types.PaymentStatusInProgress:
+		return true
+	}
+	return false
+}
+
+// checkPayment checks the fields of a payment that do not depend on other
+// records. Any category goes, as Pay takes any.
+func checkPayment(payment *types.Payment) error {
+	switch {
+	case payment.ID == "":
+		return fmt.Errorf("id: %w", ErrEmptyField)
+	case payment.Amount <= 0:
+		return ErrAmountMustBePositive
+	case !knownStatus(payment.Status):
+		return fmt.Errorf("%w %q", ErrUnknownStatus, payment.Status)
+	case !payment.Currency.Known():
+		return fmt.Errorf("%w %q", ErrUnknownCurrency, payment.Currency)
+	case payment.OriginalCurrency != "":
+		err := checkConversion(payment.OriginalAmount, payment.OriginalCurrency, payment.Rate)
+		if err != nil {
+			return err
+		}
+	}
+	return checkFees(payment)
+}
+
+// checkFees checks the fee lines of a payment, which need to say where
+// they went.
+func checkFees(payment *types.Payment) error {
+	for _, fee := range payment.Fees {
+		if fee.Amount <= 0 {
+			return fmt.Errorf("fee %q: %w", fee.Name, ErrAmountMustBePositive)
+		}
+	}
+	if len(payment.Fees) > 0 && payment.FeeAccount == 0 {
+		return fmt.Errorf("fee account: %w", ErrEmptyField)
+	}
+	return nil
+}
+
+// checkConversion checks what a payment converted from another currency
+// keeps of it.
+func checkConversion(original types.Money, currency types.Currency, rate types.Rate) error {
+	switch {
+	case original <= 0:
+		return fmt.Errorf("original amount: %w", ErrAmountMustBePositive)
+	case !currency.Known():
+		return fmt.Errorf("%w %q", ErrUnknownCurrency, currency)
+	}
+	_, err := parseRate(rate)
+	return err
+}
+
+// checkFavorite is checkPayment for favorites.
+func checkFavorite(favorite *types.Favorite) error {
+	switch {
+	case favorite.ID == "":
+		return fmt.Errorf("id: %w", ErrEmptyField)
+	case favorite.Amount <= 0:
+		return ErrAmountMustBePositive
+	}
+	return nil
+}
+
+// checkPosting checks the fields of a posting that do not depend on other
+// records.
+func checkPosting(posting *types.Posting) error {
+	switch {
+	case posting.ID == "":
+		return fmt.Errorf("id: %w", ErrEmptyField)
+	case posting.Amount <= 0:
+		return ErrAmountMustBePositive
+	case posting.Debit == posting.Credit:
+		return ErrSameLedgerAccount
+	case posting.Created.IsZero():
+		return fmt.Errorf("created: %w", ErrEmptyField)
+	}
+	return nil
+}
+
+// checkDeposit is checkPayment for deposits.
+func checkDeposit(deposit *types.Deposit) error {
+	switch {
+	case deposit.ID == "":
+		return fmt.Errorf("id: %w", ErrEmptyField)
+	case deposit.Amount <= 0:
+		return ErrAmountMustBePositive
+	case deposit.Created.IsZero():
+		return fmt.Errorf("created: %w", ErrEmptyField)
+	}
+	return nil
+}
+
+// checkTransfer is checkPayment for transfers.
+func checkTransfer(transfer *types.Transfer) error {
+	switch {
+	case transfer.ID == "":
+		return fmt.Errorf("id: %w", ErrEmptyField)
+	case transfer.Amount <= 0 || transfer.ToAmount <= 0:
+		return ErrAmountMustBePositive
+	case transfer.FromAccountID == transfer.ToAccountID:
+		return ErrSameAccount
+	case transfer.Status != types.PaymentStatusOk && transfer.Status != types.PaymentStatusFail:
+		return fmt.Errorf("%w %q", ErrUnknownStatus, transfer.Status)
+	case transfer.Created.IsZero():
+		return fmt.Errorf("created: %w", ErrEmptyField)
+	case transfer.Rate != "":
+		_, err := parseRate(transfer.Rate)
+		return err
+	}
+	return nil
+}
+
+// checkKey is checkPayment for idempotency keys.
+func checkKey(key *types.IdempotencyKey) error {
+	switch {
+	case key.Key == "":
+		return fmt.Errorf("key: %w", ErrEmptyField)
+	case !knownKeyOperation(key.Operation):
+		return fmt.Errorf("%w %q", ErrUnknownOperation, key.Operation)
+	case key.RecordID == "":
+		return fmt.Errorf("record id: %w", ErrEmptyField)
+	case key.Created.IsZero():
+		return fmt.Errorf("created: %w", ErrEmptyField)
+	}
+	return nil
+}
+
+// checkRefund is checkPayment for refunds.
+func checkRefund(refund *types.Refund) error {
+	switch {
+	case refund.ID == "":
+		return fmt.Errorf("id: %w", ErrEmptyField)
+	case refund.PaymentID == "":
+		return fmt.Errorf("payment id: %w", ErrEmptyField)
+	case refund.Amount <= 0:
+		return ErrAmountMustBePositive
+	case refund.Created.IsZero():
+		return fmt.Errorf("created: %w", ErrEmptyField)
+	}
+	return nil
+}
+
+// checkLimits checks the limits of an account. Nil limits, leaving the
+// account to the default ones, are fine.
+func checkLimits(limits *types.Limits) error {
+	if limits == nil {
+		return nil
+	}
+	switch {
+	case limits.MaxPayment < 0:
+		return fmt.Errorf("max payment: %w", ErrNegativeLimit)
+	case limits.MaxBalance < 0:
+		return fmt.Errorf("max balance: %w", ErrNegativeLimit)
+	}
+	for _, limit := range limits.Spending {
+		switch {
+		case limit.Period != types.LimitPeriodDay && limit.Period != types.LimitPeriodMonth:
+			return fmt.Errorf("%w %q", ErrUnknownPeriod, limit.Period)
+		case limit.Category != "" && !knownCategory(limit.Category):
+			return fmt.Errorf("%w %q", ErrUnknownCategory, limit.Category)
+		case limit.Amount <= 0:
+			return fmt.Errorf("spending limit: %w", ErrAmountMustBePositive)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+type badLine struct {
+	file string
+	line int
+	err  error
+}
+
+// assertBadLines checks that err reports exactly the want lines; a nil err
+// in want matches any error.
+func assertBadLines(t *testing.T, err error, want []badLine) {
+	t.Helper()
+
+	report, ok := err.(*ImportError)
+	if !ok {
+		t.Fatalf("error = %v, want *ImportError", err)
+	}
+	got := []string{}
+	for _, record := range report.Records {
+		got = append(got, fmt.Sprintf("%s:%d", record.File, record.Line))
+	}
+	lines := []string{}
+	for _, line := range want {
+		lines = append(lines, fmt.Sprintf("%s:%d", line.file, line.line))
+	}
+	if !reflect.DeepEqual(got, lines) {
+		t.Fatalf("bad lines = %v, want %v (%v)", got, lines, err)
+	}
+	for i, line := range want {
+		if line.err != nil && !errors.Is(report.Records[i].Err, line.err) {
+			t.Errorf("%v:%v: error = %v, want %v", line.file, line.line, report.Records[i].Err, line.err)
+		}
+	}
+}
+
+func TestService_Import_reportsEveryBadLine(t *testing.T) {
+	s := newTestService()
+
+	dir := writeTestFiles(t, map[string]string{
+		"accounts.dump": "1;+992938151007;100;\n" +
+			"\n" +
+			"2;+992938151003\n" +
+			"3;+992938151001;lots;\n" +
+			"4;+992938151007;0;\n",
+		"payments.dump": "#wallet-dump;2;payments\n" +
+			"p1;1;10;auto;OK\n" +
+			"p2;1;10;;OK\n" +
+			"p3;1;10;Fun;DONE\n" +
+			"p4;9;10;IT;OK\n" +
+			"p1;1;10;auto;OK\n" +
+			"p5;1;0;auto;OK\n",
+		"favorites.dump": "f1;1;home;10;Food;\n",
+	})
+
+	err := s.Import(dir)
+	assertBadLines(t, err, []badLine{
+		{"accounts.dump", 3, ErrDumpRecord},
+		{"accounts.dump", 4, nil},
+		{"accounts.dump", 5, ErrPhoneNumberRegistred},
+		{"payments.dump", 4, ErrUnknownStatus},
+		{"payments.dump", 5, ErrAccountNotFound},
+		{"payments.dump", 6, ErrDuplicateRecord},
+		{"payments.dump", 7, ErrAmountMustBePositive},
+	})
+
+	accounts, _ := s.repo().Accounts().All()
+	if len(accounts) != 0 {
+		t.Errorf("Import(): accounts = %v, want none", accounts)
+	}
+}
+
+func TestService_Import_badRecords(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []badLine
+	}{
+		{
+			name: "account currency",
+			files: map[string]string{
+				"accounts.dump": "#wallet-dump;2;accounts\n" +
+					"1;+992938151007;100;USD\n" +
+					"2;+992938151003;100;XXX\n" +
+					"3;+992938151004;100\n",
+				"payments.dump": "#wallet-dump;2;payments\n" +
+					"p1;1;10;auto;OK;;;USD\n" +
+					"p2;1;10;auto;OK;;;usd\n",
+			},
+			want: []badLine{
+				{"accounts.dump", 3, ErrUnknownCurrency},
+				{"payments.dump", 3, ErrUnknownCurrency},
+			},
+		},
+		{
+			name: "limits",
+			files: map[string]string{
+				"accounts.dump": "#wallet-dump;2;accounts\n" +
+					"1;+992938151007;100;TJS;0;0;DAY:auto:100,MONTH::1000\n" +
+					"2;+992938151003;100;TJS;-1;0;\n" +
+					"3;+992938151004;100;TJS;0;0;WEEK::100\n" +
+					"4;+992938151005;100;TJS;0;0;DAY:100\n" +
+					"5;+992938151006;100;TJS;;;\n",
+			},
+			want: []badLine{
+				{"accounts.dump", 3, ErrNegativeLimit},
+				{"accounts.dump", 4, ErrUnknownPeriod},
+				{"accounts.dump", 5, ErrDumpRecord},
+			},
+		},
+		{
+			name: "profile",
+			files: map[string]string{
+				"accounts.dump": "#wallet-dump;2;accounts\n" +
+					"1;+992938151007;100;TJS;;;;;ACTIVE;Rustam;rustam@example.tj;tg;2026-03-01T10:00:00Z\n" +
+					"2;+992938151003;100;TJS;;;;;ACTIVE;Madina;madina;;\n" +
+					"3;+992938151004;100;TJS;;;;;ACTIVE;;;tajik\n" +
+					"4;+992938151005;100;TJS;;;;;ACTIVE;;;;yesterday\n",
+			},
+			want: []badLine{
+				{"accounts.dump", 3, ErrBadEmail},
+				{"accounts.dump", 4, ErrBadLocale},
+				{"accounts.dump", 5, nil},
+			},
+		},
+		{
+			name: "account status",
+			files: map[string]string{
+				"accounts.dump": "#wallet-dump;2;accounts\n" +
+					"1;+992938151007;100;TJS;;;;;FROZEN\n" +
+					"2;+992938151003;100;TJS;;;;;SLEEPING\n" +
+					"3;+992938151004;100;TJS\n",
+			},
+			want: []badLine{
+				{"accounts.dump", 3, ErrUnknownAccountStatus},
+			},
+		},
+		{
+			name: "deposit",
+			files: map[string]string{
+				"accounts.dump": "1;+992938151007;100;\n",
+				"deposits.dump": "#wallet-dump;2;deposits\n" +
+					"d1;1;100;card;;2021-03-08T10:00:00Z\n" +
+					"d2;2;100;card;;2021-03-08T10:00:00Z\n" +
+					"d3;1;0;card;;2021-03-08T10:00:00Z\n" +
+					"d1;1;100;card;;2021-03-08T10:00:00Z\n" +
+					"d4;1;100;card;;\n",
+			},
+			want: []badLine{
+				{"deposits.dump", 3, ErrAccountNotFound},
+				{"deposits.dump", 4, ErrAmountMustBePositive},
+				{"deposits.dump", 5, ErrDuplicateRecord},
+				{"deposits.dump", 6, nil},
+			},
+		},
+		{
+			name: "posting",
+			files: map[string]string{
+				"accounts.dump": "1;+992938151007;100;\n",
+				"postings.dump": "#wallet-dump;2;postings\n" +
+					"a;-1;1;100;;2021-03-08T10:00:00Z\n" +
+					"b;-9;1;100;;2021-03-08T10:00:00Z\n" +
+					"c;1;1;100;;2021-03-08T10:00:00Z\n" +
+					"d;-1;2;100;;2021-03-08T10:00:00Z\n" +
+					"e;-1;1;100;;yesterday\n",
+			},
+			want: []badLine{
+				{"postings.dump", 3, ErrUnknownLedgerAccount},
+				{"postings.dump", 4, ErrSameLedgerAccount},
+				{"postings.dump", 5, ErrAccountNotFound},
+				{"postings.dump", 6, nil},
+			},
+		},
+		{
+			name: "transfer",
+			files: map[string]string{
+				"accounts.dump": "1;+992938151007;100;\n2;+992938151003;0;\n",
+				"transfers.dump": "#wallet-dump;2;transfers\n" +
+					"t1;1;2;10;OK;2021-03-08T10:00:00Z\n" +
+					"t2;1;1;10;OK;2021-03-08T10:00:00Z\n" +
+					"t3;1;3;10;OK;2021-03-08T10:00:00Z\n" +
+					"t4;1;2;10;INPROGRESS;2021-03-08T10:00:00Z\n",
+			},
+			want: []badLine{
+				{"transfers.dump", 3, ErrSameAccount},
+				{"transfers.dump", 4, ErrAccountNotFound},
+				{"transfers.dump", 5, ErrUnknownStatus},
+			},
+		},
+		{
+			name: "key",
+			files: map[string]string{
+				"accounts.dump": "1;+992938151007;100;\n",
+				"keys.dump": "#wallet-dump;2;keys\n" +
+					"k1;1;pay;10;p1;2021-03-08T10:00:00Z\n" +
+					"k2;2;pay;10;p1;2021-03-08T10:00:00Z\n" +
+					"k3;1;refund;10;p1;2021-03-08T10:00:00Z\n" +
+					"k1;1;pay;10;p1;2021-03-08T10:00:00Z\n" +
+					";1;pay;10;p1;2021-03-08T10:00:00Z\n",
+			},
+			want: []badLine{
+				{"keys.dump", 3, ErrAccountNotFound},
+				{"keys.dump", 4, ErrUnknownOperation},
+				{"keys.dump", 5, ErrDuplicateRecord},
+				{"keys.dump", 6, ErrEmptyField},
+			},
+		},
+		{
+			name: "refund",
+			files: map[string]string{
+				"accounts.dump": "1;+992938151007;100;\n2;+992938151003;100;\n",
+				"payments.dump": "#wallet-dump;2;payments\n" +
+					"p1;1;30;auto;OK;2021-03-08T09:00:00Z;2021-03-08T09:00:00Z;TJS\n" +
+					"p2;2;30;auto;OK;2021-03-08T09:00:00Z;2021-03-08T09:00:00Z;TJS\n",
+				"refunds.dump": "#wallet-dump;2;refunds\n" +
+					"r1;p1;1;10;2021-03-08T10:00:00Z\n" +
+					"r2;p1;3;10;2021-03-08T10:00:00Z\n" +
+					"r3;p1;1;0;2021-03-08T10:00:00Z\n" +
+					"r1;p1;1;10;2021-03-08T10:00:00Z\n" +
+					"r4;;1;10;2021-03-08T10:00:00Z\n" +
+					"r5;p9;1;10;2021-03-08T10:00:00Z\n" +
+					"r6;p2;1;10;2021-03-08T10:00:00Z\n" +
+					"r7;p1;1;21;2021-03-08T10:00:00Z\n" +
+					"r8;p1;1;20;2021-03-08T10:00:00Z\n",
+			},
+			want: []badLine{
+				{"refunds.dump", 3, ErrAccountNotFound},
+				{"refunds.dump", 4, ErrAmountMustBePositive},
+				{"refunds.dump", 5, ErrDuplicateRecord},
+				{"refunds.dump", 6, ErrEmptyField},
+				{"refunds.dump", 7, ErrPaymentNotFound},
+				{"refunds.dump", 8, ErrRefundAccountMismatch},
+				{"refunds.dump", 9, ErrRefundTooLarge},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService()
+			err := s.Import(writeTestFiles(t, tt.files))
+			assertBadLines(t, err, tt.want)
+		})
+	}
+}
+
+func TestService_ImportFormat_reportsLines(t *testing.T) {
+	tests := []struct {
+		format Format
+		files  map[string]string
+		want   []badLine
+	}{
+		{
+			format: FormatJSON,
+			files: map[string]string{
+				"accounts.json": "[\n" +
+					"  {\"id\": 1, \"phone\": \"+992938151007\", \"balance\": 100},\n" +
+					"  {\"id\": 2, \"phone\": \"+992938151003\", \"balance\": \"ten\"},\n" +
+					"  {\"id\": 3, \"phone\": \"\", \"balance\": 0}\n" +
+					"]\n",
+			},
+			want: []badLine{{"accounts.json", 3, nil}, {"accounts.json", 4, ErrEmptyField}},
+		},
+		{
+			format: FormatJSONL,
+			files: map[string]string{
+				"accounts.jsonl": "{\"id\": 1, \"phone\": \"+992938151007\", \"balance\": 100}\n" +
+					"\n" +
+					"{\"id\": 2, \"phone\": \n",
+				"payments.jsonl": "{\"id\": \"p1\", \"account_id\": 1, \"amount\": 10, \"category\": \"auto\", \"status\": \"OK\"}\n" +
+					"{\"id\": \"p2\", \"account_id\": 1, \"amount\": 10, \"category\": \"auto\", \"status\": \"\"}\n",
+			},
+			want: []badLine{{"accounts.jsonl", 3, nil}, {"payments.jsonl", 2, ErrUnknownStatus}},
+		},
+		{
+			format: FormatCSV,
+			files: map[string]string{
+				"accounts.csv":  "id,phone,balance,currency,max_payment,max_balance,spending,overdraft,status,name,email,locale,created\n1,+992938151007,100\n",
+				"favorites.csv": "\nid,account_id,name,amount,category\nf1,1,\"two\nlines\",10,auto\n\r\nf2,1,short\n\nf3,2,home,10,auto\n",
+			},
+			want: []badLine{
+				{"favorites.csv", 6, ErrDumpRecord},
+				{"favorites.csv", 8, ErrAccountNotFound},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			s := newTestService()
+			err := s.ImportFormat(writeTestFiles(t, tt.files), tt.format)
+			assertBadLines(t, err, tt.want)
+		})
+	}
+}